@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"go-markdown-confluence/internal/confluence"
 	"go-markdown-confluence/pkg/markdownconfluence"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 func main() {
@@ -18,25 +20,30 @@ func main() {
 	convertInput := convertCmd.String("input", "", "Markdown input (file or string)")
 	convertOutput := convertCmd.String("output", "", "Output file (optional)")
 	convertDryRun := convertCmd.Bool("dry-run", false, "Skip Confluence upload and output JSON")
+	convertFormat := convertCmd.String("format", "adf", "Format to convert to (adf or storage)")
 
 	postCmd := flag.NewFlagSet("post", flag.ExitOnError)
 	postInput := postCmd.String("input", "", "Markdown input (file or string)")
-	postURL := postCmd.String("url", "", "Confluence URL")
-	postUsername := postCmd.String("username", "", "Confluence username")
-	postAPIToken := postCmd.String("token", "", "Confluence API token")
+	postConnection := connectionFlags(postCmd)
 	postSpaceKey := postCmd.String("space", "", "Confluence space key")
 	postTitle := postCmd.String("title", "", "Page title")
 	postParentID := postCmd.String("parent", "", "Parent page ID (optional)")
+	postValidate := postCmd.Bool("validate", false, "Check the page against the ADF schema before publishing")
 
 	dirCmd := flag.NewFlagSet("directory", flag.ExitOnError)
 	dirPath := dirCmd.String("path", "", "Path to the directory containing Markdown files")
 	dirMapping := dirCmd.String("mapping", "", "Path to JSON file with file mappings")
-	dirURL := dirCmd.String("url", "", "Confluence URL")
-	dirUsername := dirCmd.String("username", "", "Confluence username")
-	dirAPIToken := dirCmd.String("token", "", "Confluence API token")
+	dirConnection := connectionFlags(dirCmd)
 	dirSpaceKey := dirCmd.String("space", "", "Confluence space key (default: DOCS)")
 	dirDryRun := dirCmd.Bool("dry-run", false, "Skip uploading to Confluence")
 	dirOutputDir := dirCmd.String("output-directory", "", "Directory to save converted JSON files (when using --dry-run)")
+	dirEmoji := dirCmd.String("emoji", "", "Path to JSON file with custom emoji (optional)")
+	dirStrictADF := dirCmd.Bool("strict-adf", false, "Fail on invalid raw ADF instead of publishing it as code")
+	dirValidate := dirCmd.Bool("validate", false, "Check every page against the ADF schema before publishing")
+
+	validateCmd := flag.NewFlagSet("validate", flag.ExitOnError)
+	validatePath := validateCmd.String("path", "", "Markdown file or directory to validate")
+	validateEmoji := validateCmd.String("emoji", "", "Path to JSON file with custom emoji (optional)")
 
 	flag.Parse()
 
@@ -58,13 +65,18 @@ func main() {
 	switch os.Args[1] {
 	case "convert":
 		convertCmd.Parse(os.Args[2:])
-		handleConvert(*convertInput, *convertOutput, *convertDryRun)
+		handleConvert(*convertInput, *convertOutput, *convertDryRun, markdownconfluence.Format(*convertFormat))
 	case "post":
 		postCmd.Parse(os.Args[2:])
-		handlePost(*postInput, *postURL, *postUsername, *postAPIToken, *postSpaceKey, *postTitle, *postParentID)
+		handlePost(*postInput, *postConnection, *postSpaceKey, *postTitle, *postParentID, *postValidate)
 	case "directory":
 		dirCmd.Parse(os.Args[2:])
-		handleDirectory(*dirPath, *dirMapping, *dirURL, *dirUsername, *dirAPIToken, *dirSpaceKey, *dirDryRun, *dirOutputDir)
+		handleDirectory(*dirPath, *dirMapping, *dirConnection, *dirSpaceKey, *dirDryRun, *dirOutputDir, *dirEmoji, *dirStrictADF, *dirValidate)
+	case "validate":
+		validateCmd.Parse(os.Args[2:])
+		if !handleValidate(*validatePath, *validateEmoji) {
+			os.Exit(1)
+		}
 	case "help":
 		printHelp()
 	case "version":
@@ -76,7 +88,90 @@ func main() {
 	}
 }
 
-func handleConvert(input, output string, dryRun bool) {
+// connection holds the flags that select and authenticate against a
+// Confluence site.
+type connection struct {
+	url        string
+	username   string
+	token      string
+	api        string
+	deployment string
+	format     string
+	verbose    bool
+
+	transport *confluence.Transport // Retries and rate limit of the requests, shared by copies
+}
+
+// connectionFlags defines the connection flags of a command.
+func connectionFlags(cmd *flag.FlagSet) *connection {
+	conn := &connection{transport: confluence.NewTransport()}
+	cmd.StringVar(&conn.url, "url", "", "Confluence URL, with the context path such as /wiki or /confluence")
+	cmd.StringVar(&conn.username, "username", "", "Confluence username (Cloud only)")
+	cmd.StringVar(&conn.token, "token", "", "Confluence API token, or personal access token for Data Center")
+	cmd.StringVar(&conn.api, "api", "v1", "Confluence REST API to use (v1 or v2)")
+	cmd.StringVar(&conn.deployment, "deployment", "cloud", "Kind of Confluence site (cloud or datacenter)")
+	cmd.StringVar(&conn.format, "format", "", "Format to publish pages in (adf or storage; default: storage for datacenter, adf otherwise)")
+	cmd.IntVar(&conn.transport.MaxRetries, "retries", confluence.DefaultMaxRetries, "Retries of a request that is rate limited or fails with a transient error")
+	cmd.Float64Var(&conn.transport.RequestsPerSecond, "rate-limit", 10, "Most requests per second sent to Confluence (0 for no limit)")
+	cmd.BoolVar(&conn.verbose, "verbose", false, "Report retries, rate limiting and request counts")
+	return conn
+}
+
+// complete reports whether the flags are enough to connect to the site.
+func (c connection) complete() bool {
+	if c.url == "" || c.token == "" {
+		return false
+	}
+	return c.username != "" || confluence.Deployment(c.deployment) == confluence.DataCenter
+}
+
+// client returns the client for the site, publishing page content in the
+// representation of the format.
+func (c connection) client() (confluence.ConfluenceAPI, error) {
+	if c.verbose {
+		c.transport.Logf = func(format string, args ...interface{}) {
+			fmt.Printf(format+"\n", args...)
+		}
+	}
+
+	var representation string
+	switch markdownconfluence.Format(c.format) {
+	case markdownconfluence.FormatADF:
+		representation = confluence.RepresentationADF
+	case markdownconfluence.FormatStorage:
+		representation = confluence.RepresentationStorage
+	}
+
+	return confluence.NewClient(confluence.Config{
+		BaseURL:        c.url,
+		Username:       c.username,
+		APIToken:       c.token,
+		API:            confluence.APIVersion(c.api),
+		Deployment:     confluence.Deployment(c.deployment),
+		Representation: representation,
+		Transport:      c.transport,
+	})
+}
+
+// report prints the counts of the requests sent to the site, when verbose.
+func (c connection) report() {
+	if c.verbose {
+		fmt.Printf("Confluence requests: %s\n", c.transport.Stats())
+	}
+}
+
+// apply sets the options that depend on the site: the kind of site, the
+// format of the pages and the context path that links between pages start
+// with.
+func (c connection) apply(options *markdownconfluence.ConvertDirectoryOptions) {
+	options.Deployment = markdownconfluence.Deployment(c.deployment)
+	options.Format = markdownconfluence.Format(c.format)
+	if u, err := url.Parse(c.url); err == nil && u.Host != "" {
+		options.BaseURL = strings.TrimSuffix(u.Path, "/")
+	}
+}
+
+func handleConvert(input, output string, dryRun bool, format markdownconfluence.Format) {
 	if input == "" {
 		fmt.Println("Error: No input specified")
 		return
@@ -94,6 +189,7 @@ func handleConvert(input, output string, dryRun bool) {
 
 		options := markdownconfluence.DefaultConvertOptions()
 		options.DryRun = true // Always dry run for convert command
+		options.Format = format
 
 		// If output is specified, use it as the output directory
 		if output != "" {
@@ -137,6 +233,7 @@ func handleConvert(input, output string, dryRun bool) {
 
 		options := markdownconfluence.DefaultConvertOptions()
 		options.DryRun = true
+		options.Format = format
 
 		if output != "" {
 			options.OutputDirectory = filepath.Dir(output)
@@ -155,16 +252,20 @@ func handleConvert(input, output string, dryRun bool) {
 
 	// If output is specified but we haven't written to it yet
 	if output != "" && !dryRun {
-		result, err := convert(markdownContent)
+		result, err := convert(markdownContent, format)
 		if err != nil {
 			fmt.Printf("Error during conversion: %v\n", err)
 			return
 		}
 
-		// If the output doesn't have a .json extension, add it
+		// If the output doesn't have the extension of the format, add it
+		extension := ".json"
+		if format == markdownconfluence.FormatStorage {
+			extension = ".xhtml"
+		}
 		outputPath := output
-		if filepath.Ext(outputPath) != ".json" {
-			outputPath += ".json"
+		if filepath.Ext(outputPath) != extension {
+			outputPath += extension
 		}
 
 		if err := os.WriteFile(outputPath, []byte(result), 0644); err != nil {
@@ -174,17 +275,21 @@ func handleConvert(input, output string, dryRun bool) {
 		fmt.Printf("Conversion saved to: %s\n", outputPath)
 	} else if markdownContent != "" && !dryRun {
 		// Display the result if we're not using dry-run
-		result, err := convert(markdownContent)
+		result, err := convert(markdownContent, format)
 		if err != nil {
 			fmt.Printf("Error during conversion: %v\n", err)
 			return
 		}
-		fmt.Println("Converted Markdown to ADF JSON:")
+		if format == markdownconfluence.FormatStorage {
+			fmt.Println("Converted Markdown to storage format:")
+		} else {
+			fmt.Println("Converted Markdown to ADF JSON:")
+		}
 		fmt.Println(result)
 	}
 }
 
-func convert(markdownContent string) (string, error) {
+func convert(markdownContent string, format markdownconfluence.Format) (string, error) {
 	if markdownContent == "" {
 		return "", nil
 	}
@@ -207,6 +312,7 @@ func convert(markdownContent string) (string, error) {
 	dummyClient := &OutputCapturer{}
 	options := markdownconfluence.DefaultConvertOptions()
 	options.DryRun = true
+	options.Format = format
 
 	err = markdownconfluence.ConvertDirectoryWithOptions(tempDir, fileMapping, dummyClient, options, "DOCS")
 	if err != nil {
@@ -216,15 +322,20 @@ func convert(markdownContent string) (string, error) {
 	return dummyClient.GetMarkdown(), nil
 }
 
-func handlePost(input, confluenceURL, username, apiToken, spaceKey, title, parentID string) {
-	if input == "" || confluenceURL == "" || username == "" || apiToken == "" || spaceKey == "" || title == "" {
+func handlePost(input string, conn connection, spaceKey, title, parentID string, validate bool) {
+	if input == "" || !conn.complete() || spaceKey == "" || title == "" {
 		fmt.Println("Error: Missing required parameters")
 		return
 	}
 
 	var tempFile string
 
-	client := confluence.NewConfluenceClient(confluenceURL, username, apiToken)
+	client, err := conn.client()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	defer conn.report()
 
 	if _, statErr := os.Stat(input); statErr == nil {
 		fmt.Printf("Converting and posting file: %s\n", input)
@@ -235,8 +346,10 @@ func handlePost(input, confluenceURL, username, apiToken, spaceKey, title, paren
 
 		options := markdownconfluence.DefaultConvertOptions()
 		options.DefaultSpaceKey = spaceKey
+		conn.apply(options)
+		options.Validate = validate
 
-		err := markdownconfluence.ConvertDirectoryWithOptions(filepath.Dir(input), fileMapping, client, options, "DOCS")
+		err := markdownconfluence.ConvertDirectoryWithOptions(filepath.Dir(input), fileMapping, client, options, spaceKey)
 		if err != nil {
 			fmt.Printf("Error during conversion or posting: %v\n", err)
 			return
@@ -265,8 +378,10 @@ func handlePost(input, confluenceURL, username, apiToken, spaceKey, title, paren
 
 		options := markdownconfluence.DefaultConvertOptions()
 		options.DefaultSpaceKey = spaceKey
+		conn.apply(options)
+		options.Validate = validate
 
-		err = markdownconfluence.ConvertDirectoryWithOptions(tempDir, fileMapping, client, options, "DOCS")
+		err = markdownconfluence.ConvertDirectoryWithOptions(tempDir, fileMapping, client, options, spaceKey)
 		if err != nil {
 			fmt.Printf("Error during conversion or posting: %v\n", err)
 			return
@@ -276,7 +391,7 @@ func handlePost(input, confluenceURL, username, apiToken, spaceKey, title, paren
 	}
 }
 
-func handleDirectory(dirPath, mappingPath, confluenceURL, username, apiToken, spaceKey string, dryRun bool, outputDir string) {
+func handleDirectory(dirPath, mappingPath string, conn connection, spaceKey string, dryRun bool, outputDir, emojiPath string, strictADF, validate bool) {
 	fmt.Println("Starting directory conversion process...")
 
 	if dirPath == "" {
@@ -371,50 +486,40 @@ func handleDirectory(dirPath, mappingPath, confluenceURL, username, apiToken, sp
 
 	fmt.Printf("File mapping contains %d entries\n", len(fileMapping))
 
-	// Ensure consistent usage of the ConfluenceAPI interface
-	var client confluence.ConfluenceAPI = confluence.NewConfluenceClient("", "", "")
-	if !dryRun && confluenceURL != "" && username != "" && apiToken != "" {
-		client = confluence.NewConfluenceClient(confluenceURL, username, apiToken)
+	if !dryRun && !conn.complete() {
+		fmt.Println("Error: --url and --token, and --username for Confluence Cloud, are required unless --dry-run is set")
+		return
 	}
+	client, err := conn.client()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	defer conn.report()
 
 	options := markdownconfluence.DefaultConvertOptions()
 	options.DryRun = dryRun
 	options.OutputDirectory = outputDir
 	options.DefaultSpaceKey = spaceKey
+	conn.apply(options)
+	options.Warn = func(message string) {
+		fmt.Printf("Warning: %s\n", message)
+	}
+	options.Render.StrictADF = strictADF
+	options.Validate = validate
 
-	fmt.Printf("Converting with options: DryRun=%v, OutputDirectory=%s\n", options.DryRun, options.OutputDirectory)
-
-	for oldPath, newTitle := range fileMapping {
-		if !dryRun {
-			// Check if a page with the old title exists
-			oldTitle := filepath.Base(oldPath)
-			page, err := client.GetPageByTitle(spaceKey, oldTitle)
-			if err != nil {
-				fmt.Printf("Error checking for existing page: %v\n", err)
-				continue
-			}
-
-			if page != nil {
-				// Update the page title and parent if it exists
-				fmt.Printf("Updating page '%s' to new title '%s'\n", oldTitle, newTitle)
-				err = client.UpdatePage(page.ID, newTitle, page.Body.Storage.Value, spaceKey, page.Version.Number+1)
-				if err != nil {
-					fmt.Printf("Error updating page: %v\n", err)
-					continue
-				}
-			} else {
-				// Create a new page if it doesn't exist
-				fmt.Printf("Creating new page '%s'\n", newTitle)
-				_, err = client.CreatePage(spaceKey, newTitle, "", "")
-				if err != nil {
-					fmt.Printf("Error creating page: %v\n", err)
-					continue
-				}
-			}
+	if emojiPath != "" {
+		customEmoji, err := markdownconfluence.LoadEmojiFile(emojiPath)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
 		}
+		options.Render.Emoji = customEmoji
 	}
 
-	err = markdownconfluence.ConvertDirectoryWithOptions(dirPath, fileMapping, client, options, "DOCS")
+	fmt.Printf("Converting with options: DryRun=%v, OutputDirectory=%s\n", options.DryRun, options.OutputDirectory)
+
+	err = markdownconfluence.ConvertDirectoryWithOptions(dirPath, fileMapping, client, options, spaceKey)
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		return
@@ -436,20 +541,104 @@ func handleDirectory(dirPath, mappingPath, confluenceURL, username, apiToken, sp
 	fmt.Println("Conversion completed successfully")
 }
 
+// handleValidate converts a Markdown file, or every Markdown file of a
+// directory, and prints where the result breaks the ADF schema. It returns
+// false when the files could not be validated or have violations.
+func handleValidate(path, emojiPath string) bool {
+	if path == "" {
+		fmt.Println("Error: Path is required")
+		return false
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		fmt.Printf("Error: Path not accessible: %v\n", err)
+		return false
+	}
+
+	render := markdownconfluence.DefaultRenderOptions()
+	if emojiPath != "" {
+		customEmoji, err := markdownconfluence.LoadEmojiFile(emojiPath)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return false
+		}
+		render.Emoji = customEmoji
+	}
+
+	violations := make(map[string][]markdownconfluence.Violation)
+	var files []string
+	if info.IsDir() {
+		options := markdownconfluence.DefaultConvertOptions()
+		options.DryRun = true
+		options.Validate = true
+		options.Render = render
+		options.Warn = func(message string) {
+			fmt.Printf("Warning: %s\n", message)
+		}
+
+		results, err := markdownconfluence.ConvertDirectoryWithResults(path, nil, options)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return false
+		}
+		for _, result := range results {
+			files = append(files, result.FilePath)
+			violations[result.FilePath] = result.Violations
+		}
+	} else {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Printf("Error reading file: %v\n", err)
+			return false
+		}
+		fileViolations, err := markdownconfluence.ValidateWithOptions(string(content), render)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return false
+		}
+		files = append(files, path)
+		violations[path] = fileViolations
+	}
+
+	count := 0
+	for _, file := range files {
+		for _, violation := range violations[file] {
+			fmt.Printf("%s: %s\n", file, violation)
+			count++
+		}
+	}
+	if count > 0 {
+		fmt.Printf("%d ADF schema violations in %d files\n", count, len(files))
+		return false
+	}
+	fmt.Printf("Validated %d files: no ADF schema violations\n", len(files))
+	return true
+}
+
 func printHelp() {
 	fmt.Println("Markdown to Confluence Converter")
 	fmt.Println("--------------------------------")
 	fmt.Println("Usage:")
-	fmt.Println("  convert --input <markdown_or_file> [--output <file>] [--dry-run]")
-	fmt.Println("  post --input <markdown_or_file> --url <confluence_url> --username <username> --token <api_token> --space <space_key> --title <title> [--parent <parent_id>]")
-	fmt.Println("  directory --path <directory_path> [--mapping <mapping_file>] [--url <confluence_url> --username <username> --token <api_token> --space <space_key>] [--dry-run] [--output-directory <directory>]")
+	fmt.Println("  convert --input <markdown_or_file> [--output <file>] [--dry-run] [--format adf|storage]")
+	fmt.Println("  post --input <markdown_or_file> --url <confluence_url> [--username <username>] --token <api_token> --space <space_key> --title <title> [--parent <parent_id>] [--api v1|v2] [--deployment cloud|datacenter] [--format adf|storage] [--retries <n>] [--rate-limit <requests_per_second>] [--verbose] [--validate]")
+	fmt.Println("  directory --path <directory_path> [--mapping <mapping_file>] [--url <confluence_url> [--username <username>] --token <api_token> --space <space_key>] [--api v1|v2] [--deployment cloud|datacenter] [--format adf|storage] [--retries <n>] [--rate-limit <requests_per_second>] [--verbose] [--dry-run] [--output-directory <directory>] [--emoji <emoji_file>] [--strict-adf] [--validate]")
+	fmt.Println("  validate --path <markdown_file_or_directory> [--emoji <emoji_file>]")
 	fmt.Println("  help, -help     Show this help message")
 	fmt.Println("  version, -version    Show version information")
 	fmt.Println()
 	fmt.Println("Options:")
+	fmt.Println("  --api                 Confluence REST API to publish with: v1 (default) or the Cloud v2 API")
+	fmt.Println("  --deployment          cloud (default), or datacenter for Confluence Server/Data Center with a personal access token as --token")
+	fmt.Println("  --format              adf (ADF JSON), or storage for the XHTML storage format; directories default to storage on datacenter")
+	fmt.Println("  --retries             Retries of a rate-limited or transiently failing request (default: 4)")
+	fmt.Println("  --rate-limit          Most requests per second sent to Confluence (default: 10, 0 for no limit)")
+	fmt.Println("  --verbose             Print retries and waits for the rate limit, and the request counts at the end")
 	fmt.Println("  --dry-run             Skip uploading to Confluence")
 	fmt.Println("  --output-directory    Directory to save converted JSON files when using --dry-run")
 	fmt.Println("                        Files will be saved in a structure mirroring the original paths")
+	fmt.Println("  --emoji               JSON file mapping custom emoji shortcodes to Confluence emoji IDs")
+	fmt.Println("  --validate            Check every page against the bundled ADF schema and publish nothing if one fails (post, directory)")
 }
 
 func printVersion() {
@@ -494,9 +683,14 @@ func (c *OutputCapturer) DeletePage(pageID string) error {
 	return nil
 }
 
-func (c *OutputCapturer) UploadAttachment(pageID, filePath string) error {
+func (c *OutputCapturer) UploadAttachment(pageID, filePath string) (*confluence.Attachment, error) {
 	c.Output = append(c.Output, fmt.Sprintf("Would upload attachment %s to page %s", filePath, pageID))
-	return nil
+	return &confluence.Attachment{
+		ID:         "dummy-attachment-id",
+		Title:      filepath.Base(filePath),
+		FileID:     "dummy-file-id",
+		Collection: "contentId-" + pageID,
+	}, nil
 }
 
 func (c *OutputCapturer) GetMarkdown() string {
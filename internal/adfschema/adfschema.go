@@ -0,0 +1,409 @@
+// Package adfschema checks Atlassian Document Format (ADF) documents against
+// a bundled, condensed form of the ADF JSON schema. It covers the node types
+// Confluence accepts, the children each node allows, the attributes of nodes
+// and marks, and where marks may be placed.
+package adfschema
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"go-markdown-confluence/internal/confluence"
+)
+
+// Violation is a place where a document breaks the ADF schema.
+type Violation struct {
+	Path    string // JSON path of the node, attribute or mark, e.g. "$.content[2].attrs.level"
+	Message string // What is wrong
+	Line    int    // Markdown line the node was converted from, or 0 when unknown
+}
+
+// String formats the violation as "line 12: $.content[2]: message", leaving
+// out the line when it is unknown.
+func (v Violation) String() string {
+	if v.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", v.Line, v.Path, v.Message)
+	}
+	return fmt.Sprintf("%s: %s", v.Path, v.Message)
+}
+
+//go:embed schema.json
+var schemaJSON []byte
+
+// schema is the decoded form of schema.json.
+type schema struct {
+	Groups map[string][]string  `json:"groups"` // Node types by group name, referenced as "@name"
+	Nodes  map[string]*nodeSpec `json:"nodes"`
+	Marks  map[string]*markSpec `json:"marks"`
+}
+
+// nodeSpec describes a node type.
+type nodeSpec struct {
+	Content      []string             `json:"content"`      // Types and "@groups" of allowed children; none when empty
+	MinContent   int                  `json:"minContent"`   // Least number of children
+	ContentMarks *[]string            `json:"contentMarks"` // Marks allowed on children, when narrower than their own
+	Attrs        map[string]*attrSpec `json:"attrs"`        // Allowed attributes
+	Marks        []string             `json:"marks"`        // Allowed marks
+}
+
+// markSpec describes a mark type.
+type markSpec struct {
+	Attrs        map[string]*attrSpec `json:"attrs"`
+	CombinesWith []string             `json:"combinesWith"` // Only marks the mark may be combined with, when set
+}
+
+// attrSpec describes an attribute of a node or mark.
+type attrSpec struct {
+	Type     string        `json:"type"` // "string", "number", "integer", "boolean", "object" or "array"
+	Required bool          `json:"required"`
+	Enum     []interface{} `json:"enum"`
+	Minimum  *float64      `json:"minimum"`
+	Maximum  *float64      `json:"maximum"`
+}
+
+var (
+	loadOnce sync.Once
+	loaded   *schema
+)
+
+// bundled returns the bundled schema. The schema is part of the binary, so
+// failing to decode it is a programming error.
+func bundled() *schema {
+	loadOnce.Do(func() {
+		loaded = &schema{}
+		if err := json.Unmarshal(schemaJSON, loaded); err != nil {
+			panic(fmt.Sprintf("adfschema: invalid bundled schema: %v", err))
+		}
+	})
+	return loaded
+}
+
+// Validate checks a document and returns its violations, in document order.
+// lines maps JSON paths of nodes to the Markdown lines they were converted
+// from; a violation gets the line of its nearest node that has one. lines
+// may be nil.
+func Validate(doc *confluence.ADFDocument, lines map[string]int) ([]Violation, error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("error serializing document: %w", err)
+	}
+	return ValidateJSON(data, lines)
+}
+
+// ValidateJSON is like Validate for a document serialized as JSON.
+func ValidateJSON(data []byte, lines map[string]int) ([]Violation, error) {
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing document: %w", err)
+	}
+
+	v := &validator{schema: bundled()}
+	object, ok := doc.(map[string]interface{})
+	if !ok || object["type"] != "doc" {
+		v.report("$", "document is not a doc node")
+	} else {
+		v.node(object, "$", nil)
+	}
+
+	for i := range v.violations {
+		v.violations[i].Line = lineOf(v.violations[i].Path, lines)
+	}
+	return v.violations, nil
+}
+
+// ValidateNode checks a node and its children on their own, as when they
+// are about to be inserted into a document. path is the JSON path the
+// violations are reported under.
+func ValidateNode(node interface{}, path string) []Violation {
+	v := &validator{schema: bundled()}
+	object, ok := node.(map[string]interface{})
+	if !ok {
+		v.report(path, "node is not an object")
+	} else {
+		v.node(object, path, nil)
+	}
+	return v.violations
+}
+
+// IsInline reports whether nodes of the given type belong in inline content.
+func IsInline(nodeType string) bool {
+	return contains(bundled().Groups["inline"], nodeType)
+}
+
+// Allows reports whether nodes of type parent may hold children of type
+// child.
+func Allows(parent, child string) bool {
+	s := bundled()
+	spec, ok := s.Nodes[parent]
+	if !ok {
+		return false
+	}
+	for _, entry := range spec.Content {
+		if group, ok := strings.CutPrefix(entry, "@"); ok {
+			if contains(s.Groups[group], child) {
+				return true
+			}
+		} else if entry == child {
+			return true
+		}
+	}
+	return false
+}
+
+// lineOf returns the line of the node at path, or of its nearest ancestor
+// with a known line.
+func lineOf(path string, lines map[string]int) int {
+	for path != "" {
+		if line, ok := lines[path]; ok {
+			return line
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return 0
+}
+
+// validator collects the violations of a document.
+type validator struct {
+	schema     *schema
+	violations []Violation
+}
+
+func (v *validator) report(path, format string, args ...interface{}) {
+	v.violations = append(v.violations, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// expand returns the node types that a content list allows.
+func (v *validator) expand(content []string) map[string]bool {
+	allowed := make(map[string]bool)
+	for _, entry := range content {
+		if group, ok := strings.CutPrefix(entry, "@"); ok {
+			for _, t := range v.schema.Groups[group] {
+				allowed[t] = true
+			}
+			continue
+		}
+		allowed[entry] = true
+	}
+	return allowed
+}
+
+// node checks a node and its children. marks, when not nil, narrows the
+// marks the node may carry.
+func (v *validator) node(object map[string]interface{}, path string, marks *[]string) {
+	nodeType, _ := object["type"].(string)
+	spec, ok := v.schema.Nodes[nodeType]
+	switch {
+	case nodeType == "":
+		v.report(path, "node has no type")
+		return
+	case !ok:
+		v.report(path, "unknown node type %q", nodeType)
+		return
+	}
+
+	v.attrs(object["attrs"], spec.Attrs, path, nodeType+" node")
+
+	allowedMarks := spec.Marks
+	if marks != nil {
+		allowedMarks = *marks
+	}
+	v.marks(object["marks"], allowedMarks, path, nodeType)
+
+	if nodeType == "text" {
+		if text, _ := object["text"].(string); text == "" {
+			v.report(path+".text", "text node has no text")
+		}
+	}
+
+	raw, ok := object["content"]
+	if !ok {
+		if spec.MinContent > 0 {
+			v.report(path, "%s node has no content", nodeType)
+		}
+		return
+	}
+	content, ok := raw.([]interface{})
+	if !ok {
+		v.report(path+".content", "content is not an array")
+		return
+	}
+	if len(spec.Content) == 0 {
+		if len(content) > 0 {
+			v.report(path+".content", "%s node cannot have content", nodeType)
+		}
+		return
+	}
+	if len(content) < spec.MinContent {
+		v.report(path, "%s node has no content", nodeType)
+	}
+
+	allowed := v.expand(spec.Content)
+	for i, child := range content {
+		childPath := fmt.Sprintf("%s.content[%d]", path, i)
+		childObject, ok := child.(map[string]interface{})
+		if !ok {
+			v.report(childPath, "node is not an object")
+			continue
+		}
+		childType, _ := childObject["type"].(string)
+		if _, known := v.schema.Nodes[childType]; known && !allowed[childType] {
+			v.report(childPath, "%s node cannot contain %s node", nodeType, childType)
+			continue
+		}
+		v.node(childObject, childPath, spec.ContentMarks)
+	}
+}
+
+// marks checks the marks of a node of the given type.
+func (v *validator) marks(raw interface{}, allowed []string, path, nodeType string) {
+	if raw == nil {
+		return
+	}
+	list, ok := raw.([]interface{})
+	if !ok {
+		v.report(path+".marks", "marks is not an array")
+		return
+	}
+
+	var types []string
+	for i, item := range list {
+		markPath := fmt.Sprintf("%s.marks[%d]", path, i)
+		mark, _ := item.(map[string]interface{})
+		markType, _ := mark["type"].(string)
+		spec, ok := v.schema.Marks[markType]
+		switch {
+		case !ok:
+			v.report(markPath, "unknown mark type %q", markType)
+			continue
+		case !contains(allowed, markType):
+			v.report(markPath, "%s mark is not allowed on %s node", markType, nodeType)
+			continue
+		case contains(types, markType):
+			v.report(markPath, "duplicate %s mark", markType)
+			continue
+		}
+		v.attrs(mark["attrs"], spec.Attrs, markPath, markType+" mark")
+		types = append(types, markType)
+	}
+
+	for i, markType := range types {
+		spec := v.schema.Marks[markType]
+		if spec.CombinesWith == nil {
+			continue
+		}
+		for _, other := range types {
+			if other != markType && !contains(spec.CombinesWith, other) {
+				v.report(fmt.Sprintf("%s.marks[%d]", path, i), "%s mark cannot be combined with %s mark", markType, other)
+			}
+		}
+	}
+}
+
+// attrs checks the attributes of a node or mark, described by what.
+func (v *validator) attrs(raw interface{}, specs map[string]*attrSpec, path, what string) {
+	attrs, ok := raw.(map[string]interface{})
+	if !ok && raw != nil {
+		v.report(path+".attrs", "attrs is not an object")
+		return
+	}
+
+	names := make([]string, 0, len(specs))
+	for name := range specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := attrs[name]; !ok && specs[name].Required {
+			v.report(path+".attrs", "%s has no %s attribute", what, name)
+		}
+	}
+
+	names = names[:0]
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		attrPath := path + ".attrs." + name
+		spec, ok := specs[name]
+		if !ok {
+			v.report(attrPath, "%s has no attribute %s", what, name)
+			continue
+		}
+		if message := spec.check(attrs[name]); message != "" {
+			v.report(attrPath, "%s", message)
+		}
+	}
+}
+
+// check returns what is wrong with an attribute value, or "" if nothing.
+func (spec *attrSpec) check(value interface{}) string {
+	if value == nil {
+		if spec.Required {
+			return "value is null"
+		}
+		return ""
+	}
+
+	switch spec.Type {
+	case "string":
+		if _, ok := value.(string); !ok {
+			return "value is not a string"
+		}
+	case "number", "integer":
+		number, ok := value.(float64)
+		if !ok {
+			return "value is not a number"
+		}
+		if spec.Type == "integer" && number != math.Trunc(number) {
+			return "value is not an integer"
+		}
+		if spec.Minimum != nil && number < *spec.Minimum {
+			return fmt.Sprintf("value %v is less than %v", number, *spec.Minimum)
+		}
+		if spec.Maximum != nil && number > *spec.Maximum {
+			return fmt.Sprintf("value %v is greater than %v", number, *spec.Maximum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return "value is not a boolean"
+		}
+	case "object":
+		if _, ok := value.(map[string]interface{}); !ok {
+			return "value is not an object"
+		}
+	case "array":
+		if _, ok := value.([]interface{}); !ok {
+			return "value is not an array"
+		}
+	}
+
+	if spec.Enum != nil {
+		for _, allowed := range spec.Enum {
+			if value == allowed {
+				return ""
+			}
+		}
+		return fmt.Sprintf("value %v is not one of %v", value, spec.Enum)
+	}
+	return ""
+}
+
+// contains reports whether values contains value.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
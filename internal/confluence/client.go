@@ -2,85 +2,310 @@
 package confluence
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
 // Ensure ConfluenceClient is defined as part of the package
 var _ ConfluenceAPI = (*ConfluenceClient)(nil)
 
-// Define ConfluenceClient as a struct implementing the ConfluenceClient interface
+// ConfluenceClient publishes pages through the Confluence REST API. BaseURL
+// is the URL of the Confluence site, with its context path, such as
+// "https://example.atlassian.net/wiki" or "https://intranet/confluence".
 type ConfluenceClient struct {
 	BaseURL    string
 	Username   string
 	APIToken   string
 	HTTPClient *http.Client
+
+	// BearerToken, when set, is sent as a bearer token instead of the
+	// username and API token, as Confluence Server and Data Center expect
+	// of personal access tokens.
+	BearerToken string
+	// Representation is the representation of the page content passed to
+	// CreatePage and UpdatePage; RepresentationADF when empty.
+	Representation string
 }
 
-// Implement the NewConfluenceClient function to create a new ConfluenceClient instance
+// NewConfluenceClient creates a client that authenticates with the username
+// and API token of an Atlassian account. Its requests go through a
+// Transport with the default retries.
 func NewConfluenceClient(baseURL, username, apiToken string) *ConfluenceClient {
 	return &ConfluenceClient{
-		BaseURL:    baseURL,
-		Username:   username,
-		APIToken:   apiToken,
-		HTTPClient: &http.Client{},
+		BaseURL:  baseURL,
+		Username: username,
+		APIToken: apiToken,
+		HTTPClient: &http.Client{
+			Transport: NewTransport(),
+		},
 	}
 }
 
-// Implement the CreateParentPage method for the ConfluenceClient struct
+// NewDataCenterClient creates a client for Confluence Server or Data Center
+// that authenticates with a personal access token and publishes page
+// content in the storage representation.
+func NewDataCenterClient(baseURL, token string) *ConfluenceClient {
+	client := NewConfluenceClient(baseURL, "", "")
+	client.BearerToken = token
+	client.Representation = RepresentationStorage
+	return client
+}
+
+// Deployment is the kind of Confluence site a client publishes to.
+type Deployment string
+
+const (
+	// Cloud is Confluence Cloud, which takes ADF content and API tokens.
+	Cloud Deployment = "cloud"
+	// DataCenter is Confluence Server or Data Center, which takes storage
+	// format content and personal access tokens.
+	DataCenter Deployment = "datacenter"
+)
+
+// APIVersion selects the Confluence REST API that a client uses.
+type APIVersion string
+
+const (
+	// APIv1 uses the /rest/api/content endpoints of ConfluenceClient.
+	APIv1 APIVersion = "v1"
+	// APIv2 uses the Confluence Cloud /api/v2 endpoints of
+	// ConfluenceV2Client.
+	APIv2 APIVersion = "v2"
+)
+
+// Config selects and configures the client that NewClient returns.
+type Config struct {
+	BaseURL    string     // URL of the Confluence site with its context path, such as "https://example.atlassian.net/wiki"
+	Username   string     // Atlassian account email; not used for DataCenter
+	APIToken   string     // API token of the account, or the personal access token for DataCenter
+	API        APIVersion // REST API to use; APIv1 when empty
+	Deployment Deployment // Kind of site; Cloud when empty
+
+	// Representation is the representation of page content:
+	// RepresentationADF on Cloud and RepresentationStorage on DataCenter
+	// when empty.
+	Representation string
+	// Transport sends the requests of the client, retrying them and
+	// limiting their rate; NewTransport() when nil.
+	Transport *Transport
+}
+
+// NewClient returns the client for the site and REST API that config
+// selects.
+func NewClient(config Config) (ConfluenceAPI, error) {
+	switch config.Deployment {
+	case "", Cloud:
+	case DataCenter:
+		if config.API == APIv2 {
+			return nil, fmt.Errorf("the %s API is only available on Confluence Cloud", APIv2)
+		}
+		if config.Representation != "" && config.Representation != RepresentationStorage {
+			return nil, fmt.Errorf("Confluence Data Center only accepts the %s representation", RepresentationStorage)
+		}
+		client := NewDataCenterClient(config.BaseURL, config.APIToken)
+		config.apply(client)
+		return client, nil
+	default:
+		return nil, fmt.Errorf("unknown Confluence deployment %q, expected %q or %q", config.Deployment, Cloud, DataCenter)
+	}
+
+	switch config.Representation {
+	case "", RepresentationADF, RepresentationStorage:
+	default:
+		return nil, fmt.Errorf("unknown representation %q, expected %q or %q", config.Representation, RepresentationADF, RepresentationStorage)
+	}
+
+	switch config.API {
+	case "", APIv1:
+		client := NewConfluenceClient(config.BaseURL, config.Username, config.APIToken)
+		client.Representation = config.Representation
+		config.apply(client)
+		return client, nil
+	case APIv2:
+		client := NewConfluenceV2Client(config.BaseURL, config.Username, config.APIToken)
+		client.v1.Representation = config.Representation
+		config.apply(client.v1)
+		return client, nil
+	default:
+		return nil, fmt.Errorf("unknown Confluence API %q, expected %q or %q", config.API, APIv1, APIv2)
+	}
+}
+
+// apply gives client the transport of the config.
+func (config Config) apply(client *ConfluenceClient) {
+	if config.Transport != nil {
+		client.HTTPClient.Transport = config.Transport
+	}
+}
+
+// APIError is an error response of the Confluence REST API.
+type APIError struct {
+	Method     string // Method of the request
+	URL        string // URL of the request
+	StatusCode int    // HTTP status code of the response
+	Message    string // Error message of the response, or its body when it has none
+}
+
+func (e *APIError) Error() string {
+	message := fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		message += ": " + e.Message
+	}
+	return message
+}
+
+// maxErrorBody is the most of an error response body kept in an APIError.
+const maxErrorBody = 2048
+
+// emptyDocument is the ADF content of the pages created for folders.
+const emptyDocument = `{"type":"doc","content":[]}`
+
+// representation returns the representation of page content.
+func (c *ConfluenceClient) representation() string {
+	if c.Representation == "" {
+		return RepresentationADF
+	}
+	return c.Representation
+}
+
+// emptyContent returns the content of the pages created for folders.
+func (c *ConfluenceClient) emptyContent() string {
+	if c.representation() == RepresentationADF {
+		return emptyDocument
+	}
+	return ""
+}
+
+// newPage returns a page holding content in the representation of the
+// client.
+func (c *ConfluenceClient) newPage(title, spaceKey, content, parentID string) Page {
+	page := NewPage(title, spaceKey, content, parentID)
+	page.Body = NewPageBody(c.representation(), content)
+	return page
+}
+
+// CreateParentPage returns the ID of the page titled title in the space,
+// creating an empty page under parentID when there is none, so folders map
+// to the same pages on every run.
 func (c *ConfluenceClient) CreateParentPage(spaceKey, title, parentID string) (string, error) {
-	// Placeholder implementation for creating a parent page
-	fmt.Printf("Creating parent page: SpaceKey=%s, Title=%s, ParentID=%s\n", spaceKey, title, parentID)
-	return "mock-parent-page-id", nil
+	page, err := c.GetPageByTitle(spaceKey, title)
+	if err != nil {
+		return "", err
+	}
+	if page != nil {
+		return page.ID, nil
+	}
+	return c.CreatePage(spaceKey, title, c.emptyContent(), parentID)
 }
 
-// Implement the CreatePage method for the ConfluenceClient struct
+// CreatePage creates a page with the given content, in the representation
+// of the client, and returns its ID.
 func (c *ConfluenceClient) CreatePage(spaceKey, title, content string, parentID string) (string, error) {
-	// Placeholder implementation for creating a page
-	fmt.Printf("Creating page: SpaceKey=%s, Title=%s, ParentID=%s\n", spaceKey, title, parentID)
-	return "mock-page-id", nil
+	var created Page
+	if err := c.doJSON(http.MethodPost, "/rest/api/content", nil, c.newPage(title, spaceKey, content, parentID), &created); err != nil {
+		return "", fmt.Errorf("failed to create page %s: %w", title, err)
+	}
+	return created.ID, nil
 }
 
-// Implement the UpdatePage method for the ConfluenceClient struct
+// UpdatePage replaces the title and content of a page. version is the new
+// version number, one more than the current one.
 func (c *ConfluenceClient) UpdatePage(pageID, title, content, spaceKey string, version int) error {
-	// Placeholder implementation for updating a page
-	fmt.Printf("Updating page: PageID=%s, Title=%s, Version=%d\n", pageID, title, version)
+	page := c.newPage(title, spaceKey, content, "")
+	page.Version = &Version{Number: version}
+
+	path := "/rest/api/content/" + url.PathEscape(pageID)
+	if err := c.doJSON(http.MethodPut, path, nil, page, nil); err != nil {
+		return fmt.Errorf("failed to update page %s: %w", pageID, err)
+	}
 	return nil
 }
 
 // UploadAttachment uploads a file as an attachment to the specified page.
-func (c *ConfluenceClient) UploadAttachment(pageID, filePath string) error {
-	fmt.Printf("Uploading attachment %s to page %s\n", filePath, pageID)
-	return nil
-}
+// An attachment of the same name is replaced by a new version.
+func (c *ConfluenceClient) UploadAttachment(pageID, filePath string) (*Attachment, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open attachment: %w", err)
+	}
+	defer file.Close()
 
-// Implement GetPageByTitle in the Confluence client
-func (c *ConfluenceClient) GetPageByTitle(spaceKey, title string) (*Page, error) {
-	url := fmt.Sprintf("%s/rest/api/content?spaceKey=%s&title=%s", c.BaseURL, spaceKey, title)
-	request, err := http.NewRequest("GET", url, nil)
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", filepath.Base(filePath))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
+	if _, err := io.Copy(part, file); err != nil {
+		return nil, fmt.Errorf("failed to read attachment: %w", err)
+	}
+	if err := writer.WriteField("minorEdit", "true"); err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
 
-	request.SetBasicAuth(c.Username, c.APIToken)
-	response, err := c.HTTPClient.Do(request)
+	request, err := c.newRequest(http.MethodPut, "/rest/api/content/"+url.PathEscape(pageID)+"/child/attachment", nil, &body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute request: %w", err)
+		return nil, err
 	}
-	defer response.Body.Close()
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	request.Header.Set("X-Atlassian-Token", "nocheck")
+
+	var result struct {
+		Results []struct {
+			ID         string `json:"id"`
+			Title      string `json:"title"`
+			Extensions struct {
+				FileID     string `json:"fileId"`
+				Collection string `json:"collectionName"`
+			} `json:"extensions"`
+		} `json:"results"`
+	}
+	if err := c.do(request, &result); err != nil {
+		return nil, fmt.Errorf("failed to upload attachment %s: %w", filepath.Base(filePath), err)
+	}
+	if len(result.Results) == 0 {
+		return nil, fmt.Errorf("failed to upload attachment %s: response lists no attachment", filepath.Base(filePath))
+	}
+
+	uploaded := result.Results[0]
+	return &Attachment{
+		ID:         uploaded.ID,
+		Title:      uploaded.Title,
+		FileID:     uploaded.Extensions.FileID,
+		Collection: uploaded.Extensions.Collection,
+	}, nil
+}
 
-	if response.StatusCode == http.StatusNotFound {
-		return nil, nil // Page not found
-	} else if response.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", response.StatusCode)
+// GetPageByTitle returns the page titled title in the space, with its
+// version, or nil when there is none.
+func (c *ConfluenceClient) GetPageByTitle(spaceKey, title string) (*Page, error) {
+	query := url.Values{
+		"spaceKey": {spaceKey},
+		"title":    {title},
+		"type":     {"page"},
+		"expand":   {"version"},
 	}
 
 	var result struct {
 		Results []Page `json:"results"`
 	}
-	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	err := c.doJSON(http.MethodGet, "/rest/api/content", query, nil, &result)
+	if apiErr, ok := err.(*APIError); ok && apiErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up page %s: %w", title, err)
 	}
 
 	if len(result.Results) == 0 {
@@ -89,3 +314,110 @@ func (c *ConfluenceClient) GetPageByTitle(spaceKey, title string) (*Page, error)
 
 	return &result.Results[0], nil
 }
+
+// newRequest creates an authenticated request for an API path, relative to
+// BaseURL.
+func (c *ConfluenceClient) newRequest(method, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	requestURL := strings.TrimSuffix(c.BaseURL, "/") + path
+	if len(query) > 0 {
+		requestURL += "?" + query.Encode()
+	}
+
+	request, err := http.NewRequest(method, requestURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if c.BearerToken != "" {
+		request.Header.Set("Authorization", "Bearer "+c.BearerToken)
+	} else {
+		request.SetBasicAuth(c.Username, c.APIToken)
+	}
+	request.Header.Set("Accept", "application/json")
+	return request, nil
+}
+
+// doJSON sends in, if not nil, as the JSON body of a request and decodes the
+// JSON response into out, if not nil.
+func (c *ConfluenceClient) doJSON(method, path string, query url.Values, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	request, err := c.newRequest(method, path, query, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	return c.do(request, out)
+}
+
+// do sends a request and decodes the JSON response into out, if not nil.
+// Responses other than 2xx are returned as an *APIError.
+func (c *ConfluenceClient) do(request *http.Request, out interface{}) error {
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, maxErrorBody))
+		return &APIError{
+			Method:     request.Method,
+			URL:        request.URL.Redacted(),
+			StatusCode: response.StatusCode,
+			Message:    errorMessage(body),
+		}
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(response.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// errorMessage returns the message of an error response body: the message
+// of the v1 API, the titles and details of the v2 API, or else the body.
+func errorMessage(body []byte) string {
+	var response struct {
+		Message string `json:"message"`
+		Errors  []struct {
+			Title  string `json:"title"`
+			Detail string `json:"detail"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &response); err == nil {
+		if response.Message != "" {
+			return response.Message
+		}
+		var messages []string
+		for _, e := range response.Errors {
+			message := e.Title
+			if e.Detail != "" && e.Detail != e.Title {
+				message = strings.TrimPrefix(message+": "+e.Detail, ": ")
+			}
+			if message != "" {
+				messages = append(messages, message)
+			}
+		}
+		if len(messages) > 0 {
+			return strings.Join(messages, "; ")
+		}
+	}
+	return strings.Join(strings.Fields(string(body)), " ")
+}
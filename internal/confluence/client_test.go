@@ -0,0 +1,249 @@
+package confluence
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestClient returns a client for a stand-in Confluence served by handler.
+func newTestClient(t *testing.T, handler http.HandlerFunc) *ConfluenceClient {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return NewConfluenceClient(server.URL+"/wiki", "user@example.com", "secret")
+}
+
+func TestClientCreatePage(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/wiki/rest/api/content", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		username, password, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "user@example.com", username)
+		assert.Equal(t, "secret", password)
+
+		body, _ := io.ReadAll(r.Body)
+		assert.JSONEq(t, `{
+			"type": "page",
+			"title": "Guide",
+			"space": {"key": "DOCS"},
+			"body": {"atlas_doc_format": {"value": "{\"type\":\"doc\",\"content\":[]}", "representation": "atlas_doc_format"}},
+			"ancestors": [{"id": "42"}]
+		}`, string(body))
+
+		w.Write([]byte(`{"id": "1001", "type": "page", "title": "Guide"}`))
+	})
+
+	id, err := client.CreatePage("DOCS", "Guide", `{"type":"doc","content":[]}`, "42")
+	assert.NoError(t, err)
+	assert.Equal(t, "1001", id)
+}
+
+func TestClientUpdatePage(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+		assert.Equal(t, "/wiki/rest/api/content/1001", r.URL.Path)
+
+		var page Page
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&page))
+		assert.Equal(t, "Guide", page.Title)
+		if assert.NotNil(t, page.Version) {
+			assert.Equal(t, 3, page.Version.Number)
+		}
+
+		w.Write([]byte(`{"id": "1001"}`))
+	})
+
+	assert.NoError(t, client.UpdatePage("1001", "Guide", `{"type":"doc","content":[]}`, "DOCS", 3))
+}
+
+func TestClientGetPageByTitle(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		assert.Equal(t, "/wiki/rest/api/content", r.URL.Path)
+		assert.Equal(t, "DOCS", r.URL.Query().Get("spaceKey"))
+		assert.Equal(t, "version", r.URL.Query().Get("expand"))
+
+		switch r.URL.Query().Get("title") {
+		case "Q&A / Notes":
+			w.Write([]byte(`{"results": [{"id": "7", "type": "page", "title": "Q&A / Notes", "version": {"number": 4}}]}`))
+		default:
+			w.Write([]byte(`{"results": []}`))
+		}
+	})
+
+	page, err := client.GetPageByTitle("DOCS", "Q&A / Notes")
+	assert.NoError(t, err)
+	if assert.NotNil(t, page) {
+		assert.Equal(t, "7", page.ID)
+		assert.Equal(t, 4, page.Version.Number)
+	}
+
+	page, err = client.GetPageByTitle("DOCS", "Missing")
+	assert.NoError(t, err)
+	assert.Nil(t, page)
+}
+
+func TestClientCreateParentPage(t *testing.T) {
+	var created []string
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			if r.URL.Query().Get("title") == "existing" {
+				w.Write([]byte(`{"results": [{"id": "5", "title": "existing"}]}`))
+				return
+			}
+			w.Write([]byte(`{"results": []}`))
+		case http.MethodPost:
+			var page Page
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&page))
+			created = append(created, page.Title)
+			w.Write([]byte(`{"id": "6"}`))
+		}
+	})
+
+	id, err := client.CreateParentPage("DOCS", "existing", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "5", id)
+
+	id, err = client.CreateParentPage("DOCS", "guides", "5")
+	assert.NoError(t, err)
+	assert.Equal(t, "6", id)
+	assert.Equal(t, []string{"guides"}, created)
+}
+
+func TestClientUploadAttachment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "diagram.png")
+	assert.NoError(t, os.WriteFile(path, []byte("PNG"), 0644))
+
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+		assert.Equal(t, "/wiki/rest/api/content/1001/child/attachment", r.URL.Path)
+		assert.Equal(t, "nocheck", r.Header.Get("X-Atlassian-Token"))
+
+		file, header, err := r.FormFile("file")
+		if assert.NoError(t, err) {
+			defer file.Close()
+			data, _ := io.ReadAll(file)
+			assert.Equal(t, "diagram.png", header.Filename)
+			assert.Equal(t, "PNG", string(data))
+		}
+
+		w.Write([]byte(`{"results": [{"id": "att9", "title": "diagram.png", "extensions": {"fileId": "f-123", "collectionName": "contentId-1001"}}]}`))
+	})
+
+	attachment, err := client.UploadAttachment("1001", path)
+	assert.NoError(t, err)
+	assert.Equal(t, &Attachment{ID: "att9", Title: "diagram.png", FileID: "f-123", Collection: "contentId-1001"}, attachment)
+}
+
+func TestClientErrors(t *testing.T) {
+	cases := []struct {
+		name     string
+		status   int
+		body     string
+		expected string
+	}{
+		{
+			name:     "v1 message",
+			status:   http.StatusBadRequest,
+			body:     `{"statusCode": 400, "message": "A page with this title already exists"}`,
+			expected: "400 Bad Request: A page with this title already exists",
+		},
+		{
+			name:     "v2 errors",
+			status:   http.StatusForbidden,
+			body:     `{"errors": [{"status": 403, "title": "Forbidden", "detail": "No permission to edit the space"}]}`,
+			expected: "403 Forbidden: Forbidden: No permission to edit the space",
+		},
+		{
+			name:     "Plain body",
+			status:   http.StatusInternalServerError,
+			body:     "<html>\n  <body>Oops</body>\n</html>",
+			expected: "500 Internal Server Error: <html> <body>Oops</body> </html>",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(c.status)
+				w.Write([]byte(c.body))
+			})
+
+			_, err := client.CreatePage("DOCS", "Guide", `{"type":"doc","content":[]}`, "")
+			assert.ErrorContains(t, err, "failed to create page Guide: POST ")
+			assert.ErrorContains(t, err, c.expected)
+
+			var apiErr *APIError
+			if assert.ErrorAs(t, err, &apiErr) {
+				assert.Equal(t, c.status, apiErr.StatusCode)
+			}
+		})
+	}
+
+	t.Run("Page not found", func(t *testing.T) {
+		client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			http.NotFound(w, r)
+		})
+		page, err := client.GetPageByTitle("DOCS", "Guide")
+		assert.NoError(t, err)
+		assert.Nil(t, page)
+	})
+}
+
+func TestDataCenterClient(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer pat-123", r.Header.Get("Authorization"))
+		_, _, basic := r.BasicAuth()
+		assert.False(t, basic)
+
+		switch r.Method + " " + r.URL.Path {
+		case "GET /confluence/rest/api/content":
+			w.Write([]byte(`{"results": []}`))
+		case "POST /confluence/rest/api/content", "PUT /confluence/rest/api/content/2001":
+			body, _ := io.ReadAll(r.Body)
+			bodies = append(bodies, string(body))
+			w.Write([]byte(`{"id": "2001"}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{BaseURL: server.URL + "/confluence/", APIToken: "pat-123", Deployment: DataCenter})
+	assert.NoError(t, err)
+
+	id, err := client.CreateParentPage("OPS", "runbooks", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "2001", id)
+	assert.NoError(t, client.UpdatePage("2001", "runbooks", "<p>Hello</p>", "OPS", 2))
+
+	if assert.Len(t, bodies, 2) {
+		assert.JSONEq(t, `{
+			"type": "page",
+			"title": "runbooks",
+			"space": {"key": "OPS"},
+			"body": {"storage": {"value": "", "representation": "storage"}}
+		}`, bodies[0])
+		assert.JSONEq(t, `{
+			"type": "page",
+			"title": "runbooks",
+			"space": {"key": "OPS"},
+			"body": {"storage": {"value": "<p>Hello</p>", "representation": "storage"}},
+			"version": {"number": 2}
+		}`, bodies[1])
+	}
+
+	_, err = NewClient(Config{BaseURL: server.URL, APIToken: "pat-123", Deployment: DataCenter, API: APIv2})
+	assert.EqualError(t, err, "the v2 API is only available on Confluence Cloud")
+}
@@ -0,0 +1,246 @@
+package confluence
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Ensure ConfluenceV2Client implements the same API as ConfluenceClient
+var _ ConfluenceAPI = (*ConfluenceV2Client)(nil)
+
+// ConfluenceV2Client publishes pages through the Confluence Cloud REST API
+// v2. The v2 API has no endpoints for uploading attachments or adding
+// labels, so those requests go to the v1 API of the same site.
+type ConfluenceV2Client struct {
+	v1       *ConfluenceClient // Sends the requests, and those the v2 API has no endpoint for; its Representation applies to page content
+	spaceIDs map[string]string // Space IDs by space key
+}
+
+// NewConfluenceV2Client creates a v2 client that authenticates with the
+// username and API token of an Atlassian account. baseURL is the URL of the
+// Confluence site, as for NewConfluenceClient.
+func NewConfluenceV2Client(baseURL, username, apiToken string) *ConfluenceV2Client {
+	return &ConfluenceV2Client{
+		v1:       NewConfluenceClient(baseURL, username, apiToken),
+		spaceIDs: make(map[string]string),
+	}
+}
+
+// pageV2 is a page of the v2 API.
+type pageV2 struct {
+	ID       string     `json:"id,omitempty"`
+	Status   string     `json:"status"`
+	Title    string     `json:"title"`
+	SpaceID  string     `json:"spaceId,omitempty"`
+	ParentID string     `json:"parentId,omitempty"`
+	Body     *bodyV2    `json:"body,omitempty"`
+	Version  *versionV2 `json:"version,omitempty"`
+}
+
+// bodyV2 is the body of a page written through the v2 API.
+type bodyV2 struct {
+	Representation string `json:"representation"`
+	Value          string `json:"value"`
+}
+
+// versionV2 is the version of a page or content property of the v2 API.
+type versionV2 struct {
+	Number int `json:"number"`
+}
+
+// propertyV2 is a content property of the v2 API.
+type propertyV2 struct {
+	ID      string      `json:"id,omitempty"`
+	Key     string      `json:"key"`
+	Value   interface{} `json:"value"`
+	Version *versionV2  `json:"version,omitempty"`
+}
+
+// spaceID returns the ID of the space with the given key, which the v2 API
+// uses in place of keys.
+func (c *ConfluenceV2Client) spaceID(spaceKey string) (string, error) {
+	if id, ok := c.spaceIDs[spaceKey]; ok {
+		return id, nil
+	}
+
+	var result struct {
+		Results []struct {
+			ID  string `json:"id"`
+			Key string `json:"key"`
+		} `json:"results"`
+	}
+	if err := c.v1.doJSON(http.MethodGet, "/api/v2/spaces", url.Values{"keys": {spaceKey}}, nil, &result); err != nil {
+		return "", fmt.Errorf("failed to look up space %s: %w", spaceKey, err)
+	}
+	if len(result.Results) == 0 {
+		return "", fmt.Errorf("space %s not found", spaceKey)
+	}
+
+	c.spaceIDs[spaceKey] = result.Results[0].ID
+	return result.Results[0].ID, nil
+}
+
+// CreateParentPage returns the ID of the page titled title in the space,
+// creating an empty page under parentID when there is none, so folders map
+// to the same pages on every run.
+func (c *ConfluenceV2Client) CreateParentPage(spaceKey, title, parentID string) (string, error) {
+	page, err := c.GetPageByTitle(spaceKey, title)
+	if err != nil {
+		return "", err
+	}
+	if page != nil {
+		return page.ID, nil
+	}
+	return c.CreatePage(spaceKey, title, c.v1.emptyContent(), parentID)
+}
+
+// CreatePage creates a page with the given content, in the representation
+// of the client, and returns its ID.
+func (c *ConfluenceV2Client) CreatePage(spaceKey, title, content string, parentID string) (string, error) {
+	spaceID, err := c.spaceID(spaceKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to create page %s: %w", title, err)
+	}
+
+	page := pageV2{
+		Status:   "current",
+		Title:    title,
+		SpaceID:  spaceID,
+		ParentID: parentID,
+		Body:     &bodyV2{Representation: c.v1.representation(), Value: content},
+	}
+	var created pageV2
+	if err := c.v1.doJSON(http.MethodPost, "/api/v2/pages", nil, page, &created); err != nil {
+		return "", fmt.Errorf("failed to create page %s: %w", title, err)
+	}
+	return created.ID, nil
+}
+
+// UpdatePage replaces the title and content of a page. version is the
+// new version number, one more than the current one.
+func (c *ConfluenceV2Client) UpdatePage(pageID, title, content, spaceKey string, version int) error {
+	page := pageV2{
+		ID:      pageID,
+		Status:  "current",
+		Title:   title,
+		Body:    &bodyV2{Representation: c.v1.representation(), Value: content},
+		Version: &versionV2{Number: version},
+	}
+	if err := c.v1.doJSON(http.MethodPut, "/api/v2/pages/"+url.PathEscape(pageID), nil, page, nil); err != nil {
+		return fmt.Errorf("failed to update page %s: %w", pageID, err)
+	}
+	return nil
+}
+
+// GetPageByTitle returns the current page titled title in the space, with
+// its version, or nil when there is none.
+func (c *ConfluenceV2Client) GetPageByTitle(spaceKey, title string) (*Page, error) {
+	spaceID, err := c.spaceID(spaceKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up page %s: %w", title, err)
+	}
+
+	query := url.Values{
+		"space-id": {spaceID},
+		"title":    {title},
+		"status":   {"current"},
+	}
+	var result struct {
+		Results []pageV2 `json:"results"`
+	}
+	if err := c.v1.doJSON(http.MethodGet, "/api/v2/pages", query, nil, &result); err != nil {
+		return nil, fmt.Errorf("failed to look up page %s: %w", title, err)
+	}
+	if len(result.Results) == 0 {
+		return nil, nil
+	}
+
+	found := result.Results[0]
+	page := &Page{
+		ID:    found.ID,
+		Type:  "page",
+		Title: found.Title,
+		Space: Space{Key: spaceKey},
+	}
+	if found.Version != nil {
+		page.Version = &Version{Number: found.Version.Number}
+	}
+	if found.ParentID != "" {
+		page.Ancestors = []Ancestor{{ID: found.ParentID}}
+	}
+	return page, nil
+}
+
+// UploadAttachment uploads a file as an attachment to the specified page,
+// through the v1 API.
+func (c *ConfluenceV2Client) UploadAttachment(pageID, filePath string) (*Attachment, error) {
+	return c.v1.UploadAttachment(pageID, filePath)
+}
+
+// Labels returns the names of the labels of a page.
+func (c *ConfluenceV2Client) Labels(pageID string) ([]string, error) {
+	var result struct {
+		Results []struct {
+			Name string `json:"name"`
+		} `json:"results"`
+	}
+	path := "/api/v2/pages/" + url.PathEscape(pageID) + "/labels"
+	if err := c.v1.doJSON(http.MethodGet, path, url.Values{"limit": {"250"}}, nil, &result); err != nil {
+		return nil, fmt.Errorf("failed to get labels of page %s: %w", pageID, err)
+	}
+
+	labels := make([]string, 0, len(result.Results))
+	for _, label := range result.Results {
+		labels = append(labels, label.Name)
+	}
+	return labels, nil
+}
+
+// AddLabels adds global labels to a page, through the v1 API. Labels the
+// page already has are left as they are.
+func (c *ConfluenceV2Client) AddLabels(pageID string, labels []string) error {
+	type label struct {
+		Prefix string `json:"prefix"`
+		Name   string `json:"name"`
+	}
+	body := make([]label, 0, len(labels))
+	for _, name := range labels {
+		body = append(body, label{Prefix: "global", Name: name})
+	}
+
+	path := "/rest/api/content/" + url.PathEscape(pageID) + "/label"
+	if err := c.v1.doJSON(http.MethodPost, path, nil, body, nil); err != nil {
+		return fmt.Errorf("failed to add labels to page %s: %w", pageID, err)
+	}
+	return nil
+}
+
+// SetContentProperty creates the content property key of a page, or
+// replaces its value when it exists.
+func (c *ConfluenceV2Client) SetContentProperty(pageID, key string, value interface{}) error {
+	path := "/api/v2/pages/" + url.PathEscape(pageID) + "/properties"
+
+	var result struct {
+		Results []propertyV2 `json:"results"`
+	}
+	if err := c.v1.doJSON(http.MethodGet, path, url.Values{"key": {key}}, nil, &result); err != nil {
+		return fmt.Errorf("failed to get property %s of page %s: %w", key, pageID, err)
+	}
+
+	property := propertyV2{Key: key, Value: value}
+	method := http.MethodPost
+	if len(result.Results) > 0 {
+		existing := result.Results[0]
+		method, path = http.MethodPut, path+"/"+url.PathEscape(existing.ID)
+		property.Version = &versionV2{Number: 1}
+		if existing.Version != nil {
+			property.Version.Number = existing.Version.Number + 1
+		}
+	}
+
+	if err := c.v1.doJSON(method, path, nil, property, nil); err != nil {
+		return fmt.Errorf("failed to set property %s of page %s: %w", key, pageID, err)
+	}
+	return nil
+}
@@ -0,0 +1,337 @@
+package confluence
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakePage is a page stored by fakeConfluence.
+type fakePage struct {
+	id, space, title, parent, body string
+	representation                 string
+	version                        int
+}
+
+// fakeConfluence is an in-memory stand-in for a Confluence Cloud site that
+// serves the v1 and v2 endpoints the clients use.
+type fakeConfluence struct {
+	mu         sync.Mutex
+	spaces     map[string]string // Space IDs by key
+	pages      []*fakePage
+	labels     map[string][]string               // Labels by page ID
+	properties map[string]map[string]*propertyV2 // Content properties by page ID and key
+}
+
+func newFakeConfluence(t *testing.T) (*fakeConfluence, string) {
+	fake := &fakeConfluence{
+		spaces:     map[string]string{"DOCS": "100"},
+		labels:     make(map[string][]string),
+		properties: make(map[string]map[string]*propertyV2),
+	}
+	server := httptest.NewServer(http.StripPrefix("/wiki", fake))
+	t.Cleanup(server.Close)
+	return fake, server.URL + "/wiki"
+}
+
+func (f *fakeConfluence) find(space, title string) *fakePage {
+	for _, page := range f.pages {
+		if page.space == space && page.title == title {
+			return page
+		}
+	}
+	return nil
+}
+
+func (f *fakeConfluence) byID(id string) *fakePage {
+	for _, page := range f.pages {
+		if page.id == id {
+			return page
+		}
+	}
+	return nil
+}
+
+func (f *fakeConfluence) create(w http.ResponseWriter, space, title, parent, representation, body string) {
+	if f.find(space, title) != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"statusCode": 400, "message": "A page with this title already exists"})
+		return
+	}
+	page := &fakePage{id: strconv.Itoa(1000 + len(f.pages)), space: space, title: title, parent: parent, body: body, representation: representation, version: 1}
+	f.pages = append(f.pages, page)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"id": page.id})
+}
+
+func (f *fakeConfluence) update(w http.ResponseWriter, id, title, representation, body string, version int) {
+	page := f.byID(id)
+	switch {
+	case page == nil:
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"statusCode": 404, "message": "No content with id " + id})
+	case version != page.version+1:
+		writeJSON(w, http.StatusConflict, map[string]interface{}{"statusCode": 409, "message": "Version must be incremented"})
+	default:
+		page.title, page.body, page.representation, page.version = title, body, representation, version
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": id})
+	}
+}
+
+// v1Body returns the representation and value of the body of a v1 page.
+func v1Body(page Page) (string, string) {
+	if page.Body.Storage != nil {
+		return page.Body.Storage.Representation, page.Body.Storage.Value
+	}
+	return page.Body.AtlasDocFormat.Representation, page.Body.AtlasDocFormat.Value
+}
+
+func (f *fakeConfluence) spaceKey(id string) string {
+	for key, spaceID := range f.spaces {
+		if spaceID == id {
+			return key
+		}
+	}
+	return ""
+}
+
+func (f *fakeConfluence) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	query := r.URL.Query()
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	route := r.Method + " " + r.URL.Path
+	switch {
+	case route == "GET /rest/api/content":
+		results := []interface{}{}
+		if page := f.find(query.Get("spaceKey"), query.Get("title")); page != nil {
+			results = append(results, map[string]interface{}{"id": page.id, "title": page.title, "version": map[string]int{"number": page.version}})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"results": results})
+
+	case route == "POST /rest/api/content":
+		var page Page
+		json.NewDecoder(r.Body).Decode(&page)
+		parent := ""
+		if len(page.Ancestors) > 0 {
+			parent = page.Ancestors[0].ID
+		}
+		representation, body := v1Body(page)
+		f.create(w, page.Space.Key, page.Title, parent, representation, body)
+
+	case r.Method == http.MethodPut && len(parts) == 4 && parts[0] == "rest":
+		var page Page
+		json.NewDecoder(r.Body).Decode(&page)
+		representation, body := v1Body(page)
+		f.update(w, parts[3], page.Title, representation, body, page.Version.Number)
+
+	case r.Method == http.MethodPut && len(parts) == 6 && parts[5] == "attachment":
+		_, header, err := r.FormFile("file")
+		if err != nil || f.byID(parts[3]) == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"statusCode": 400, "message": "bad attachment"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"results": []interface{}{map[string]interface{}{
+			"id":         "att-" + header.Filename,
+			"title":      header.Filename,
+			"extensions": map[string]string{"fileId": "file-" + header.Filename, "collectionName": "contentId-" + parts[3]},
+		}}})
+
+	case r.Method == http.MethodPost && len(parts) == 5 && parts[4] == "label":
+		var labels []struct{ Name string }
+		json.NewDecoder(r.Body).Decode(&labels)
+		for _, label := range labels {
+			f.labels[parts[3]] = append(f.labels[parts[3]], label.Name)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"results": labels})
+
+	case route == "GET /api/v2/spaces":
+		results := []interface{}{}
+		if id, ok := f.spaces[query.Get("keys")]; ok {
+			results = append(results, map[string]string{"id": id, "key": query.Get("keys")})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"results": results})
+
+	case route == "GET /api/v2/pages":
+		results := []pageV2{}
+		if page := f.find(f.spaceKey(query.Get("space-id")), query.Get("title")); page != nil {
+			results = append(results, pageV2{ID: page.id, Status: "current", Title: page.title, SpaceID: query.Get("space-id"), ParentID: page.parent, Version: &versionV2{Number: page.version}})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"results": results})
+
+	case route == "POST /api/v2/pages":
+		var page pageV2
+		json.NewDecoder(r.Body).Decode(&page)
+		if page.Body.Representation != RepresentationADF && page.Body.Representation != RepresentationStorage {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"errors": []map[string]string{{"title": "Bad Request", "detail": "unknown representation"}}})
+			return
+		}
+		f.create(w, f.spaceKey(page.SpaceID), page.Title, page.ParentID, page.Body.Representation, page.Body.Value)
+
+	case r.Method == http.MethodPut && len(parts) == 4 && parts[2] == "pages":
+		var page pageV2
+		json.NewDecoder(r.Body).Decode(&page)
+		f.update(w, parts[3], page.Title, page.Body.Representation, page.Body.Value, page.Version.Number)
+
+	case r.Method == http.MethodGet && len(parts) == 5 && parts[4] == "labels":
+		results := []interface{}{}
+		for _, name := range f.labels[parts[3]] {
+			results = append(results, map[string]string{"id": "l-" + name, "name": name, "prefix": "global"})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"results": results})
+
+	case len(parts) >= 5 && parts[4] == "properties":
+		properties := f.properties[parts[3]]
+		if properties == nil {
+			properties = make(map[string]*propertyV2)
+			f.properties[parts[3]] = properties
+		}
+		switch r.Method {
+		case http.MethodGet:
+			results := []*propertyV2{}
+			if property, ok := properties[query.Get("key")]; ok {
+				results = append(results, property)
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"results": results})
+		case http.MethodPost, http.MethodPut:
+			var property propertyV2
+			json.NewDecoder(r.Body).Decode(&property)
+			version := 1
+			if existing, ok := properties[property.Key]; ok {
+				if r.Method == http.MethodPost || property.Version == nil || property.Version.Number != existing.Version.Number+1 {
+					writeJSON(w, http.StatusConflict, map[string]interface{}{"errors": []map[string]string{{"title": "Conflict"}}})
+					return
+				}
+				version = property.Version.Number
+			}
+			property.ID, property.Version = "p-"+property.Key, &versionV2{Number: version}
+			properties[property.Key] = &property
+			writeJSON(w, http.StatusOK, property)
+		}
+
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"statusCode": 404, "message": "no route for " + route})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// TestClientContract runs the same publishing steps against both clients,
+// which must behave alike for ConvertDirectoryWithOptions.
+func TestClientContract(t *testing.T) {
+	for _, api := range []APIVersion{APIv1, APIv2} {
+		t.Run(string(api), func(t *testing.T) {
+			fake, baseURL := newFakeConfluence(t)
+			client, err := NewClient(Config{BaseURL: baseURL, Username: "user@example.com", APIToken: "secret", API: api})
+			assert.NoError(t, err)
+
+			page, err := client.GetPageByTitle("DOCS", "Guide")
+			assert.NoError(t, err)
+			assert.Nil(t, page)
+
+			parentID, err := client.CreateParentPage("DOCS", "guides", "")
+			assert.NoError(t, err)
+			again, err := client.CreateParentPage("DOCS", "guides", "")
+			assert.NoError(t, err)
+			assert.Equal(t, parentID, again)
+
+			pageID, err := client.CreatePage("DOCS", "Guide", `{"type":"doc","content":[]}`, parentID)
+			assert.NoError(t, err)
+			_, err = client.CreatePage("DOCS", "Guide", `{"type":"doc","content":[]}`, parentID)
+			assert.ErrorContains(t, err, "A page with this title already exists")
+
+			page, err = client.GetPageByTitle("DOCS", "Guide")
+			assert.NoError(t, err)
+			if assert.NotNil(t, page) {
+				assert.Equal(t, pageID, page.ID)
+				assert.Equal(t, 1, page.Version.Number)
+			}
+
+			assert.NoError(t, client.UpdatePage(pageID, "Guide", `{"type":"doc","content":[{"type":"rule"}]}`, "DOCS", 2))
+			err = client.UpdatePage(pageID, "Guide", `{"type":"doc","content":[]}`, "DOCS", 2)
+			assert.ErrorContains(t, err, "409 Conflict: Version must be incremented")
+
+			stored := fake.byID(pageID)
+			assert.Equal(t, parentID, stored.parent)
+			assert.Equal(t, `{"type":"doc","content":[{"type":"rule"}]}`, stored.body)
+			assert.Equal(t, 2, stored.version)
+
+			path := filepath.Join(t.TempDir(), "diagram.png")
+			assert.NoError(t, os.WriteFile(path, []byte("PNG"), 0644))
+			attachment, err := client.UploadAttachment(pageID, path)
+			assert.NoError(t, err)
+			assert.Equal(t, &Attachment{ID: "att-diagram.png", Title: "diagram.png", FileID: "file-diagram.png", Collection: "contentId-" + pageID}, attachment)
+		})
+	}
+}
+
+func TestClientStorageRepresentation(t *testing.T) {
+	for _, api := range []APIVersion{APIv1, APIv2} {
+		t.Run(string(api), func(t *testing.T) {
+			fake, baseURL := newFakeConfluence(t)
+			client, err := NewClient(Config{BaseURL: baseURL, Username: "user@example.com", APIToken: "secret", API: api, Representation: RepresentationStorage})
+			assert.NoError(t, err)
+
+			parentID, err := client.CreateParentPage("DOCS", "guides", "")
+			assert.NoError(t, err)
+			pageID, err := client.CreatePage("DOCS", "Guide", "<p>Hello</p>", parentID)
+			assert.NoError(t, err)
+			assert.NoError(t, client.UpdatePage(pageID, "Guide", "<p>Hello again</p>", "DOCS", 2))
+
+			parent := fake.byID(parentID)
+			assert.Equal(t, RepresentationStorage, parent.representation)
+			assert.Equal(t, "", parent.body)
+			page := fake.byID(pageID)
+			assert.Equal(t, RepresentationStorage, page.representation)
+			assert.Equal(t, "<p>Hello again</p>", page.body)
+		})
+	}
+
+	_, err := NewClient(Config{Deployment: DataCenter, Representation: RepresentationADF})
+	assert.EqualError(t, err, "Confluence Data Center only accepts the storage representation")
+	_, err = NewClient(Config{Representation: "wiki"})
+	assert.EqualError(t, err, `unknown representation "wiki", expected "atlas_doc_format" or "storage"`)
+}
+
+func TestClientV2UnknownSpace(t *testing.T) {
+	_, baseURL := newFakeConfluence(t)
+	client := NewConfluenceV2Client(baseURL, "user@example.com", "secret")
+
+	_, err := client.CreatePage("NOPE", "Guide", `{"type":"doc","content":[]}`, "")
+	assert.EqualError(t, err, "failed to create page Guide: space NOPE not found")
+}
+
+func TestClientV2LabelsAndProperties(t *testing.T) {
+	fake, baseURL := newFakeConfluence(t)
+	client := NewConfluenceV2Client(baseURL, "user@example.com", "secret")
+
+	pageID, err := client.CreatePage("DOCS", "Guide", `{"type":"doc","content":[]}`, "")
+	assert.NoError(t, err)
+
+	assert.NoError(t, client.AddLabels(pageID, []string{"docs", "howto"}))
+	labels, err := client.Labels(pageID)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"docs", "howto"}, labels)
+
+	assert.NoError(t, client.SetContentProperty(pageID, "source", map[string]string{"path": "guide.md"}))
+	assert.NoError(t, client.SetContentProperty(pageID, "source", map[string]string{"path": "docs/guide.md"}))
+	property := fake.properties[pageID]["source"]
+	assert.Equal(t, 2, property.Version.Number)
+	assert.Equal(t, map[string]interface{}{"path": "docs/guide.md"}, property.Value)
+}
+
+func TestNewClientUnknownAPI(t *testing.T) {
+	_, err := NewClient(Config{API: "v3"})
+	assert.EqualError(t, err, fmt.Sprintf("unknown Confluence API %q, expected %q or %q", "v3", APIv1, APIv2))
+}
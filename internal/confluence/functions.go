@@ -9,12 +9,7 @@ func NewPage(title, spaceKey, content, parentID string) Page {
 		Space: Space{
 			Key: spaceKey,
 		},
-		Body: Body{
-			Storage: Storage{
-				Value:          content,
-				Representation: "atlas_doc_format", // Changed from "wiki" to the ADF format
-			},
-		},
+		Body: NewPageBody(RepresentationADF, content),
 	}
 
 	// Add parent page as ancestor if provided
@@ -27,6 +22,16 @@ func NewPage(title, spaceKey, content, parentID string) Page {
 	return page
 }
 
+// NewPageBody returns a page body holding content in the given
+// representation.
+func NewPageBody(representation, content string) Body {
+	storage := &Storage{Value: content, Representation: representation}
+	if representation == RepresentationStorage {
+		return Body{Storage: storage}
+	}
+	return Body{AtlasDocFormat: storage}
+}
+
 // NewPageWithVersion creates a new page with version information for updates
 func NewPageWithVersion(title, spaceKey, content string, version int) Page {
 	page := NewPage(title, spaceKey, content, "")
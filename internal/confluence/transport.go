@@ -0,0 +1,305 @@
+package confluence
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Defaults of the transports that NewTransport returns.
+const (
+	DefaultMaxRetries = 4
+	DefaultMinDelay   = 500 * time.Millisecond
+	DefaultMaxDelay   = 30 * time.Second
+	DefaultTimeout    = 30 * time.Second
+)
+
+// Transport is the http.RoundTripper of the clients. It retries requests
+// that Confluence rate limits, and idempotent requests that fail with a
+// network error or a transient 5xx response, waiting as long as the
+// response asks or else backing off exponentially with jitter. It also
+// spaces requests out so that no more than RequestsPerSecond are sent.
+//
+// Rate-limited requests are retried whatever their method, since Confluence
+// rejects them before doing anything. Requests with a body that cannot be
+// sent again are not retried.
+type Transport struct {
+	Base              http.RoundTripper // Sends the requests; http.DefaultTransport when nil
+	MaxRetries        int               // Retries of a request after its first attempt
+	MinDelay          time.Duration     // Backoff before the first retry, doubled for each one after
+	MaxDelay          time.Duration     // Longest backoff between retries
+	Timeout           time.Duration     // Time limit of each attempt, with the response body; none when 0
+	RequestsPerSecond float64           // Most requests sent per second; no limit when 0
+
+	// Logf, when set, reports retries and pauses for the rate limit of the
+	// site.
+	Logf func(format string, args ...interface{})
+
+	mu    sync.Mutex
+	next  time.Time // Earliest time the next request may be sent
+	stats TransportStats
+
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// TransportStats counts the requests a Transport sent.
+type TransportStats struct {
+	Requests int           // Attempts sent, retries included
+	Retries  int           // Attempts that retried a failed one
+	Waited   time.Duration // Time spent waiting to retry or for the rate limit
+}
+
+func (s TransportStats) String() string {
+	return fmt.Sprintf("%d requests, %d retries, %s waiting", s.Requests, s.Retries, s.Waited.Round(time.Millisecond))
+}
+
+// NewTransport returns a transport with the default retries and timeout and
+// no limit on the request rate.
+func NewTransport() *Transport {
+	return &Transport{
+		MaxRetries: DefaultMaxRetries,
+		MinDelay:   DefaultMinDelay,
+		MaxDelay:   DefaultMaxDelay,
+		Timeout:    DefaultTimeout,
+	}
+}
+
+// Stats returns the counts of the requests sent so far.
+func (t *Transport) Stats() TransportStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stats
+}
+
+// RoundTrip sends a request, retrying it as the transport allows.
+func (t *Transport) RoundTrip(request *http.Request) (*http.Response, error) {
+	ctx := request.Context()
+	for retry := 0; ; retry++ {
+		if err := t.wait(ctx, t.reserve()); err != nil {
+			return nil, err
+		}
+
+		attempt := request.Clone(ctx)
+		if retry > 0 && request.GetBody != nil {
+			body, err := request.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attempt.Body = body
+		}
+		response, err := t.send(attempt)
+
+		if response != nil {
+			t.observe(request, response)
+		}
+		if retry >= t.MaxRetries || !t.retryable(request, response, err) || ctx.Err() != nil {
+			return response, err
+		}
+
+		delay, reason := t.backoff(retry), ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = response.Status
+			if after, ok := retryAfter(response.Header, t.clock()); ok {
+				delay = after
+			}
+			io.Copy(io.Discard, io.LimitReader(response.Body, maxErrorBody))
+			response.Body.Close()
+		}
+
+		t.mu.Lock()
+		t.stats.Retries++
+		t.mu.Unlock()
+		t.logf("Retrying %s %s in %s after %s (retry %d of %d)", request.Method, request.URL.Redacted(), delay.Round(time.Millisecond), reason, retry+1, t.MaxRetries)
+		if err := t.wait(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// send sends one attempt of a request, within the timeout of the transport.
+func (t *Transport) send(request *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.stats.Requests++
+	t.mu.Unlock()
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if t.Timeout <= 0 {
+		return base.RoundTrip(request)
+	}
+
+	ctx, cancel := context.WithTimeout(request.Context(), t.Timeout)
+	response, err := base.RoundTrip(request.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	response.Body = &cancelBody{ReadCloser: response.Body, cancel: cancel}
+	return response, nil
+}
+
+// cancelBody is a response body that releases the context of its request
+// when closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// retryable reports whether a request that got response or err may be
+// sent again.
+func (t *Transport) retryable(request *http.Request, response *http.Response, err error) bool {
+	if request.Body != nil && request.Body != http.NoBody && request.GetBody == nil {
+		return false
+	}
+	if err != nil {
+		return idempotent(request.Method)
+	}
+
+	switch response.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return idempotent(request.Method)
+	}
+	return false
+}
+
+// idempotent reports whether requests of a method can be sent twice with
+// the effect of sending them once.
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// backoff returns the delay before a retry: MinDelay doubled for each
+// retry before it, up to MaxDelay, of which up to half is random.
+func (t *Transport) backoff(retry int) time.Duration {
+	delay := t.MaxDelay
+	if retry < 32 && t.MinDelay<<retry < t.MaxDelay {
+		delay = t.MinDelay << retry
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// retryAfter returns how long a response asks to wait before retrying, as
+// given by its Retry-After header, in seconds or as a date, or by the time
+// the Atlassian X-RateLimit-Reset header says the rate limit resets.
+func retryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if date, err := http.ParseTime(value); err == nil {
+			return max(date.Sub(now), 0), true
+		}
+	}
+	if reset, ok := rateLimitReset(header); ok {
+		return max(reset.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// rateLimitReset returns the time of the Atlassian X-RateLimit-Reset
+// header, an ISO 8601 timestamp.
+func rateLimitReset(header http.Header) (time.Time, bool) {
+	value := header.Get("X-RateLimit-Reset")
+	if value == "" {
+		return time.Time{}, false
+	}
+	reset, err := time.Parse(time.RFC3339, value)
+	return reset, err == nil
+}
+
+// observe holds back the requests after a response that used up the rate
+// limit of the site until the limit resets.
+func (t *Transport) observe(request *http.Request, response *http.Response) {
+	if response.Header.Get("X-RateLimit-Remaining") != "0" {
+		return
+	}
+	reset, ok := rateLimitReset(response.Header)
+	if !ok || !reset.After(t.clock()) {
+		return
+	}
+
+	t.mu.Lock()
+	if reset.After(t.next) {
+		t.next = reset
+	}
+	t.mu.Unlock()
+	t.logf("Rate limit of %s used up, pausing requests until %s", request.URL.Host, reset.Format(time.RFC3339))
+}
+
+// reserve returns how long to wait before sending a request so that
+// requests keep to the rate limits, and books the time it is sent at.
+func (t *Transport) reserve() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.clock()
+	at := now
+	if t.next.After(at) {
+		at = t.next
+	}
+	if t.RequestsPerSecond > 0 {
+		t.next = at.Add(time.Duration(float64(time.Second) / t.RequestsPerSecond))
+	}
+	return at.Sub(now)
+}
+
+// wait waits for d, or until ctx is done.
+func (t *Transport) wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t.mu.Lock()
+	t.stats.Waited += d
+	t.mu.Unlock()
+
+	if t.sleep != nil {
+		return t.sleep(ctx, d)
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// clock returns the current time.
+func (t *Transport) clock() time.Time {
+	if t.now != nil {
+		return t.now()
+	}
+	return time.Now()
+}
+
+func (t *Transport) logf(format string, args ...interface{}) {
+	if t.Logf != nil {
+		t.Logf(format, args...)
+	}
+}
@@ -0,0 +1,129 @@
+package confluence
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeTransport is a transport on a fake clock, which its waits advance.
+type fakeTransport struct {
+	*Transport
+	mu    sync.Mutex
+	clock time.Time
+	waits []time.Duration
+}
+
+// newFakeTransport returns a transport for a client on a stand-in
+// Confluence served by handler, with delays that never wait.
+func newFakeTransport(t *testing.T, handler http.HandlerFunc) (*fakeTransport, *ConfluenceClient) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	fake := &fakeTransport{Transport: NewTransport(), clock: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	fake.now = func() time.Time {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return fake.clock
+	}
+	fake.sleep = func(ctx context.Context, d time.Duration) error {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		fake.clock = fake.clock.Add(d)
+		fake.waits = append(fake.waits, d)
+		return nil
+	}
+
+	client, err := NewClient(Config{BaseURL: server.URL + "/wiki", Username: "user@example.com", APIToken: "secret", Transport: fake.Transport})
+	assert.NoError(t, err)
+	return fake, client.(*ConfluenceClient)
+}
+
+func TestTransportRetryAfter(t *testing.T) {
+	var bodies []string
+	fake, client := newFakeTransport(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		switch len(bodies) {
+		case 1:
+			w.Header().Set("Retry-After", "3")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.Header().Set("X-RateLimit-Reset", "2024-05-01T12:00:10Z")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Write([]byte(`{"id": "1001"}`))
+		}
+	})
+
+	var logged []string
+	fake.Logf = func(format string, args ...interface{}) {
+		logged = append(logged, format)
+	}
+
+	id, err := client.CreatePage("DOCS", "Guide", `{"type":"doc","content":[]}`, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "1001", id)
+
+	assert.Equal(t, []time.Duration{3 * time.Second, 7 * time.Second}, fake.waits)
+	if assert.Len(t, bodies, 3) {
+		assert.Equal(t, bodies[0], bodies[2])
+	}
+	assert.Equal(t, TransportStats{Requests: 3, Retries: 2, Waited: 10 * time.Second}, fake.Stats())
+	assert.Len(t, logged, 2)
+}
+
+func TestTransportBackoff(t *testing.T) {
+	attempts := map[string]int{}
+	fake, client := newFakeTransport(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts[r.Method]++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	_, err := client.GetPageByTitle("DOCS", "Guide")
+	var apiErr *APIError
+	if assert.ErrorAs(t, err, &apiErr) {
+		assert.Equal(t, http.StatusServiceUnavailable, apiErr.StatusCode)
+	}
+	assert.Equal(t, DefaultMaxRetries+1, attempts[http.MethodGet])
+	if assert.Len(t, fake.waits, DefaultMaxRetries) {
+		for i, wait := range fake.waits {
+			backoff := DefaultMinDelay << i
+			assert.GreaterOrEqual(t, wait, backoff/2)
+			assert.LessOrEqual(t, wait, backoff)
+		}
+	}
+
+	// Creating a page is not idempotent, so a failure is not retried.
+	_, err = client.CreatePage("DOCS", "Guide", `{"type":"doc","content":[]}`, "")
+	assert.ErrorContains(t, err, "503 Service Unavailable")
+	assert.Equal(t, 1, attempts[http.MethodPost])
+}
+
+func TestTransportRateLimit(t *testing.T) {
+	requests := 0
+	fake, client := newFakeTransport(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 2 {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", "2024-05-01T12:01:00Z")
+		}
+		w.Write([]byte(`{"results": []}`))
+	})
+	fake.RequestsPerSecond = 4
+
+	for range 4 {
+		_, err := client.GetPageByTitle("DOCS", "Guide")
+		assert.NoError(t, err)
+	}
+
+	// The third request waits for the rate limit of the site to reset.
+	assert.Equal(t, []time.Duration{250 * time.Millisecond, 59750 * time.Millisecond, 250 * time.Millisecond}, fake.waits)
+	assert.Equal(t, 0, fake.Stats().Retries)
+}
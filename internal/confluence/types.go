@@ -17,12 +17,20 @@ type Space struct {
 	Key string `json:"key"`
 }
 
-// Body represents the body of a Confluence page.
+// Body represents the body of a Confluence page, in one of its
+// representations.
 type Body struct {
-	Storage Storage `json:"storage"`
+	Storage        *Storage `json:"storage,omitempty"`
+	AtlasDocFormat *Storage `json:"atlas_doc_format,omitempty"`
 }
 
-// Storage represents the storage format of a Confluence page body.
+// Representations of page bodies.
+const (
+	RepresentationADF     = "atlas_doc_format" // Atlassian Document Format JSON, Confluence Cloud only
+	RepresentationStorage = "storage"          // Confluence storage format (XHTML)
+)
+
+// Storage represents a representation of a Confluence page body.
 type Storage struct {
 	Value          string `json:"value"`
 	Representation string `json:"representation"`
@@ -48,6 +56,7 @@ type ADFDocument struct {
 type ADFParagraph struct {
 	Type    string        `json:"type"`
 	Content []interface{} `json:"content"`
+	Marks   []Mark        `json:"marks,omitempty"`
 }
 
 // ADFHeading represents a heading in ADF.
@@ -71,7 +80,17 @@ type ADFText struct {
 
 // Mark represents formatting (e.g., bold, italic) in ADF.
 type Mark struct {
-	Type string `json:"type"`
+	Type  string     `json:"type"`
+	Attrs *MarkAttrs `json:"attrs,omitempty"`
+}
+
+// MarkAttrs represents attributes for a mark.
+type MarkAttrs struct {
+	Href  string `json:"href,omitempty"`  // Target of link marks
+	Title string `json:"title,omitempty"` // Optional title of link marks
+	Align string `json:"align,omitempty"` // "center" or "end" for alignment marks
+	Type  string `json:"type,omitempty"`  // "sub" or "sup" for subsup marks
+	Color string `json:"color,omitempty"` // Hex color of backgroundColor marks
 }
 
 // ADFEmphasis represents emphasized text (bold, italic) in ADF.
@@ -82,30 +101,38 @@ type ADFEmphasis struct {
 	Marks []Mark `json:"marks"`
 }
 
-// ADFLink represents a hyperlink in ADF.
-type ADFLink struct {
-	Type    string        `json:"type"`
-	Attrs   LinkAttrs     `json:"attrs"`
-	Content []interface{} `json:"content"`
+// ADFMediaSingle represents a single image laid out as a block in ADF. Its
+// content is one media node.
+type ADFMediaSingle struct {
+	Type    string           `json:"type"`
+	Attrs   MediaSingleAttrs `json:"attrs"`
+	Content []interface{}    `json:"content"`
 }
 
-// LinkAttrs represents attributes for a link.
-type LinkAttrs struct {
-	Href string `json:"href"`
+// MediaSingleAttrs represents attributes for a mediaSingle.
+type MediaSingleAttrs struct {
+	Layout    string `json:"layout"`              // "center", "wide", "full-width", "align-start", "align-end", "wrap-left" or "wrap-right"
+	Width     int    `json:"width,omitempty"`     // Width of the image on the page
+	WidthType string `json:"widthType,omitempty"` // "pixel" when Width is in pixels rather than a percentage
 }
 
-// ADFImage represents an image in ADF.
-type ADFImage struct {
+// ADFMedia represents an image in ADF. Type is "media" inside a mediaSingle
+// and "mediaInline" for images within text.
+type ADFMedia struct {
 	Type  string     `json:"type"`
-	Attrs ImageAttrs `json:"attrs"`
+	Attrs MediaAttrs `json:"attrs"`
 }
 
-// ImageAttrs represents attributes for an image.
-type ImageAttrs struct {
-	Src    string `json:"src"`
-	Alt    string `json:"alt,omitempty"`
-	Width  int    `json:"width,omitempty"`
-	Height int    `json:"height,omitempty"`
+// MediaAttrs represents attributes for a media node. Files are attachments
+// referenced by their media file ID and collection; external images by URL.
+type MediaAttrs struct {
+	Type       string `json:"type"` // "file" or "external"
+	ID         string `json:"id,omitempty"`
+	Collection string `json:"collection,omitempty"`
+	URL        string `json:"url,omitempty"`
+	Alt        string `json:"alt,omitempty"`
+	Width      int    `json:"width,omitempty"`
+	Height     int    `json:"height,omitempty"`
 }
 
 // ADFCodeBlock represents a code block in ADF.
@@ -130,7 +157,7 @@ type ADFCodeSpan struct {
 // ADFList represents a list (ordered or unordered) in ADF.
 type ADFList struct {
 	Type    string        `json:"type"`
-	Attrs   ListAttrs     `json:"attrs,omitempty"`
+	Attrs   *ListAttrs    `json:"attrs,omitempty"`
 	Content []interface{} `json:"content"`
 }
 
@@ -159,16 +186,24 @@ type ADFBlockquote struct {
 // ADFTable represents a table in ADF.
 type ADFTable struct {
 	Type    string        `json:"type"`
-	Content []ADFTableRow `json:"content"`
+	Attrs   TableAttrs    `json:"attrs"`
+	Content []interface{} `json:"content"`
+}
+
+// TableAttrs represents attributes for a table.
+type TableAttrs struct {
+	IsNumberColumnEnabled bool   `json:"isNumberColumnEnabled"`
+	Layout                string `json:"layout,omitempty"`
 }
 
 // ADFTableRow represents a table row in ADF.
 type ADFTableRow struct {
-	Type    string         `json:"type"`
-	Content []ADFTableCell `json:"content"`
+	Type    string        `json:"type"`
+	Content []interface{} `json:"content"`
 }
 
 // ADFTableCell represents a table cell in ADF.
+// Type is "tableHeader" for cells in the header row and "tableCell" otherwise.
 type ADFTableCell struct {
 	Type    string        `json:"type"`
 	Content []interface{} `json:"content"`
@@ -182,8 +217,43 @@ type ADFPanel struct {
 }
 
 // PanelAttrs represents the attributes of a panel in ADF.
+// The icon and color attributes only apply to panels of type "custom".
 type PanelAttrs struct {
-	PanelType string `json:"panelType"`
+	PanelType     string `json:"panelType"`
+	PanelIcon     string `json:"panelIcon,omitempty"`
+	PanelIconText string `json:"panelIconText,omitempty"`
+	PanelColor    string `json:"panelColor,omitempty"`
+}
+
+// ADFExpand represents a collapsible section in ADF.
+type ADFExpand struct {
+	Type    string        `json:"type"`
+	Attrs   ExpandAttrs   `json:"attrs"`
+	Content []interface{} `json:"content"`
+}
+
+// ExpandAttrs represents the attributes of an expand in ADF.
+type ExpandAttrs struct {
+	Title string `json:"title"`
+}
+
+// ADFExtension represents a Confluence macro in ADF. Type is "extension" for
+// block macros, "inlineExtension" for inline macros and "bodiedExtension" for
+// macros with a rich-text body.
+type ADFExtension struct {
+	Type    string         `json:"type"`
+	Attrs   ExtensionAttrs `json:"attrs"`
+	Content []interface{}  `json:"content,omitempty"`
+}
+
+// ExtensionAttrs represents the attributes of an extension in ADF.
+type ExtensionAttrs struct {
+	ExtensionType string                 `json:"extensionType"`
+	ExtensionKey  string                 `json:"extensionKey"`
+	Parameters    map[string]interface{} `json:"parameters,omitempty"`
+	Text          string                 `json:"text,omitempty"`
+	Layout        string                 `json:"layout,omitempty"`
+	LocalID       string                 `json:"localId,omitempty"`
 }
 
 // Add new types for emojis, placeholders, task lists, and decision items
@@ -195,6 +265,8 @@ type ADFEmoji struct {
 
 type EmojiAttrs struct {
 	ShortName string `json:"shortName"`
+	ID        string `json:"id,omitempty"`
+	Text      string `json:"text,omitempty"`
 }
 
 type ADFPlaceholder struct {
@@ -206,18 +278,68 @@ type PlaceholderAttrs struct {
 	Text string `json:"text"`
 }
 
+// ADFTaskList represents a list of tasks in ADF. Its content holds task items
+// and nested task lists.
 type ADFTaskList struct {
 	Type    string        `json:"type"`
+	Attrs   TaskListAttrs `json:"attrs"`
+	Content []interface{} `json:"content"`
+}
+
+// TaskListAttrs represents attributes for a task list.
+type TaskListAttrs struct {
+	LocalID string `json:"localId"`
+}
+
+// ADFTaskItem represents a single task in ADF.
+type ADFTaskItem struct {
+	Type    string        `json:"type"`
+	Attrs   TaskItemAttrs `json:"attrs"`
 	Content []interface{} `json:"content"`
 }
 
+// TaskItemAttrs represents attributes for a task item.
+type TaskItemAttrs struct {
+	LocalID string `json:"localId"`
+	State   string `json:"state"` // "TODO" or "DONE"
+}
+
+// ADFHardBreak represents a line break inside inline content in ADF.
+type ADFHardBreak struct {
+	Type string `json:"type"`
+}
+
+// ADFDecisionList represents a list of decisions in ADF.
+type ADFDecisionList struct {
+	Type    string            `json:"type"`
+	Attrs   DecisionListAttrs `json:"attrs"`
+	Content []interface{}     `json:"content"`
+}
+
+// DecisionListAttrs represents attributes for a decision list.
+type DecisionListAttrs struct {
+	LocalID string `json:"localId"`
+}
+
+// ADFDecisionItem represents a single decision in ADF.
 type ADFDecisionItem struct {
-	Type  string            `json:"type"`
-	Attrs DecisionItemAttrs `json:"attrs"`
+	Type    string            `json:"type"`
+	Attrs   DecisionItemAttrs `json:"attrs"`
+	Content []interface{}     `json:"content"`
 }
 
+// DecisionItemAttrs represents attributes for a decision item.
 type DecisionItemAttrs struct {
-	State string `json:"state"`
+	LocalID string `json:"localId"`
+	State   string `json:"state"` // "DECIDED" or "UNDECIDED"
+}
+
+// Attachment represents a file attached to a Confluence page.
+type Attachment struct {
+	ID         string `json:"id"`             // Content ID of the attachment
+	Title      string `json:"title"`          // File name of the attachment
+	FileID     string `json:"fileId"`         // Media file ID that media nodes reference
+	Collection string `json:"collectionName"` // Media collection of the page, "contentId-<page ID>"
 }
 
 // Define the ConfluenceClient interface in the internal/confluence package to avoid circular dependencies
@@ -228,5 +350,5 @@ type ConfluenceAPI interface {
 	// GetPageByTitle retrieves a page by its title in the specified space.
 	GetPageByTitle(spaceKey, title string) (*Page, error)
 	// UploadAttachment uploads a file as an attachment to the specified page.
-	UploadAttachment(pageID, filePath string) error
+	UploadAttachment(pageID, filePath string) (*Attachment, error)
 }
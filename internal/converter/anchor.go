@@ -0,0 +1,116 @@
+package converter
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// HeadingAnchors maps the fragments that Markdown links may use for the
+// headings of a document to the anchors Confluence generates for them. Each
+// heading is reachable by its GitHub-style slug and, when it has one, by its
+// explicit {#id} attribute.
+func HeadingAnchors(doc ast.Node, source []byte) map[string]string {
+	anchors := make(map[string]string)
+	slugs := make(map[string]int)
+	names := make(map[string]int)
+
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering || n.Kind() != ast.KindHeading {
+			return ast.WalkContinue, nil
+		}
+
+		text := headingText(n, source)
+		anchor := confluenceAnchor(text, names)
+		if id, ok := n.AttributeString("id"); ok {
+			if id, ok := id.([]byte); ok {
+				anchors[string(id)] = anchor
+			}
+		}
+		if slug := githubSlug(text, slugs); anchors[slug] == "" {
+			anchors[slug] = anchor
+		}
+		return ast.WalkSkipChildren, nil
+	})
+
+	return anchors
+}
+
+// headingText returns the plain text of a heading.
+func headingText(n ast.Node, source []byte) string {
+	var b strings.Builder
+	_ = ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+		if v, ok := c.(*ast.Text); ok && entering {
+			if v.IsRaw() {
+				b.Write(v.Segment.Value(source))
+			} else {
+				b.WriteString(decodeText(v.Segment.Value(source)))
+			}
+			if v.SoftLineBreak() {
+				b.WriteByte(' ')
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.TrimSpace(b.String())
+}
+
+// HeadingSlug returns the GitHub-style fragment of a heading text.
+func HeadingSlug(text string) string {
+	return githubSlug(text, map[string]int{})
+}
+
+// githubSlug returns the fragment GitHub generates for a heading: the
+// lower-cased text without punctuation, with spaces turned into hyphens and
+// a counter appended to repeated slugs.
+func githubSlug(text string, seen map[string]int) string {
+	slug := strings.Map(func(r rune) rune {
+		switch {
+		case r == ' ':
+			return '-'
+		case r == '-' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, text)
+
+	count := seen[slug]
+	seen[slug]++
+	if count > 0 {
+		slug += "-" + strconv.Itoa(count)
+	}
+	return slug
+}
+
+// confluenceAnchor returns the anchor Confluence generates for a heading:
+// the text with runs of whitespace turned into hyphens, percent-encoded, and
+// ".1", ".2"... appended to repeated anchors.
+func confluenceAnchor(text string, seen map[string]int) string {
+	anchor := url.PathEscape(strings.Join(strings.Fields(text), "-"))
+
+	count := seen[anchor]
+	seen[anchor]++
+	if count > 0 {
+		anchor += "." + strconv.Itoa(count)
+	}
+	return anchor
+}
+
+// linkTarget returns the href of a Markdown link destination, rewriting
+// fragments that point at a heading of the page to its Confluence anchor and
+// passing other destinations to the ResolveLink option.
+func (r *renderer) linkTarget(destination string) string {
+	if fragment, ok := strings.CutPrefix(destination, "#"); ok {
+		if anchor, ok := r.anchors[fragment]; ok {
+			return "#" + anchor
+		}
+		return destination
+	}
+	if r.options.ResolveLink != nil {
+		return r.options.ResolveLink(destination)
+	}
+	return destination
+}
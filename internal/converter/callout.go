@@ -0,0 +1,145 @@
+package converter
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+
+	"go-markdown-confluence/internal/confluence"
+)
+
+// calloutStyle describes the ADF panel a callout type is rendered as.
+type calloutStyle struct {
+	panelType string
+	icon      string // Emoji short name of a custom panel
+	iconText  string // Emoji character of a custom panel
+	color     string // Background color of a custom panel
+}
+
+// calloutStyles maps Obsidian and GitHub callout types, including their
+// aliases, to ADF panels. Types without a matching ADF panel type use a
+// custom panel. Unknown types are rendered like "note".
+var calloutStyles = map[string]calloutStyle{
+	"note":      {panelType: "note"},
+	"info":      {panelType: "info"},
+	"todo":      {panelType: "info"},
+	"tip":       {panelType: "tip"},
+	"hint":      {panelType: "tip"},
+	"important": {panelType: "tip"},
+	"success":   {panelType: "success"},
+	"check":     {panelType: "success"},
+	"done":      {panelType: "success"},
+	"warning":   {panelType: "warning"},
+	"caution":   {panelType: "warning"},
+	"attention": {panelType: "warning"},
+	"failure":   {panelType: "error"},
+	"fail":      {panelType: "error"},
+	"missing":   {panelType: "error"},
+	"danger":    {panelType: "error"},
+	"error":     {panelType: "error"},
+	"abstract":  {panelType: "custom", icon: ":clipboard:", iconText: "📋", color: "#E6FCFF"},
+	"summary":   {panelType: "custom", icon: ":clipboard:", iconText: "📋", color: "#E6FCFF"},
+	"tldr":      {panelType: "custom", icon: ":clipboard:", iconText: "📋", color: "#E6FCFF"},
+	"question":  {panelType: "custom", icon: ":question:", iconText: "❓", color: "#FFF7D6"},
+	"help":      {panelType: "custom", icon: ":question:", iconText: "❓", color: "#FFF7D6"},
+	"faq":       {panelType: "custom", icon: ":question:", iconText: "❓", color: "#FFF7D6"},
+	"bug":       {panelType: "custom", icon: ":beetle:", iconText: "🐞", color: "#FFEBE6"},
+	"example":   {panelType: "custom", icon: ":bookmark_tabs:", iconText: "📑", color: "#EAE6FF"},
+	"quote":     {panelType: "custom", icon: ":speech_balloon:", iconText: "💬", color: "#F4F5F7"},
+	"cite":      {panelType: "custom", icon: ":speech_balloon:", iconText: "💬", color: "#F4F5F7"},
+}
+
+// calloutHeader matches the first line of a callout: the type, an optional
+// fold marker and an optional title.
+var calloutHeader = regexp.MustCompile(`^\[!([A-Za-z][\w-]*)\]([+-]?)[ \t]*`)
+
+// callout is a parsed `> [!TYPE] Title` header.
+type callout struct {
+	kind      string // Lower-cased callout type
+	foldable  bool   // Whether the header has a "+" or "-" fold marker
+	title     string // Plain-text title, empty when none was given
+	headerEnd int    // Source offset of the end of the header line
+}
+
+// parseCallout parses the callout header of the blockquote n.
+func parseCallout(n ast.Node, source []byte) (*callout, bool) {
+	if n.Kind() != ast.KindBlockquote {
+		return nil, false
+	}
+	first := n.FirstChild()
+	if first == nil || first.Kind() != ast.KindParagraph || first.Lines().Len() == 0 {
+		return nil, false
+	}
+
+	line := first.Lines().At(0)
+	value := line.Value(source)
+	m := calloutHeader.FindSubmatchIndex(value)
+	if m == nil {
+		return nil, false
+	}
+
+	c := &callout{
+		kind:      strings.ToLower(string(value[m[2]:m[3]])),
+		foldable:  m[5] > m[4],
+		headerEnd: line.Stop,
+	}
+
+	// The title is taken from the rendered text of the header line, so that
+	// inline Markdown such as emphasis does not leak into it.
+	titleStart := line.Start + m[1]
+	var title strings.Builder
+	_ = ast.Walk(first, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+		if text, ok := c.(*ast.Text); ok && entering {
+			segment := text.Segment
+			if segment.Stop <= titleStart || segment.Start >= line.Stop {
+				return ast.WalkContinue, nil
+			}
+			segment = segment.WithStart(max(segment.Start, titleStart))
+			title.Write(segment.Value(source))
+		}
+		return ast.WalkContinue, nil
+	})
+	c.title = strings.TrimSpace(title.String())
+
+	return c, true
+}
+
+// isCalloutHeader reports whether the paragraph n consists only of the header
+// line of a callout, in which case it produces no content of its own.
+func isCalloutHeader(n ast.Node, source []byte) bool {
+	parent := n.Parent()
+	if parent == nil || parent.FirstChild() != n || n.Lines().Len() != 1 {
+		return false
+	}
+	_, ok := parseCallout(parent, source)
+	return ok
+}
+
+// newCalloutPanel returns the ADF panel for a callout of the given type.
+func newCalloutPanel(kind string) *confluence.ADFPanel {
+	style, ok := calloutStyles[kind]
+	if !ok {
+		style = calloutStyles["note"]
+	}
+
+	return &confluence.ADFPanel{
+		Type: "panel",
+		Attrs: confluence.PanelAttrs{
+			PanelType:     style.panelType,
+			PanelIcon:     style.icon,
+			PanelIconText: style.iconText,
+			PanelColor:    style.color,
+		},
+		Content: []interface{}{},
+	}
+}
+
+// calloutTitle returns the title shown for a foldable callout, falling back
+// to the capitalized callout type like Obsidian does.
+func calloutTitle(c *callout) string {
+	if c.title != "" {
+		return c.title
+	}
+	return strings.ToUpper(c.kind[:1]) + c.kind[1:]
+}
@@ -0,0 +1,77 @@
+package converter
+
+import (
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// decisionPrefixes maps the line prefixes that mark a decision to the ADF
+// decision state they produce.
+var decisionPrefixes = []struct {
+	prefix string
+	state  string
+}{
+	{prefix: "decision:", state: "DECIDED"},
+	{prefix: "undecided:", state: "UNDECIDED"},
+}
+
+// decisionPrefix inspects the first line of the block n. When it starts with
+// a decision prefix, it returns the decision state and the source offset at
+// which the decision text begins.
+func decisionPrefix(n ast.Node, source []byte) (state string, textStart int, ok bool) {
+	if n == nil || n.Type() != ast.TypeBlock || n.Lines().Len() == 0 {
+		return "", 0, false
+	}
+
+	line := n.Lines().At(0)
+	value := line.Value(source)
+	trimmed := strings.TrimLeft(string(value), " \t")
+	for _, p := range decisionPrefixes {
+		if !strings.HasPrefix(strings.ToLower(trimmed), p.prefix) {
+			continue
+		}
+		rest := trimmed[len(p.prefix):]
+		textStart = line.Start + len(value) - len(strings.TrimLeft(rest, " \t"))
+		return p.state, textStart, true
+	}
+	return "", 0, false
+}
+
+// isDecisionQuote reports whether the blockquote n renders as an ADF
+// decisionList, which is the case when every child of the quote is a
+// paragraph that starts with a decision prefix. Each paragraph becomes a
+// decision item; any other quote stays a blockquote.
+func isDecisionQuote(n ast.Node, source []byte) bool {
+	if n.Kind() != ast.KindBlockquote || !n.HasChildren() {
+		return false
+	}
+	for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+		if child.Kind() != ast.KindParagraph {
+			return false
+		}
+		if _, _, ok := decisionPrefix(child, source); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// isDecisionList reports whether the list n renders as an ADF decisionList,
+// which is the case when every item starts with a decision prefix.
+func isDecisionList(n ast.Node, source []byte) bool {
+	if n.Kind() != ast.KindList || !n.HasChildren() {
+		return false
+	}
+	for item := n.FirstChild(); item != nil; item = item.NextSibling() {
+		if _, _, ok := decisionPrefix(item.FirstChild(), source); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// isDecisionItem reports whether n is an item of a decision list.
+func isDecisionItem(n ast.Node, source []byte) bool {
+	return n != nil && n.Kind() == ast.KindListItem && isDecisionList(n.Parent(), source)
+}
@@ -0,0 +1,116 @@
+package converter
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+
+	"go-markdown-confluence/internal/adfschema"
+	"go-markdown-confluence/internal/confluence"
+)
+
+var (
+	detailsStart    = regexp.MustCompile(`(?i)^\s*<details(\s[^>]*)?>`)
+	detailsOpenTag  = regexp.MustCompile(`(?i)<details(\s[^>]*)?>`)
+	detailsCloseTag = regexp.MustCompile(`(?i)</details\s*>`)
+	summaryElement  = regexp.MustCompile(`(?is)<summary(\s[^>]*)?>(.*?)</summary\s*>`)
+	htmlTag         = regexp.MustCompile(`<[^>]*>`)
+)
+
+// htmlBlockText returns the raw source of an HTML block.
+func htmlBlockText(n *ast.HTMLBlock, source []byte) string {
+	var text strings.Builder
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		text.Write(line.Value(source))
+	}
+	if n.HasClosure() {
+		text.Write(n.ClosureLine.Value(source))
+	}
+	return text.String()
+}
+
+// expandType returns the ADF type for a collapsible section at the current
+// position: the type that the innermost open container allowing either one
+// of them takes, which is nestedExpand in table cells and expands. Where no
+// container allows one, as in list items and blockquotes, appendBlock
+// publishes the expand after the container.
+func (r *renderer) expandType() string {
+	for i := len(r.stack) - 1; i >= 0; i-- {
+		switch adfType := r.stack[i].adfType; {
+		case adfschema.Allows(adfType, "expand"):
+			return "expand"
+		case adfschema.Allows(adfType, "nestedExpand"):
+			return "nestedExpand"
+		}
+	}
+	return "expand"
+}
+
+// openDetails renders an HTML block that starts a <details> element as an
+// expand titled with the <summary> text. Markdown that follows the summary
+// in the same block is converted into the expand. Goldmark ends an HTML block
+// at the first blank line, so the Markdown blocks after it up to the sibling
+// HTML block that closes the element also become the expand's content.
+func (r *renderer) openDetails(n *ast.HTMLBlock, text string) error {
+	open := detailsStart.FindStringIndex(text)
+	body := text[open[1]:]
+
+	title := ""
+	if m := summaryElement.FindStringSubmatchIndex(body); m != nil {
+		title = strings.TrimSpace(html.UnescapeString(htmlTag.ReplaceAllString(body[m[4]:m[5]], "")))
+		body = body[m[1]:]
+	}
+
+	expand := &confluence.ADFExpand{
+		Type:    r.expandType(),
+		Attrs:   confluence.ExpandAttrs{Title: title},
+		Content: []interface{}{},
+	}
+	r.appendBlock(expand)
+
+	// The container stays open until the walk leaves the node that holds
+	// the closing tag, or the parent when the element is never closed.
+	var closer ast.Node = n
+	if end := detailsCloseTag.FindStringIndex(body); end != nil {
+		body = body[:end[0]]
+	} else if closer = detailsCloser(n, r.source); closer == nil {
+		closer = n.Parent()
+	}
+	r.push(closer, expand.Type, &expand.Content)
+	r.top().fill = true
+
+	return r.renderMarkdown(body)
+}
+
+// closeDetails renders any Markdown in front of the closing </details> tag of
+// the HTML block n that closes the innermost open expand.
+func (r *renderer) closeDetails(n *ast.HTMLBlock, text string) error {
+	if r.top().node != n {
+		return nil
+	}
+	end := detailsCloseTag.FindStringIndex(text)
+	return r.renderMarkdown(text[:end[0]])
+}
+
+// detailsCloser returns the sibling HTML block that closes the <details>
+// element opened by n, taking nested elements into account.
+func detailsCloser(n *ast.HTMLBlock, source []byte) ast.Node {
+	depth := 1
+	for s := n.NextSibling(); s != nil; s = s.NextSibling() {
+		block, ok := s.(*ast.HTMLBlock)
+		if !ok {
+			continue
+		}
+		text := htmlBlockText(block, source)
+		depth += len(detailsOpenTag.FindAllStringIndex(text, -1))
+		depth -= len(detailsCloseTag.FindAllStringIndex(text, -1))
+		if depth <= 0 {
+			return s
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package converter
+
+import (
+	"go-markdown-confluence/internal/confluence"
+	"go-markdown-confluence/internal/emoji"
+	"go-markdown-confluence/internal/parser"
+)
+
+// renderEmoji appends the emoji named by a shortcode, looking it up in the
+// custom emoji of the options before the bundled table. Unknown shortcodes
+// are kept as text.
+func (r *renderer) renderEmoji(n *parser.Emoji) {
+	name := n.Name(r.source)
+	e, ok := r.options.Emoji[name]
+	if !ok {
+		e, ok = emoji.Lookup(name)
+	}
+	if !ok {
+		r.appendInline(&confluence.ADFText{
+			Type:  "text",
+			Text:  string(n.Segment.Value(r.source)),
+			Marks: r.inlineMarks(n),
+		})
+		return
+	}
+
+	r.appendInline(&confluence.ADFEmoji{
+		Type: "emoji",
+		Attrs: confluence.EmojiAttrs{
+			ShortName: e.ShortName,
+			ID:        e.ID,
+			Text:      e.Text,
+		},
+	})
+}
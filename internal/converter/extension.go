@@ -0,0 +1,97 @@
+package converter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+
+	"go-markdown-confluence/internal/confluence"
+	"go-markdown-confluence/internal/parser"
+)
+
+// macroExtensionType is the extension type of built-in Confluence macros.
+const macroExtensionType = "com.atlassian.confluence.macro.core"
+
+// newMacro returns an extension node of the given type ("extension",
+// "inlineExtension" or "bodiedExtension") for the Confluence macro key with
+// the given macro parameters.
+func newMacro(nodeType, key string, params map[string]string) *confluence.ADFExtension {
+	macroParams := make(map[string]interface{}, len(params))
+	for name, value := range params {
+		macroParams[name] = map[string]string{"value": value}
+	}
+
+	return &confluence.ADFExtension{
+		Type: nodeType,
+		Attrs: confluence.ExtensionAttrs{
+			ExtensionType: macroExtensionType,
+			ExtensionKey:  key,
+			Parameters: map[string]interface{}{
+				"macroParams": macroParams,
+			},
+		},
+	}
+}
+
+// anchorMacro returns an inline anchor macro that in-page links can target
+// with "#name".
+func anchorMacro(name string) *confluence.ADFExtension {
+	return newMacro("inlineExtension", "anchor", map[string]string{"": name})
+}
+
+// macroParameter matches a macro parameter: key=value, key="quoted value" or
+// a value without a key, which is the macro's default parameter.
+var macroParameter = regexp.MustCompile(`(?:([^\s="]+)=)?("(?:[^"\\]|\\.)*"|\S+)`)
+
+// macroParameters parses the parameters of a macro written in Markdown.
+func macroParameters(text string) map[string]string {
+	params := make(map[string]string)
+	for _, m := range macroParameter.FindAllStringSubmatch(text, -1) {
+		value := m[2]
+		if unquoted, err := strconv.Unquote(value); err == nil && strings.HasPrefix(value, `"`) {
+			value = unquoted
+		}
+		params[m[1]] = value
+	}
+	return params
+}
+
+// renderMacroFence renders a confluence-macro fence, whose info string names
+// the macro and holds its parameters:
+//
+//	```confluence-macro toc maxLevel=3
+//	```
+//
+// A fence without a body becomes an extension; the Markdown body of any
+// other fence is converted into a bodiedExtension.
+func (r *renderer) renderMacroFence(n *ast.FencedCodeBlock) error {
+	info := string(n.Info.Segment.Value(r.source))
+	fields := strings.Fields(info)
+	if len(fields) < 2 {
+		return fmt.Errorf("confluence-macro block at line %d has no macro name", r.lineOf(n.Info.Segment.Start))
+	}
+	name := fields[1]
+	rest := strings.TrimSpace(info)
+	rest = strings.TrimSpace(rest[len(fields[0]):])
+	params := macroParameters(rest[len(name):])
+
+	body := string(n.Lines().Value(r.source))
+	if strings.TrimSpace(body) == "" {
+		r.appendBlock(newMacro("extension", name, params))
+		return nil
+	}
+
+	macro := newMacro("bodiedExtension", name, params)
+	macro.Content = []interface{}{}
+	r.appendBlock(macro)
+	r.push(n, macro.Type, &macro.Content)
+	return r.renderMarkdown(body)
+}
+
+// renderInlineMacro appends an inline {{macro:name parameters}} macro.
+func (r *renderer) renderInlineMacro(n *parser.Macro) {
+	r.appendInline(newMacro("inlineExtension", n.Name, macroParameters(n.Parameters)))
+}
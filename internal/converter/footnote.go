@@ -0,0 +1,100 @@
+package converter
+
+import (
+	"fmt"
+
+	"github.com/yuin/goldmark/ast"
+	extast "github.com/yuin/goldmark/extension/ast"
+
+	"go-markdown-confluence/internal/confluence"
+)
+
+// footnoteAnchor returns the anchor name of the footnote with the given index.
+func footnoteAnchor(index int) string {
+	return fmt.Sprintf("fn-%d", index)
+}
+
+// footnoteRefAnchor returns the anchor name of a reference to a footnote.
+// Repeated references to the same footnote get distinct anchors so that each
+// back-link returns to its own reference.
+func footnoteRefAnchor(index, refIndex int) string {
+	if refIndex == 0 {
+		return fmt.Sprintf("fnref-%d", index)
+	}
+	return fmt.Sprintf("fnref-%d-%d", index, refIndex)
+}
+
+// renderFootnoteLink renders a footnote reference as an anchor followed by
+// the footnote number in superscript, linked to the footnote body. The
+// number keeps the marks of the text around it, except that ADF does not
+// combine code with superscript, allows a single link and a single
+// superscript or subscript mark, so an enclosing link or code span is
+// dropped and an enclosing <sup> or <sub> element replaces the superscript.
+func (r *renderer) renderFootnoteLink(n *extast.FootnoteLink) {
+	r.appendInline(anchorMacro(footnoteRefAnchor(n.Index, n.RefIndex)))
+
+	var marks []confluence.Mark
+	for _, m := range r.inlineMarks(n) {
+		if m.Type != "code" && m.Type != "link" {
+			marks = append(marks, m)
+		}
+	}
+	marks = append(marks, linkMark("#"+footnoteAnchor(n.Index), ""))
+	if !hasMarkType(marks, "subsup") {
+		marks = append(marks, confluence.Mark{Type: "subsup", Attrs: &confluence.MarkAttrs{Type: "sup"}})
+	}
+	r.appendInline(&confluence.ADFText{
+		Type:  "text",
+		Text:  fmt.Sprintf("[%d]", n.Index),
+		Marks: marks,
+	})
+}
+
+// renderFootnoteBacklink renders the link from a footnote body back to the
+// reference it belongs to. When the body does not end with a paragraph, the
+// back-links get a paragraph of their own that stays open until the footnote
+// is closed.
+func (r *renderer) renderFootnoteBacklink(n *extast.FootnoteBacklink) {
+	if c := r.top(); !c.inline {
+		paragraph := &confluence.ADFParagraph{
+			Type:    "paragraph",
+			Content: []interface{}{},
+		}
+		r.appendBlock(paragraph)
+		r.push(n.Parent(), "paragraph", &paragraph.Content)
+	}
+
+	if len(*r.top().content) > 0 {
+		r.appendInline(&confluence.ADFText{Type: "text", Text: " "})
+	}
+	r.appendInline(&confluence.ADFText{
+		Type:  "text",
+		Text:  "↩",
+		Marks: []confluence.Mark{linkMark("#"+footnoteRefAnchor(n.Index, n.RefIndex), "")},
+	})
+}
+
+// openFootnoteList appends the footnotes section, a heading followed by an
+// ordered list with one item per footnote, and opens the list as the current
+// container.
+func (r *renderer) openFootnoteList(n *extast.FootnoteList) {
+	r.appendBlock(&confluence.ADFHeading{
+		Type:    "heading",
+		Attrs:   confluence.HeadingAttrs{Level: 2},
+		Content: []interface{}{&confluence.ADFText{Type: "text", Text: r.options.FootnotesTitle}},
+	})
+
+	list := &confluence.ADFList{
+		Type:    "orderedList",
+		Content: []interface{}{},
+	}
+	r.appendBlock(list)
+	r.push(n, "orderedList", &list.Content)
+}
+
+// isFootnoteStart reports whether the paragraph n is the first block of a
+// footnote body, which receives the footnote's anchor.
+func isFootnoteStart(n ast.Node) (*extast.Footnote, bool) {
+	footnote, ok := n.Parent().(*extast.Footnote)
+	return footnote, ok && n.PreviousSibling() == nil
+}
@@ -0,0 +1,159 @@
+package converter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+
+	"go-markdown-confluence/internal/confluence"
+)
+
+// htmlTagPattern matches a single opening, closing or self-closing tag.
+var htmlTagPattern = regexp.MustCompile(`^<(/?)([A-Za-z][A-Za-z0-9-]*)(?:\s[^>]*)?(/?)>$`)
+
+// backgroundStyle matches the background color of a tag's style attribute,
+// in the six-digit hex form that ADF colors take.
+var backgroundStyle = regexp.MustCompile(`(?i)\sstyle\s*=\s*["'][^"']*\bbackground(?:-color)?\s*:\s*(#[0-9a-f]{6})\b`)
+
+// markHighlight is the background color of <mark> elements that set none
+// in their style: the light orange of the Confluence editor's highlight
+// palette, the closest to the yellow browsers highlight <mark> with.
+const markHighlight = "#fedec8"
+
+// htmlMarks maps the supported inline HTML elements to the ADF mark applied
+// to the text they contain.
+var htmlMarks = map[string]confluence.Mark{
+	"sub":    {Type: "subsup", Attrs: &confluence.MarkAttrs{Type: "sub"}},
+	"sup":    {Type: "subsup", Attrs: &confluence.MarkAttrs{Type: "sup"}},
+	"u":      {Type: "underline"},
+	"ins":    {Type: "underline"},
+	"del":    {Type: "strike"},
+	"s":      {Type: "strike"},
+	"strike": {Type: "strike"},
+	"b":      {Type: "strong"},
+	"strong": {Type: "strong"},
+	"i":      {Type: "em"},
+	"em":     {Type: "em"},
+	"code":   {Type: "code"},
+	"kbd":    {Type: "code"},
+	"mark":   {Type: "backgroundColor", Attrs: &confluence.MarkAttrs{Color: markHighlight}},
+}
+
+// htmlMark returns the mark for the supported inline HTML element tag, whose
+// source is text. A <mark> element takes the background color of its style.
+func htmlMark(tag, text string) (confluence.Mark, bool) {
+	mark, supported := htmlMarks[tag]
+	if tag == "mark" {
+		if m := backgroundStyle.FindStringSubmatch(text); m != nil {
+			mark.Attrs = &confluence.MarkAttrs{Color: strings.ToLower(m[1])}
+		}
+	}
+	return mark, supported
+}
+
+// htmlElement is an inline HTML element that is open in a container.
+type htmlElement struct {
+	tag  string
+	mark confluence.Mark // Mark applied to the element's text
+}
+
+// renderRawHTML renders an inline HTML tag. Tags of the supported subset open
+// or close a mark, <br> becomes a hard break, comments are dropped and other
+// tags are handled according to Options.UnsupportedHTML.
+func (r *renderer) renderRawHTML(n *ast.RawHTML) error {
+	var raw strings.Builder
+	for i := 0; i < n.Segments.Len(); i++ {
+		segment := n.Segments.At(i)
+		raw.Write(segment.Value(r.source))
+	}
+	text := raw.String()
+
+	if strings.HasPrefix(text, "<!--") {
+		return nil
+	}
+
+	m := htmlTagPattern.FindStringSubmatch(text)
+	if m == nil {
+		return r.unsupportedHTML(text, n.Segments.At(0).Start)
+	}
+	closing, tag, selfClosing := m[1] == "/", strings.ToLower(m[2]), m[3] == "/"
+
+	if tag == "br" {
+		r.appendInline(&confluence.ADFHardBreak{Type: "hardBreak"})
+		return nil
+	}
+
+	mark, supported := htmlMark(tag, text)
+	if !supported {
+		return r.unsupportedHTML(text, n.Segments.At(0).Start)
+	}
+
+	c := r.top()
+	switch {
+	case selfClosing:
+	case closing:
+		for i := len(c.htmlElements) - 1; i >= 0; i-- {
+			if c.htmlElements[i].tag == tag {
+				c.htmlElements = append(c.htmlElements[:i], c.htmlElements[i+1:]...)
+				break
+			}
+		}
+	default:
+		c.htmlElements = append(c.htmlElements, htmlElement{tag: tag, mark: mark})
+	}
+	return nil
+}
+
+// renderHTMLBlock handles an HTML block that has no ADF equivalent according
+// to Options.UnsupportedHTML. Kept blocks are published line by line as
+// literal text.
+func (r *renderer) renderHTMLBlock(n *ast.HTMLBlock, text string) error {
+	if n.Lines().Len() == 0 || strings.HasPrefix(strings.TrimSpace(text), "<!--") {
+		return nil
+	}
+
+	switch r.options.UnsupportedHTML {
+	case HTMLKeep:
+		paragraph := &confluence.ADFParagraph{
+			Type:    "paragraph",
+			Content: []interface{}{},
+		}
+		for i, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+			if i > 0 {
+				paragraph.Content = append(paragraph.Content, &confluence.ADFHardBreak{Type: "hardBreak"})
+			}
+			// ADF does not allow empty text nodes, so a blank line is
+			// only the hard break.
+			if line != "" {
+				paragraph.Content = append(paragraph.Content, &confluence.ADFText{Type: "text", Text: line})
+			}
+		}
+		r.appendBlock(paragraph)
+	case HTMLFail:
+		return fmt.Errorf("unsupported HTML block at line %d: %s", r.lineOf(n.Lines().At(0).Start), firstLine(text))
+	}
+	return nil
+}
+
+// unsupportedHTML handles an inline HTML tag outside the supported subset
+// according to Options.UnsupportedHTML.
+func (r *renderer) unsupportedHTML(text string, offset int) error {
+	switch r.options.UnsupportedHTML {
+	case HTMLKeep:
+		r.appendInline(&confluence.ADFText{Type: "text", Text: text})
+	case HTMLFail:
+		return fmt.Errorf("unsupported HTML tag at line %d: %s", r.lineOf(offset), text)
+	}
+	return nil
+}
+
+// firstLine returns the first line of text without surrounding whitespace.
+func firstLine(text string) string {
+	text = strings.TrimSpace(text)
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		return text[:i]
+	}
+	return text
+}
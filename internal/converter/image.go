@@ -0,0 +1,206 @@
+package converter
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+
+	"go-markdown-confluence/internal/confluence"
+	"go-markdown-confluence/internal/parser"
+)
+
+// image is an image to publish, written as ![alt](src) or ![[src|alt]].
+type image struct {
+	src    string
+	alt    string
+	width  int    // Width in pixels, if given
+	height int    // Height in pixels, if given
+	layout string // Layout of the mediaSingle, if given
+}
+
+var (
+	// imageAttributes matches the attribute block that may follow an image,
+	// as in ![alt](img.png){width=400 layout=wide}.
+	imageAttributes = regexp.MustCompile(`^\{([^{}\n]*)\}`)
+	// imageSize matches the size that an image embed may give instead of
+	// alt text, as in ![[img.png|400]] or ![[img.png|400x300]].
+	imageSize = regexp.MustCompile(`^(\d+)(?:x(\d+))?$`)
+)
+
+// isImage reports whether n is an image or an image embed.
+func isImage(n ast.Node) bool {
+	if link, ok := n.(*parser.WikiLink); ok {
+		return isImageEmbed(link)
+	}
+	return n.Kind() == ast.KindImage
+}
+
+// imageAttributeBlock returns the key=value fields of the attribute block
+// at the start of value and the length of the block. The block may span
+// several text nodes, so value is the source from the block onwards.
+func imageAttributeBlock(value []byte) ([]string, int, bool) {
+	m := imageAttributes.FindSubmatchIndex(value)
+	if m == nil {
+		return nil, 0, false
+	}
+	fields := strings.Fields(string(value[m[2]:m[3]]))
+	if len(fields) == 0 {
+		return nil, 0, false
+	}
+	for _, field := range fields {
+		if !strings.Contains(field, "=") {
+			return nil, 0, false
+		}
+	}
+	return fields, m[1], true
+}
+
+// imageParagraph reports whether paragraph n holds nothing but images,
+// separated by whitespace, which are then laid out as blocks.
+func imageParagraph(n ast.Node, source []byte) bool {
+	images, skipTo := 0, 0
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if isImage(c) {
+			images++
+			if text, ok := c.NextSibling().(*ast.Text); ok {
+				if _, end, ok := imageAttributeBlock(source[text.Segment.Start:]); ok {
+					skipTo = text.Segment.Start + end
+				}
+			}
+			continue
+		}
+		text, ok := c.(*ast.Text)
+		if !ok {
+			return false
+		}
+		segment := text.Segment
+		if segment.Stop <= skipTo {
+			continue
+		}
+		if segment.Start < skipTo {
+			segment = segment.WithStart(skipTo)
+		}
+		if len(bytes.TrimSpace(segment.Value(source))) > 0 {
+			return false
+		}
+	}
+	return images > 0
+}
+
+// imageOf returns the image that n, an image or an image embed, stands for
+// and the source offset at which the attribute block following it ends, or
+// zero when there is none. Image embeds are resolved with the
+// ResolveWikiLink option.
+func imageOf(n ast.Node, source []byte, options *Options) (image, int) {
+	var img image
+	switch v := n.(type) {
+	case *ast.Image:
+		img = image{src: string(v.Destination), alt: headingText(v, source)}
+
+	case *parser.WikiLink:
+		img.src = v.Target
+		if options.ResolveWikiLink != nil {
+			if resolved, ok := options.ResolveWikiLink(v.Target, ""); ok {
+				img.src = resolved
+			}
+		}
+		if m := imageSize.FindStringSubmatch(v.Alias); m != nil {
+			img.width, _ = strconv.Atoi(m[1])
+			img.height, _ = strconv.Atoi(m[2])
+		} else {
+			img.alt = v.Alias
+		}
+	}
+
+	text, ok := n.NextSibling().(*ast.Text)
+	if !ok {
+		return img, 0
+	}
+	fields, end, ok := imageAttributeBlock(source[text.Segment.Start:])
+	if !ok {
+		return img, 0
+	}
+	for _, field := range fields {
+		key, value, _ := strings.Cut(field, "=")
+		value = strings.Trim(value, `"'`)
+		switch key {
+		case "width":
+			img.width, _ = strconv.Atoi(strings.TrimSuffix(value, "px"))
+		case "height":
+			img.height, _ = strconv.Atoi(strings.TrimSuffix(value, "px"))
+		case "layout":
+			img.layout = value
+		}
+	}
+	return img, text.Segment.Start + end
+}
+
+// media returns the attributes of the media node for img: the attachment
+// that the ResolveImage option publishes it as, or else its URL.
+func (r *renderer) media(img image) confluence.MediaAttrs {
+	attrs := confluence.MediaAttrs{Alt: img.alt, Width: img.width, Height: img.height}
+	if r.options.ResolveImage != nil {
+		if id, collection, ok := r.options.ResolveImage(img.src); ok {
+			attrs.Type, attrs.ID, attrs.Collection = "file", id, collection
+			return attrs
+		}
+	}
+	attrs.Type, attrs.URL = "external", img.src
+	return attrs
+}
+
+// renderBlockImage appends img as a mediaSingle.
+func (r *renderer) renderBlockImage(img image) {
+	r.appendBlock(mediaSingle(img, r.media(img)))
+}
+
+// mediaSingle returns a mediaSingle showing img as media, centered unless
+// the image sets a layout.
+func mediaSingle(img image, media confluence.MediaAttrs) *confluence.ADFMediaSingle {
+	single := &confluence.ADFMediaSingle{
+		Type:    "mediaSingle",
+		Attrs:   confluence.MediaSingleAttrs{Layout: img.layout},
+		Content: []interface{}{&confluence.ADFMedia{Type: "media", Attrs: media}},
+	}
+	if single.Attrs.Layout == "" {
+		single.Attrs.Layout = "center"
+	}
+	if img.width > 0 {
+		single.Attrs.Width, single.Attrs.WidthType = img.width, "pixel"
+	}
+	return single
+}
+
+// renderInlineImage appends img within text. Attachments become mediaInline
+// nodes; ADF has no inline external images, so those are shown as their alt
+// text linked to the image.
+func (r *renderer) renderInlineImage(img image, marks []confluence.Mark) {
+	media := r.media(img)
+	if media.Type == "file" {
+		r.appendInline(&confluence.ADFMedia{Type: "mediaInline", Attrs: media})
+		return
+	}
+
+	text := img.alt
+	if text == "" {
+		text = img.src
+	}
+	r.appendInline(&confluence.ADFText{
+		Type:  "text",
+		Text:  text,
+		Marks: append(marks, linkMark(img.src, "")),
+	})
+}
+
+// renderImage appends the image or image embed n within text and skips the
+// attribute block that follows it.
+func (r *renderer) renderImage(n ast.Node) {
+	img, end := imageOf(n, r.source, r.options)
+	if end > r.skipTo {
+		r.skipTo = end
+	}
+	r.renderInlineImage(img, r.inlineMarks(n))
+}
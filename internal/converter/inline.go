@@ -0,0 +1,199 @@
+package converter
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark/ast"
+	extast "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/util"
+
+	"go-markdown-confluence/internal/confluence"
+)
+
+// inlineMarks returns the marks that apply to the inline node n: the marks of
+// its chain of inline ancestors, outermost first, followed by the marks of
+// inline HTML elements open in the current container. In ADF the code mark
+// may only be combined with link marks, so other formatting is dropped from
+// text inside code spans.
+func (r *renderer) inlineMarks(n ast.Node) []confluence.Mark {
+	var ancestors []ast.Node
+	for p := n.Parent(); p != nil && p.Type() == ast.TypeInline; p = p.Parent() {
+		ancestors = append(ancestors, p)
+	}
+
+	var marks []confluence.Mark
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		var mark confluence.Mark
+		switch v := ancestors[i].(type) {
+		case *ast.Emphasis:
+			mark = confluence.Mark{Type: "strong"}
+			if v.Level == 1 {
+				mark = confluence.Mark{Type: "em"}
+			}
+		case *extast.Strikethrough:
+			mark = confluence.Mark{Type: "strike"}
+		case *ast.CodeSpan:
+			mark = confluence.Mark{Type: "code"}
+		case *ast.Link:
+			mark = linkMark(r.linkTarget(string(v.Destination)), string(v.Title))
+		default:
+			continue
+		}
+		if !hasMark(marks, mark) {
+			marks = append(marks, mark)
+		}
+	}
+
+	for _, element := range r.top().htmlElements {
+		if !hasMark(marks, element.mark) {
+			marks = append(marks, element.mark)
+		}
+	}
+
+	if !hasMark(marks, confluence.Mark{Type: "code"}) {
+		return marks
+	}
+	compatible := marks[:0]
+	for _, m := range marks {
+		if m.Type == "code" || m.Type == "link" {
+			compatible = append(compatible, m)
+		}
+	}
+	return compatible
+}
+
+// renderLineBreak appends the line break that follows the text node n, if
+// any. Hard breaks become hardBreak nodes; soft breaks become a space, or a
+// hardBreak when the PreserveNewlines option is set.
+func (r *renderer) renderLineBreak(n *ast.Text) {
+	switch {
+	case n.HardLineBreak(), n.SoftLineBreak() && r.options.PreserveNewlines:
+		r.appendInline(&confluence.ADFHardBreak{Type: "hardBreak"})
+	case n.SoftLineBreak():
+		r.appendInline(&confluence.ADFText{
+			Type:  "text",
+			Text:  " ",
+			Marks: r.inlineMarks(n),
+		})
+	}
+}
+
+// hasMark reports whether marks already contains a mark equal to mark.
+func hasMark(marks []confluence.Mark, mark confluence.Mark) bool {
+	for _, m := range marks {
+		if sameMark(m, mark) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasMarkType reports whether marks contains a mark of the given type.
+func hasMarkType(marks []confluence.Mark, markType string) bool {
+	for _, m := range marks {
+		if m.Type == markType {
+			return true
+		}
+	}
+	return false
+}
+
+// sameMark reports whether two marks have the same type and attributes.
+func sameMark(a, b confluence.Mark) bool {
+	if a.Type != b.Type {
+		return false
+	}
+	if a.Attrs == nil || b.Attrs == nil {
+		return a.Attrs == b.Attrs
+	}
+	return *a.Attrs == *b.Attrs
+}
+
+// sameMarks reports whether two mark sets are identical, ignoring order.
+func sameMarks(a, b []confluence.Mark) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, m := range a {
+		if !hasMark(b, m) {
+			return false
+		}
+	}
+	return true
+}
+
+// linkMark returns an ADF link mark pointing at href.
+func linkMark(href, title string) confluence.Mark {
+	return confluence.Mark{
+		Type:  "link",
+		Attrs: &confluence.MarkAttrs{Href: href, Title: title},
+	}
+}
+
+// autoLinkURL returns the link target of an autolink, adding the mailto
+// scheme to bare email addresses.
+func autoLinkURL(n *ast.AutoLink, source []byte) string {
+	url := string(n.URL(source))
+	if n.AutoLinkType == ast.AutoLinkEmail && !strings.HasPrefix(strings.ToLower(url), "mailto:") {
+		url = "mailto:" + url
+	}
+	return url
+}
+
+// characterReference matches an HTML entity or numeric character reference
+// at the start of a string.
+var characterReference = regexp.MustCompile(`^&(?:#[xX]([0-9a-fA-F]{1,6})|#([0-9]{1,7})|([a-zA-Z][a-zA-Z0-9]*));`)
+
+// decodeText returns the text of a Markdown text segment with backslash
+// escapes removed and entity and numeric character references decoded, as
+// CommonMark specifies. An escaped ampersand never starts a reference.
+func decodeText(source []byte) string {
+	var b strings.Builder
+	for i := 0; i < len(source); i++ {
+		c := source[i]
+		if c == '\\' && i+1 < len(source) && util.IsPunct(source[i+1]) {
+			b.WriteByte(source[i+1])
+			i++
+			continue
+		}
+		if c == '&' {
+			if m := characterReference.FindSubmatch(source[i:]); m != nil {
+				if decoded, ok := decodeReference(m); ok {
+					b.WriteString(decoded)
+					i += len(m[0]) - 1
+					continue
+				}
+			}
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// decodeReference returns the characters a characterReference match stands
+// for. Invalid code points decode to U+FFFD; unknown entity names are not
+// references.
+func decodeReference(m [][]byte) (string, bool) {
+	if m[3] != nil {
+		entity, ok := util.LookUpHTML5EntityByName(string(m[3]))
+		if !ok {
+			return "", false
+		}
+		return string(entity.Characters), true
+	}
+
+	var v uint64
+	if m[1] != nil {
+		v, _ = strconv.ParseUint(string(m[1]), 16, 32)
+	} else {
+		v, _ = strconv.ParseUint(string(m[2]), 10, 32)
+	}
+	r := rune(v)
+	if r == 0 || !utf8.ValidRune(r) {
+		r = utf8.RuneError
+	}
+	return string(r), true
+}
@@ -0,0 +1,62 @@
+package converter
+
+import (
+	"strings"
+
+	"go-markdown-confluence/internal/confluence"
+)
+
+// renderMath appends a LaTeX expression in the form selected by the Math
+// option. Block expressions become block nodes; inline expressions, even
+// those written with double dollar signs, stay inline.
+func (r *renderer) renderMath(latex string, block bool) {
+	latex = strings.TrimSpace(latex)
+
+	switch r.options.Math {
+	case MathExtension:
+		if block && r.options.MathBlockKey != "" {
+			r.appendBlock(mathMacro("extension", r.options.MathBlockKey, latex))
+			return
+		}
+		if !block && r.options.MathInlineKey != "" {
+			r.appendInline(mathMacro("inlineExtension", r.options.MathInlineKey, latex))
+			return
+		}
+
+	case MathImage:
+		// The rendered file only exists where the page is converted, so
+		// math that is not published as an attachment falls back to code.
+		if r.options.MathRenderer == nil {
+			break
+		}
+		if src, err := r.options.MathRenderer(latex, block); err == nil {
+			img := image{src: src, alt: latex}
+			if media := r.media(img); media.Type == "file" {
+				if block {
+					r.appendBlock(mediaSingle(img, media))
+					return
+				}
+				r.appendInline(&confluence.ADFMedia{Type: "mediaInline", Attrs: media})
+				return
+			}
+		}
+	}
+
+	if block {
+		r.appendBlock(codeBlock("latex", latex))
+		return
+	}
+	r.appendInline(&confluence.ADFText{
+		Type:  "text",
+		Text:  latex,
+		Marks: []confluence.Mark{{Type: "code"}},
+	})
+}
+
+// mathMacro returns a math macro of the given node type whose body is the
+// LaTeX source. The source is also set as the node's fallback text.
+func mathMacro(nodeType, key, latex string) *confluence.ADFExtension {
+	macro := newMacro(nodeType, key, map[string]string{"body": latex})
+	macro.Attrs.Text = latex
+	return macro
+}
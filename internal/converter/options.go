@@ -0,0 +1,119 @@
+package converter
+
+import "go-markdown-confluence/internal/emoji"
+
+// HTMLPolicy decides what happens to HTML that has no ADF equivalent.
+type HTMLPolicy string
+
+const (
+	// HTMLDrop leaves unsupported HTML out of the page.
+	HTMLDrop HTMLPolicy = "drop"
+	// HTMLKeep publishes unsupported HTML as literal text.
+	HTMLKeep HTMLPolicy = "keep"
+	// HTMLFail fails the conversion on unsupported HTML.
+	HTMLFail HTMLPolicy = "fail"
+)
+
+// MathMode decides how LaTeX math is published.
+type MathMode string
+
+const (
+	// MathExtension publishes math as Confluence macros, using
+	// MathBlockKey and MathInlineKey as the extension keys.
+	MathExtension MathMode = "extension"
+	// MathImage publishes math as images rendered by MathRenderer.
+	MathImage MathMode = "image"
+	// MathCode publishes math as its LaTeX source in code marks and
+	// latex code blocks.
+	MathCode MathMode = "code"
+)
+
+// Options controls how Markdown constructs are mapped to ADF nodes, or to
+// the elements of the storage format.
+type Options struct {
+	// TableLayout is the layout attribute applied to every table
+	// ("default", "wide" or "full-width").
+	TableLayout string
+	// TableNumberColumn enables the numbered first column on every table.
+	// The storage format has no equivalent.
+	TableNumberColumn bool
+	// PreserveNewlines renders the line breaks inside a paragraph as hard
+	// breaks instead of spaces.
+	PreserveNewlines bool
+	// FootnotesTitle is the heading of the section that collects footnotes
+	// at the bottom of the page.
+	FootnotesTitle string
+	// UnsupportedHTML decides what happens to HTML tags outside the
+	// supported subset (sub, sup, u, ins, del, s, strike, b, strong, i, em,
+	// code, kbd, mark and br) and to HTML blocks.
+	UnsupportedHTML HTMLPolicy
+	// ResolveLink, when set, returns the href of every link destination that
+	// is not a fragment of the page itself, such as a relative link to
+	// another Markdown file.
+	ResolveLink func(destination string) string
+	// ResolveWikiLink, when set, returns the href of a [[wikilink]] to
+	// another note, or the path of the file an ![[image]] embed names. ok
+	// is false for targets it cannot find.
+	ResolveWikiLink func(target, fragment string) (href string, ok bool)
+	// ResolveImage, when set, returns the media file ID and collection of
+	// the attachment that the image at src is published as. ok is false for
+	// images that are not attachments, which are referenced by URL.
+	ResolveImage func(src string) (id, collection string, ok bool)
+	// ResolvePage, when set, returns the title of the page that a link
+	// destination, such as a relative link to another Markdown file, is
+	// published as, and the anchor the link points at within it. ok is
+	// false for destinations that are not pages, which are resolved with
+	// ResolveLink. It is only used for the storage format, where links to
+	// pages are made by title.
+	ResolvePage func(destination string) (title, anchor string, ok bool)
+	// ResolveWikiPage is like ResolvePage for the target and fragment of a
+	// [[wikilink]]. Targets it cannot find are resolved with
+	// ResolveWikiLink.
+	ResolveWikiPage func(target, fragment string) (title, anchor string, ok bool)
+	// ResolveAttachment, when set, returns the file name of the attachment
+	// that the image at src is published as. ok is false for images that
+	// are not attachments, which are referenced by URL. It is only used for
+	// the storage format, where attachments are referenced by file name.
+	ResolveAttachment func(src string) (filename string, ok bool)
+	// Transclude, when set, returns the Markdown of the note, or of the
+	// heading section or block of the note named by fragment, that an
+	// ![[note]] embed on a line of its own is replaced with. The Markdown is
+	// rendered with the returned options, whose resolvers resolve the links
+	// and images of the note, or with these options when nil.
+	Transclude func(target, fragment string) (markdown string, options *Options, ok bool)
+	// StrictADF fails the conversion on raw ADF that is not valid, instead
+	// of publishing it as a code block.
+	StrictADF bool
+	// Warn, when set, receives problems that do not fail the conversion,
+	// such as raw ADF that is published as code because it is not valid.
+	Warn func(message string)
+	// Emoji holds custom emoji by shortcode name, without colons. They take
+	// precedence over the bundled standard emoji.
+	Emoji map[string]emoji.Emoji
+	// Math decides how $...$ and $$...$$ expressions are published. Math
+	// falls back to MathCode when the extension key for the expression is
+	// empty or the image cannot be rendered.
+	Math MathMode
+	// MathBlockKey is the extension key of the macro used for display math.
+	MathBlockKey string
+	// MathInlineKey is the extension key of the macro used for inline math.
+	MathInlineKey string
+	// MathRenderer renders a LaTeX expression to an image file and returns
+	// its path. Formulas are only published as attachments, so the path
+	// must be one that ResolveImage or ResolveAttachment accepts; math
+	// falls back to MathCode when it is nil.
+	MathRenderer func(latex string, display bool) (string, error)
+}
+
+// DefaultOptions returns the options used by ConvertToADF, and by
+// ConvertToStorage when none are given.
+func DefaultOptions() *Options {
+	return &Options{
+		TableLayout:     "default",
+		FootnotesTitle:  "Footnotes",
+		UnsupportedHTML: HTMLDrop,
+		Math:            MathExtension,
+		MathBlockKey:    "mathblock",
+		MathInlineKey:   "mathinline",
+	}
+}
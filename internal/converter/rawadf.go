@@ -0,0 +1,146 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+
+	"go-markdown-confluence/internal/adfschema"
+	"go-markdown-confluence/internal/confluence"
+)
+
+// rawADFAttribute marks a code span as raw ADF, as in
+// `{"type":"status","attrs":{"text":"DONE","color":"green"}}`{=adf}.
+var rawADFAttribute = []byte("{=adf}")
+
+// isRawADFLanguage reports whether a fence with the given language holds
+// raw ADF.
+func isRawADFLanguage(language string) bool {
+	return language == "adf" || language == "adf-json" || language == "{=adf}"
+}
+
+// parseRawADF decodes raw ADF, which is a node or an array of nodes, and
+// checks every node against the ADF schema. inline reports whether the
+// nodes are inline nodes; block and inline nodes cannot be mixed.
+func parseRawADF(data []byte) (nodes []interface{}, inline bool, err error) {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, false, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	path := "$"
+	nodes, isArray := raw.([]interface{})
+	if !isArray {
+		nodes = []interface{}{raw}
+	}
+	if len(nodes) == 0 {
+		return nil, false, fmt.Errorf("no nodes")
+	}
+
+	for i, node := range nodes {
+		if isArray {
+			path = fmt.Sprintf("$[%d]", i)
+		}
+		object, _ := node.(map[string]interface{})
+		nodeType, _ := object["type"].(string)
+		if nodeType == "doc" {
+			return nil, false, fmt.Errorf("%s: doc node cannot be nested", path)
+		}
+		if violations := adfschema.ValidateNode(node, path); len(violations) > 0 {
+			return nil, false, fmt.Errorf("%s", violations[0])
+		}
+		if i == 0 {
+			inline = adfschema.IsInline(nodeType)
+		} else if adfschema.IsInline(nodeType) != inline {
+			return nil, false, fmt.Errorf("%s: %s node cannot be mixed with %s nodes", path, nodeType, nodeClass(!inline))
+		}
+	}
+	return nodes, inline, nil
+}
+
+// nodeClass names the class of a node for errors.
+func nodeClass(inline bool) string {
+	if inline {
+		return "inline"
+	}
+	return "block"
+}
+
+// renderRawADF appends the raw ADF of a fence that starts at the given
+// line. Inline nodes are wrapped in a paragraph. It returns false when the
+// ADF is invalid and StrictADF is off, and the fence should be rendered as
+// code instead.
+func (r *renderer) renderRawADF(data []byte, line int) (bool, error) {
+	nodes, inline, err := parseRawADF(data)
+	if err != nil {
+		return false, r.rawADFError(fmt.Errorf("invalid ADF at line %d: %w", line, err))
+	}
+
+	if inline && !r.top().inline {
+		r.appendBlock(&confluence.ADFParagraph{Type: "paragraph", Content: nodes})
+		return true, nil
+	}
+	// Raw ADF is placed where it is written, even where the schema does not
+	// allow it, so that validation reports it there.
+	for _, node := range nodes {
+		if inline {
+			r.appendInline(node)
+		} else {
+			r.appendTo(r.top(), node)
+		}
+	}
+	return true, nil
+}
+
+// renderRawCodeSpan appends the raw ADF of a code span followed by {=adf}
+// and skips the attribute. When the ADF is invalid and StrictADF is off, the
+// span is rendered as code. It returns false when n is not raw ADF.
+func (r *renderer) renderRawCodeSpan(n *ast.CodeSpan) (bool, error) {
+	next, ok := n.NextSibling().(*ast.Text)
+	if !ok || n.FirstChild() == nil || !bytes.HasPrefix(r.source[next.Segment.Start:], rawADFAttribute) {
+		return false, nil
+	}
+	r.skipTo = next.Segment.Start + len(rawADFAttribute)
+
+	var data bytes.Buffer
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if text, ok := c.(*ast.Text); ok {
+			data.Write(text.Segment.Value(r.source))
+		}
+	}
+
+	nodes, inline, err := parseRawADF(data.Bytes())
+	if err == nil && !inline {
+		err = fmt.Errorf("block nodes cannot be injected within text")
+	}
+	if err != nil {
+		err = fmt.Errorf("invalid ADF at line %d: %w", r.lineOf(next.Segment.Start), err)
+		if err := r.rawADFError(err); err != nil {
+			return true, err
+		}
+		r.appendInline(&confluence.ADFText{
+			Type:  "text",
+			Text:  strings.ReplaceAll(data.String(), "\n", " "),
+			Marks: r.inlineMarks(n.FirstChild()),
+		})
+		return true, nil
+	}
+
+	for _, node := range nodes {
+		r.appendInline(node)
+	}
+	return true, nil
+}
+
+// rawADFError returns err when StrictADF is set, and otherwise reports it as
+// a warning.
+func (r *renderer) rawADFError(err error) error {
+	if r.options.StrictADF {
+		return err
+	}
+	r.warn(err.Error())
+	return nil
+}
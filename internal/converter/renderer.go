@@ -2,20 +2,42 @@
 package converter
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"reflect"
+	"strconv"
 	"strings"
 
 	"github.com/yuin/goldmark/ast"
+	extast "github.com/yuin/goldmark/extension/ast"
 
+	"go-markdown-confluence/internal/adfschema"
 	"go-markdown-confluence/internal/confluence"
 	"go-markdown-confluence/internal/mermaid"
+	"go-markdown-confluence/internal/parser"
 )
 
-// ConvertToADF converts a parsed AST node to an ADFDocument.
+// ConvertToADF converts a parsed AST node to an ADFDocument using DefaultOptions.
 func ConvertToADF(n ast.Node, source []byte) (*confluence.ADFDocument, error) {
+	return ConvertToADFWithOptions(n, source, nil)
+}
+
+// ConvertToADFWithOptions converts a parsed AST node to an ADFDocument.
+// A nil options value is equivalent to DefaultOptions.
+func ConvertToADFWithOptions(n ast.Node, source []byte, options *Options) (*confluence.ADFDocument, error) {
+	doc, _, err := convert(n, source, options, false)
+	return doc, err
+}
+
+// convert converts a parsed AST node to an ADFDocument. When trackLines is
+// set, it also returns the Markdown line of every ADF node it appended.
+func convert(n ast.Node, source []byte, options *Options, trackLines bool) (*confluence.ADFDocument, map[slot]int, error) {
 	if n == nil {
-		return nil, fmt.Errorf("Invalid Markdown: AST node is nil")
+		return nil, nil, fmt.Errorf("Invalid Markdown: AST node is nil")
+	}
+	if options == nil {
+		options = DefaultOptions()
 	}
 
 	doc := &confluence.ADFDocument{
@@ -23,369 +45,759 @@ func ConvertToADF(n ast.Node, source []byte) (*confluence.ADFDocument, error) {
 		Content: []interface{}{},
 	}
 
-	err := ast.Walk(n, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
-		if entering {
-			switch n.Kind() {
-			case ast.KindDocument:
-			default:
-				if n.Kind() == ast.KindText && len(n.Text(source)) == 0 {
-					return ast.WalkContinue, nil
-				}
+	r := &renderer{
+		source:  source,
+		options: options,
+		anchors: HeadingAnchors(n, source),
+		stack:   []*container{{node: n, adfType: "doc", content: &doc.Content}},
+	}
+	if trackLines {
+		r.lines = make(map[slot]int)
+	}
+
+	if err := ast.Walk(n, r.walk); err != nil {
+		return nil, nil, err
+	}
+
+	return doc, r.lines, nil
+}
+
+// renderer builds an ADF tree while walking a goldmark AST. It keeps a stack
+// of open ADF containers that mirrors the AST nodes currently being visited,
+// so every node is appended to the container opened by its nearest ancestor.
+type renderer struct {
+	source     []byte
+	options    *Options
+	anchors    map[string]string // Confluence anchors by link fragment
+	stack      []*container
+	localID    int      // Last local ID handed out by nextLocalID
+	embedDepth int      // Number of ![[note]] embeds being transcluded
+	skipTo     int      // Source offset before which text is not rendered
+	node       ast.Node // AST node being visited
+
+	lines map[slot]int // Markdown lines of the appended ADF nodes, when tracked
+	line  int          // Markdown line of the node being visited, when tracked
+}
+
+// container is an open ADF node that accepts children.
+type container struct {
+	node    ast.Node       // AST node that opened the container
+	adfType string         // Type of the ADF node
+	content *[]interface{} // Children of the ADF node
+	inline  bool           // Whether the ADF node holds inline content
+	strong  bool           // Whether text in the container is bold, as in headings rendered as paragraphs
+	hoisted bool           // Whether content of the container was appended after it instead
+	fill    bool           // Whether the container gets an empty paragraph when closed empty
+
+	htmlElements []htmlElement // Inline HTML elements open in the container
+}
+
+// inlineContainers lists the ADF node types whose children are inline nodes.
+var inlineContainers = map[string]bool{
+	"paragraph":    true,
+	"heading":      true,
+	"taskItem":     true,
+	"decisionItem": true,
+}
+
+// push opens a new container of the given ADF type for the AST node n.
+func (r *renderer) push(n ast.Node, adfType string, content *[]interface{}) {
+	r.stack = append(r.stack, &container{
+		node:    n,
+		adfType: adfType,
+		content: content,
+		inline:  inlineContainers[adfType],
+	})
+}
+
+// pop closes the containers opened by n, if any. ADF does not allow empty
+// containers, so a container left empty because all of its content was
+// hoisted after it is removed, as is an empty blockquote, and an empty list
+// item, or container marked to be filled, gets an empty paragraph.
+func (r *renderer) pop(n ast.Node) {
+	for len(r.stack) > 1 && r.top().node == n {
+		c := r.top()
+		r.stack = r.stack[:len(r.stack)-1]
+		if len(*c.content) > 0 {
+			continue
+		}
+		switch {
+		case c.hoisted || c.adfType == "blockquote":
+			r.removeNode(c.content)
+		case c.adfType == "listItem" || c.fill:
+			r.appendTo(c, &confluence.ADFParagraph{Type: "paragraph", Content: []interface{}{}})
+		}
+	}
+}
+
+// removeNode removes the node whose children are content from the open
+// container that holds it.
+func (r *renderer) removeNode(content *[]interface{}) {
+	for i := len(r.stack) - 1; i >= 0; i-- {
+		parent := r.stack[i].content
+		for j, node := range *parent {
+			if nodeContent(node) == content {
+				r.removeAt(parent, j)
+				return
 			}
+		}
+	}
+}
 
-			switch n.Kind() {
-			case ast.KindDocument:
-
-			case ast.KindHeading:
-				v := n.(*ast.Heading)
-				heading := &confluence.ADFHeading{
-					Type: "heading",
-					Attrs: confluence.HeadingAttrs{
-						Level: v.Level,
-					},
-					Content: []interface{}{},
-				}
-				doc.Content = append(doc.Content, heading)
+// removeAt removes the i-th node of content, moving the lines tracked for
+// the nodes after it.
+func (r *renderer) removeAt(content *[]interface{}, i int) {
+	*content = append((*content)[:i], (*content)[i+1:]...)
+	if r.lines == nil {
+		return
+	}
+	for j := i; j < len(*content); j++ {
+		if line, ok := r.lines[slot{content, j + 1}]; ok {
+			r.lines[slot{content, j}] = line
+		} else {
+			delete(r.lines, slot{content, j})
+		}
+	}
+	delete(r.lines, slot{content, len(*content)})
+}
 
-			case ast.KindParagraph:
-				paragraph := &confluence.ADFParagraph{
-					Type:    "paragraph",
-					Content: []interface{}{},
-				}
-				doc.Content = append(doc.Content, paragraph)
-
-			case ast.KindText:
-				v := n.(*ast.Text)
-				text := string(v.Segment.Value(source))
-				if strings.HasPrefix(text, "[[") && strings.HasSuffix(text, "]]") {
-					target := strings.TrimSuffix(strings.TrimPrefix(text, "[["), "]]")
-					linkText := target
-					if parts := strings.SplitN(target, "|", 2); len(parts) == 2 {
-						target = parts[0]
-						linkText = parts[1]
-					}
-					link := &confluence.ADFLink{
-						Type:    "link",
-						Attrs:   confluence.LinkAttrs{Href: target},
-						Content: []interface{}{&confluence.ADFText{Type: "text", Text: linkText}},
-					}
-					addToParent(doc, link)
-				} else if strings.HasPrefix(text, ":") && strings.HasSuffix(text, ":") {
-					emoji := &confluence.ADFEmoji{
-						Type: "emoji",
-						Attrs: confluence.EmojiAttrs{
-							ShortName: text,
-						},
-					}
-					addToParent(doc, emoji)
-				} else {
-					textNode := &confluence.ADFText{
-						Type: "text",
-						Text: text,
-					}
-					addToParent(doc, textNode)
-				}
+// top returns the innermost open container.
+func (r *renderer) top() *container {
+	return r.stack[len(r.stack)-1]
+}
 
-			case ast.KindEmphasis:
-				v := n.(*ast.Emphasis)
-				markType := "strong"
-				if v.Level == 1 {
-					markType = "em"
-				}
+// appendBlock adds a block node to the innermost open container that the
+// ADF schema allows it in. Markdown nests blocks more freely than ADF, so a
+// node that the innermost container does not allow, such as a table in a
+// list item, is appended to the nearest enclosing container that does,
+// after the content that container already has.
+func (r *renderer) appendBlock(node interface{}) {
+	r.appendTo(r.blockContainer(nodeType(node)), node)
+}
 
-				parent := getCurrentParent(doc)
-				if parent != nil {
-					if textParent, ok := parent.(*confluence.ADFText); ok {
-						textParent.Marks = append(textParent.Marks, confluence.Mark{Type: markType})
-					}
-				}
+// appendTo adds a node to the container c.
+func (r *renderer) appendTo(c *container, node interface{}) {
+	r.track(c.content)
+	*c.content = append(*c.content, node)
+}
 
-			case ast.KindLink:
-				v := n.(*ast.Link)
-				link := &confluence.ADFLink{
-					Type: "link",
-					Attrs: confluence.LinkAttrs{
-						Href: string(v.Destination),
-					},
-					Content: []interface{}{},
-				}
-				addToParent(doc, link)
-
-			case ast.KindImage:
-				v := n.(*ast.Image)
-				image := &confluence.ADFImage{
-					Type: "image",
-					Attrs: confluence.ImageAttrs{
-						Src: string(v.Destination),
-						Alt: string(v.Title),
-					},
-				}
-				addToParent(doc, image)
-				return ast.WalkSkipChildren, nil
+// blockContainer returns the innermost open container that allows nodes of
+// the given type, warning when it is not the innermost one.
+func (r *renderer) blockContainer(nodeType string) *container {
+	top := r.top()
+	if nodeType == "" || adfschema.Allows(top.adfType, nodeType) {
+		return top
+	}
+	for i := len(r.stack) - 2; i >= 0; i-- {
+		if adfschema.Allows(r.stack[i].adfType, nodeType) {
+			for _, c := range r.stack[i+1:] {
+				c.hoisted = true
+			}
+			r.warn(fmt.Sprintf("%s%s cannot be inside a %s and is published after the %s", nodeType, r.atLine(), top.adfType, r.stack[i+1].adfType))
+			return r.stack[i]
+		}
+	}
+	return top
+}
 
-			case ast.KindCodeBlock, ast.KindFencedCodeBlock:
-				var language string
-				if fenced, ok := n.(*ast.FencedCodeBlock); ok {
-					language = string(fenced.Language(source))
-				}
+// nodeType returns the type of an ADF node: a node type of package
+// confluence, or a node of raw ADF.
+func nodeType(node interface{}) string {
+	if object, ok := node.(map[string]interface{}); ok {
+		t, _ := object["type"].(string)
+		return t
+	}
+	v := reflect.Indirect(reflect.ValueOf(node))
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	if field := v.FieldByName("Type"); field.Kind() == reflect.String {
+		return field.String()
+	}
+	return ""
+}
 
-				lines := n.Lines()
-				var codeText strings.Builder
-				for i := 0; i < lines.Len(); i++ {
-					line := lines.At(i)
-					codeText.Write(line.Value(source))
-				}
+// nodeContent returns the children of an ADF node of package confluence,
+// or nil when it has none.
+func nodeContent(node interface{}) *[]interface{} {
+	v := reflect.Indirect(reflect.ValueOf(node))
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	field := v.FieldByName("Content")
+	if !field.IsValid() || !field.CanAddr() {
+		return nil
+	}
+	content, _ := field.Addr().Interface().(*[]interface{})
+	return content
+}
 
-				codeStr := codeText.String()
+// atLine returns " at line N" for the node being visited, or nothing when
+// its line is unknown.
+func (r *renderer) atLine() string {
+	if r.node == nil {
+		return ""
+	}
+	offset := sourceOffset(r.node)
+	if offset < 0 {
+		return ""
+	}
+	return fmt.Sprintf(" at line %d", r.lineOf(offset))
+}
 
-				if language == "adf" || language == "adf-json" {
-					var raw interface{}
-					if err := json.Unmarshal([]byte(codeStr), &raw); err == nil {
-						doc.Content = append(doc.Content, raw)
-						return ast.WalkSkipChildren, nil
-					}
-				}
+// codeBlock returns a code block of the given language. ADF does not allow
+// empty text nodes, so a block without code has no content.
+func codeBlock(language, code string) *confluence.ADFCodeBlock {
+	block := &confluence.ADFCodeBlock{
+		Type:    "codeBlock",
+		Attrs:   confluence.CodeBlockAttrs{Language: language},
+		Content: []interface{}{},
+	}
+	if code != "" {
+		block.Content = append(block.Content, &confluence.ADFText{Type: "text", Text: code})
+	}
+	return block
+}
 
-				if language == "mermaid" {
-					imgPath, _ := mermaid.RenderDiagram(codeStr)
-					image := &confluence.ADFImage{Type: "image", Attrs: confluence.ImageAttrs{Src: imgPath}}
-					addToParent(doc, image)
-					return ast.WalkSkipChildren, nil
-				}
+// openStrongParagraph opens a container for the heading n where ADF allows
+// no headings, rendering it as bold text: a paragraph, or a line of its own
+// in task and decision items.
+func (r *renderer) openStrongParagraph(n ast.Node) {
+	top := r.top()
+	if top.inline {
+		if len(*top.content) > 0 {
+			r.appendInline(&confluence.ADFHardBreak{Type: "hardBreak"})
+		}
+		r.stack = append(r.stack, &container{node: n, adfType: top.adfType, content: top.content, inline: true, strong: true})
+		return
+	}
 
-				codeBlock := &confluence.ADFCodeBlock{
-					Type:    "codeBlock",
-					Attrs:   confluence.CodeBlockAttrs{Language: language},
-					Content: []interface{}{&confluence.ADFText{Type: "text", Text: codeStr}},
-				}
-				doc.Content = append(doc.Content, codeBlock)
-				return ast.WalkSkipChildren, nil
-
-			case ast.KindCodeSpan:
-				parent := getCurrentParent(doc)
-				if parent != nil {
-					if textParent, ok := parent.(*confluence.ADFText); ok {
-						textParent.Marks = append(textParent.Marks, confluence.Mark{Type: "code"})
-					}
-				}
+	paragraph := &confluence.ADFParagraph{
+		Type:    "paragraph",
+		Content: []interface{}{},
+	}
+	r.appendBlock(paragraph)
+	r.push(n, "paragraph", &paragraph.Content)
+	r.top().strong = true
+}
 
-			// Ensure no additional paragraph content is added for task lists and decision items
-			case ast.KindList:
-				v := n.(*ast.List)
-				isTaskList := false
-				for child := v.FirstChild(); child != nil; child = child.NextSibling() {
-					if item, ok := child.(*ast.ListItem); ok {
-						if item.FirstChild() != nil {
-							text := string(item.FirstChild().Text(source))
-							if len(text) > 0 && (text[0] == '[' && (text[1] == ' ' || text[1] == 'x') && text[2] == ']') {
-								isTaskList = true
-								break
-							}
-						}
-					}
-				}
+// appendInline adds an inline node to the innermost open container, wrapping
+// it in a paragraph when that container only accepts block content. Text
+// that carries the same marks as the preceding text node is merged into it.
+func (r *renderer) appendInline(node interface{}) {
+	c := r.top()
+	if !c.inline {
+		paragraph := &confluence.ADFParagraph{Type: "paragraph"}
+		r.track(&paragraph.Content)
+		paragraph.Content = append(paragraph.Content, node)
+		r.track(c.content)
+		*c.content = append(*c.content, paragraph)
+		return
+	}
 
-				if isTaskList {
-					taskList := &confluence.ADFTaskList{
-						Type:    "taskList",
-						Content: []interface{}{},
-					}
-					doc.Content = append(doc.Content, taskList)
-					return ast.WalkSkipChildren, nil // Ensure proper return values
-				}
+	if text, ok := node.(*confluence.ADFText); ok && c.strong && !hasMark(text.Marks, confluence.Mark{Type: "strong"}) && !hasMark(text.Marks, confluence.Mark{Type: "code"}) {
+		text.Marks = append(text.Marks, confluence.Mark{Type: "strong"})
+	}
+	if text, ok := node.(*confluence.ADFText); ok && len(*c.content) > 0 {
+		if last, ok := (*c.content)[len(*c.content)-1].(*confluence.ADFText); ok && sameMarks(last.Marks, text.Marks) {
+			last.Text += text.Text
+			return
+		}
+	}
+	r.track(c.content)
+	*c.content = append(*c.content, node)
+}
 
-				listType := "bulletList"
-				if v.IsOrdered() {
-					listType = "orderedList"
-				}
+// track records the Markdown line of the node about to be appended to
+// content, when lines are tracked.
+func (r *renderer) track(content *[]interface{}) {
+	if r.lines != nil && r.line > 0 {
+		r.lines[slot{content, len(*content)}] = r.line
+	}
+}
 
-				list := &confluence.ADFList{
-					Type:    listType,
-					Content: []interface{}{},
-				}
-				doc.Content = append(doc.Content, list)
-				return ast.WalkContinue, nil // Ensure proper return values
+// nextLocalID returns a local ID that is unique within the document. IDs are
+// sequential so that converting the same Markdown twice yields the same ADF.
+func (r *renderer) nextLocalID() string {
+	r.localID++
+	return strconv.Itoa(r.localID)
+}
 
-			case ast.KindListItem:
-				listItem := &confluence.ADFListItem{
-					Type:    "listItem",
-					Content: []interface{}{},
-				}
+// openDecisionList appends a decisionList for the list or blockquote n and
+// opens it as the current container.
+func (r *renderer) openDecisionList(n ast.Node) {
+	decisionList := &confluence.ADFDecisionList{
+		Type:    "decisionList",
+		Attrs:   confluence.DecisionListAttrs{LocalID: r.nextLocalID()},
+		Content: []interface{}{},
+	}
+	r.appendBlock(decisionList)
+	r.push(n, "decisionList", &decisionList.Content)
+}
 
-				if len(doc.Content) > 0 {
-					if list, ok := doc.Content[len(doc.Content)-1].(*confluence.ADFList); ok {
-						list.Content = append(list.Content, listItem)
-					}
-				}
+// openDecisionItem appends a decisionItem for the list item or paragraph n
+// and opens it as the current container. The decision prefix on the first
+// line of n is left out of the rendered text.
+func (r *renderer) openDecisionItem(n ast.Node) {
+	block := n
+	if n.Kind() == ast.KindListItem {
+		block = n.FirstChild()
+	}
 
-			case ast.KindThematicBreak:
-				rule := &confluence.ADFRule{
-					Type: "rule",
-				}
-				doc.Content = append(doc.Content, rule)
-
-			case ast.KindBlockquote:
-				v := n.(*ast.Blockquote)
-				textContent := strings.TrimSpace(string(v.Text(source)))
-				if strings.HasPrefix(strings.ToLower(textContent), "[!") {
-					end := strings.Index(textContent, "]")
-					if end != -1 {
-						callout := strings.ToLower(textContent[2:end])
-						content := strings.TrimSpace(textContent[end+1:])
-						panelType := "info"
-						if callout == "warning" || callout == "caution" || callout == "danger" {
-							panelType = "warning"
-						}
-						panel := &confluence.ADFPanel{
-							Type:    "panel",
-							Attrs:   confluence.PanelAttrs{PanelType: panelType},
-							Content: []interface{}{&confluence.ADFParagraph{Type: "paragraph", Content: []interface{}{&confluence.ADFText{Type: "text", Text: content}}}},
-						}
-						doc.Content = append(doc.Content, panel)
-						return ast.WalkSkipChildren, nil
-					}
-				}
-				if strings.HasPrefix(strings.ToLower(textContent), "decision:") {
-					decision := &confluence.ADFDecisionItem{
-						Type: "decisionItem",
-						Attrs: confluence.DecisionItemAttrs{
-							State: "DECIDED",
-						},
-					}
-					doc.Content = append(doc.Content, decision)
-					return ast.WalkSkipChildren, nil // Ensure proper return values
-				}
+	state, textStart, ok := decisionPrefix(block, r.source)
+	if !ok {
+		state = "DECIDED"
+	} else {
+		r.skipTo = textStart
+	}
 
-				blockquote := &confluence.ADFBlockquote{
-					Type:    "blockquote",
-					Content: []interface{}{},
-				}
-				doc.Content = append(doc.Content, blockquote)
-				return ast.WalkContinue, nil // Ensure proper return values
-
-			case ast.KindHTMLBlock:
-				v := n.(*ast.HTMLBlock)
-				if strings.Contains(string(v.Text(source)), "placeholder") {
-					placeholder := &confluence.ADFPlaceholder{
-						Type: "placeholder",
-						Attrs: confluence.PlaceholderAttrs{
-							Text: "Add your content here",
-						},
-					}
-					addToParent(doc, placeholder)
-				}
-			}
+	decisionItem := &confluence.ADFDecisionItem{
+		Type: "decisionItem",
+		Attrs: confluence.DecisionItemAttrs{
+			LocalID: r.nextLocalID(),
+			State:   state,
+		},
+		Content: []interface{}{},
+	}
+	r.appendBlock(decisionItem)
+	r.push(n, "decisionItem", &decisionItem.Content)
+}
+
+// openCallout appends the panel, or the expand for a foldable callout, that
+// renders the callout blockquote n and opens it as the current container.
+// The header line is left out of the body.
+func (r *renderer) openCallout(n ast.Node, c *callout) {
+	r.skipTo = c.headerEnd
+
+	if c.foldable {
+		expand := &confluence.ADFExpand{
+			Type:    r.expandType(),
+			Attrs:   confluence.ExpandAttrs{Title: calloutTitle(c)},
+			Content: []interface{}{},
 		}
-		return ast.WalkContinue, nil
-	})
+		r.appendBlock(expand)
+		r.push(n, expand.Type, &expand.Content)
+		r.top().fill = true
+		return
+	}
 
-	if err != nil {
-		return nil, err
+	panel := newCalloutPanel(c.kind)
+	if c.title != "" {
+		panel.Content = append(panel.Content, &confluence.ADFParagraph{
+			Type: "paragraph",
+			Content: []interface{}{&confluence.ADFText{
+				Type:  "text",
+				Text:  c.title,
+				Marks: []confluence.Mark{{Type: "strong"}},
+			}},
+		})
 	}
+	r.appendBlock(panel)
+	r.push(n, "panel", &panel.Content)
+	r.top().fill = true
+}
 
-	return doc, nil
+// renderMarkdown converts a Markdown fragment, such as Markdown embedded in
+// an HTML block, into the innermost open container.
+func (r *renderer) renderMarkdown(markdown string) error {
+	return r.renderMarkdownWith(markdown, r.options)
 }
 
-// getCurrentParent returns the last content element that can contain child nodes.
-func getCurrentParent(doc *confluence.ADFDocument) interface{} {
-	if len(doc.Content) == 0 {
+// renderMarkdownWith converts a Markdown fragment into the innermost open
+// container with other options, such as a transcluded note with the
+// resolvers of the note.
+func (r *renderer) renderMarkdownWith(markdown string, options *Options) error {
+	if strings.TrimSpace(markdown) == "" {
 		return nil
 	}
 
-	lastElem := doc.Content[len(doc.Content)-1]
-
-	switch v := lastElem.(type) {
-	case *confluence.ADFParagraph:
-		if len(v.Content) > 0 {
-			return v.Content[len(v.Content)-1]
-		}
-		return v
-	case *confluence.ADFHeading:
-		if len(v.Content) > 0 {
-			return v.Content[len(v.Content)-1]
-		}
-		return v
-	case *confluence.ADFBlockquote:
-		if len(v.Content) > 0 {
-			return v.Content[len(v.Content)-1]
-		}
-		return v
-	case *confluence.ADFCodeBlock:
-		if len(v.Content) > 0 {
-			return v.Content[len(v.Content)-1]
-		}
-		return v
-	case *confluence.ADFLink:
-		if len(v.Content) > 0 {
-			return v.Content[len(v.Content)-1]
-		}
-		return v
-	case *confluence.ADFList:
-		if len(v.Content) > 0 {
-			listItem := v.Content[len(v.Content)-1]
-			if li, ok := listItem.(*confluence.ADFListItem); ok {
-				if len(li.Content) > 0 {
-					return li.Content[len(li.Content)-1]
-				}
-				return li
-			}
-		}
-		return v
+	source := []byte(markdown)
+	document := parser.NewMarkdownParser().Parse(markdown)
+
+	top := r.top()
+	fragment := &renderer{
+		source:     source,
+		options:    options,
+		anchors:    r.anchors,
+		stack:      append(append([]*container{}, r.stack...), &container{node: document, adfType: top.adfType, content: top.content, inline: top.inline}),
+		localID:    r.localID,
+		embedDepth: r.embedDepth,
 	}
+	err := ast.Walk(document, fragment.walk)
+	r.localID = fragment.localID
+	return err
+}
 
-	return lastElem
+// warn reports a problem that does not fail the conversion to the Warn
+// option, if set.
+func (r *renderer) warn(message string) {
+	if r.options.Warn != nil {
+		r.options.Warn(message)
+	}
 }
 
-// Prevent adding empty paragraphs and redundant content
-func addToParent(doc *confluence.ADFDocument, node interface{}) {
-	// Directly add standalone elements without wrapping
-	switch node.(type) {
-	case *confluence.ADFEmoji, *confluence.ADFPlaceholder, *confluence.ADFTaskList, *confluence.ADFDecisionItem:
-		if len(doc.Content) > 0 {
-			// Remove the last element if it's an empty paragraph
-			if lastElem, ok := doc.Content[len(doc.Content)-1].(*confluence.ADFParagraph); ok && len(lastElem.Content) == 0 {
-				doc.Content = doc.Content[:len(doc.Content)-1]
-			}
+// lineOf returns the 1-based line number of the source offset.
+func (r *renderer) lineOf(offset int) int {
+	return lineOf(r.source, offset)
+}
+
+// lineOf returns the 1-based line number of an offset into source.
+func lineOf(source []byte, offset int) int {
+	return bytes.Count(source[:min(offset, len(source))], []byte("\n")) + 1
+}
+
+// walk is the ast.Walker that renders each node.
+func (r *renderer) walk(n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		r.pop(n)
+		return ast.WalkContinue, nil
+	}
+
+	source := r.source
+	r.node = n
+	if r.lines != nil {
+		if offset := sourceOffset(n); offset >= 0 {
+			r.line = r.lineOf(offset)
 		}
-		doc.Content = append(doc.Content, node)
-		return
 	}
 
-	if len(doc.Content) == 0 {
-		paragraph := &confluence.ADFParagraph{
-			Type:    "paragraph",
+	switch n.Kind() {
+	case ast.KindDocument:
+
+	case ast.KindHeading:
+		// ADF allows headings at the top level, in panels, expands and
+		// table cells only.
+		if !adfschema.Allows(r.top().adfType, "heading") {
+			r.openStrongParagraph(n)
+			return ast.WalkContinue, nil
+		}
+
+		v := n.(*ast.Heading)
+		heading := &confluence.ADFHeading{
+			Type: "heading",
+			Attrs: confluence.HeadingAttrs{
+				Level: v.Level,
+			},
 			Content: []interface{}{},
 		}
-		doc.Content = append(doc.Content, paragraph)
-	}
-
-	lastElem := doc.Content[len(doc.Content)-1]
-
-	switch v := lastElem.(type) {
-	case *confluence.ADFParagraph:
-		v.Content = append(v.Content, node)
-	case *confluence.ADFHeading:
-		v.Content = append(v.Content, node)
-	case *confluence.ADFBlockquote:
-		v.Content = append(v.Content, node)
-	case *confluence.ADFCodeBlock:
-		v.Content = append(v.Content, node)
-	case *confluence.ADFLink:
-		v.Content = append(v.Content, node)
-	case *confluence.ADFList:
-		if len(v.Content) > 0 {
-			listItem := v.Content[len(v.Content)-1]
-			if li, ok := listItem.(*confluence.ADFListItem); ok {
-				li.Content = append(li.Content, node)
+		r.appendBlock(heading)
+		r.push(n, "heading", &heading.Content)
+
+	case ast.KindParagraph, ast.KindTextBlock:
+		// Task and decision items hold inline content directly, so their
+		// paragraphs, and those of quotes merged into them, are joined with
+		// hard breaks instead of opening a container. Other blocks in the
+		// items are published after the list.
+		if top := r.top(); top.adfType == "taskItem" || top.adfType == "decisionItem" {
+			if len(*r.top().content) > 0 {
+				r.appendInline(&confluence.ADFHardBreak{Type: "hardBreak"})
+			}
+			return ast.WalkContinue, nil
+		}
+
+		if isDecisionQuote(n.Parent(), source) {
+			r.openDecisionItem(n)
+			return ast.WalkContinue, nil
+		}
+
+		if n.Kind() == ast.KindParagraph && isCalloutHeader(n, source) {
+			return ast.WalkSkipChildren, nil
+		}
+
+		if embed, ok := noteEmbed(n); ok {
+			if transcluded, err := r.transclude(embed); transcluded || err != nil {
+				return ast.WalkSkipChildren, err
+			}
+		}
+
+		if n.Kind() == ast.KindParagraph && imageParagraph(n, source) {
+			for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+				if isImage(c) {
+					img, _ := imageOf(c, source, r.options)
+					r.renderBlockImage(img)
+				}
 			}
+			return ast.WalkSkipChildren, nil
 		}
-	default:
+
 		paragraph := &confluence.ADFParagraph{
 			Type:    "paragraph",
-			Content: []interface{}{node},
+			Content: []interface{}{},
+		}
+		if footnote, ok := isFootnoteStart(n); ok {
+			paragraph.Content = append(paragraph.Content, anchorMacro(footnoteAnchor(footnote.Index)))
+		}
+		if id, _, ok := paragraphBlockID(n, source); ok {
+			paragraph.Content = append(paragraph.Content, anchorMacro(id))
+		}
+		r.appendBlock(paragraph)
+		r.push(n, "paragraph", &paragraph.Content)
+
+	case ast.KindText:
+		v := n.(*ast.Text)
+		segment := v.Segment
+		if segment.Start < r.skipTo {
+			if segment.Stop <= r.skipTo {
+				return ast.WalkContinue, nil
+			}
+			segment = segment.WithStart(r.skipTo)
+		}
+		if n.NextSibling() == nil {
+			if _, start, ok := paragraphBlockID(n.Parent(), source); ok {
+				segment = segment.WithStop(max(start, segment.Start))
+			}
+		}
+		var text string
+		switch {
+		case !v.IsRaw():
+			text = decodeText(segment.Value(source))
+		case n.Parent().Kind() == ast.KindCodeSpan:
+			// Line endings inside code spans are rendered as spaces.
+			text = strings.ReplaceAll(string(segment.Value(source)), "\n", " ")
+		default:
+			text = string(segment.Value(source))
+		}
+		if len(text) == 0 {
+			r.renderLineBreak(v)
+			return ast.WalkContinue, nil
+		}
+		r.appendInline(&confluence.ADFText{
+			Type:  "text",
+			Text:  text,
+			Marks: r.inlineMarks(n),
+		})
+		r.renderLineBreak(v)
+
+	case ast.KindCodeSpan:
+		if injected, err := r.renderRawCodeSpan(n.(*ast.CodeSpan)); injected || err != nil {
+			return ast.WalkSkipChildren, err
+		}
+
+	case parser.KindWikiLink:
+		r.renderWikiLink(n.(*parser.WikiLink))
+
+	case parser.KindMacro:
+		r.renderInlineMacro(n.(*parser.Macro))
+
+	case parser.KindEmoji:
+		r.renderEmoji(n.(*parser.Emoji))
+
+	case ast.KindRawHTML:
+		return ast.WalkSkipChildren, r.renderRawHTML(n.(*ast.RawHTML))
+
+	case ast.KindAutoLink:
+		v := n.(*ast.AutoLink)
+		r.appendInline(&confluence.ADFText{
+			Type:  "text",
+			Text:  string(v.Label(source)),
+			Marks: append(r.inlineMarks(n), linkMark(autoLinkURL(v, source), "")),
+		})
+
+	case ast.KindImage:
+		r.renderImage(n)
+		return ast.WalkSkipChildren, nil
+
+	case ast.KindCodeBlock, ast.KindFencedCodeBlock:
+		var language string
+		if fenced, ok := n.(*ast.FencedCodeBlock); ok {
+			language = string(fenced.Language(source))
+		}
+
+		lines := n.Lines()
+		var codeText strings.Builder
+		for i := 0; i < lines.Len(); i++ {
+			line := lines.At(i)
+			codeText.Write(line.Value(source))
 		}
-		doc.Content = append(doc.Content, paragraph)
+
+		codeStr := codeText.String()
+
+		if isRawADFLanguage(language) {
+			fenced := n.(*ast.FencedCodeBlock)
+			if injected, err := r.renderRawADF([]byte(codeStr), r.lineOf(fenced.Info.Segment.Start)); injected || err != nil {
+				return ast.WalkSkipChildren, err
+			}
+		}
+
+		if language == "confluence-macro" {
+			return ast.WalkSkipChildren, r.renderMacroFence(n.(*ast.FencedCodeBlock))
+		}
+
+		if language == "math" {
+			r.renderMath(codeStr, true)
+			return ast.WalkSkipChildren, nil
+		}
+
+		if language == "mermaid" {
+			imgPath, _ := mermaid.RenderDiagram(codeStr)
+			r.renderBlockImage(image{src: imgPath})
+			return ast.WalkSkipChildren, nil
+		}
+
+		r.appendBlock(codeBlock(language, codeStr))
+		return ast.WalkSkipChildren, nil
+
+	case parser.KindMathBlock:
+		r.renderMath(string(n.Lines().Value(source)), true)
+		return ast.WalkSkipChildren, nil
+
+	case parser.KindMathInline:
+		r.renderMath(string(n.(*parser.MathInline).Value(source)), false)
+		return ast.WalkSkipChildren, nil
+
+	case ast.KindList:
+		v := n.(*ast.List)
+		if isDecisionList(n, source) {
+			r.openDecisionList(n)
+			return ast.WalkContinue, nil
+		}
+
+		if isTaskList(n) {
+			taskList := &confluence.ADFTaskList{
+				Type:    "taskList",
+				Attrs:   confluence.TaskListAttrs{LocalID: r.nextLocalID()},
+				Content: []interface{}{},
+			}
+			// A nested task list is a sibling of its parent task item in ADF,
+			// so it is appended to the enclosing task list.
+			if isTaskItem(n.Parent()) {
+				parent := r.stack[len(r.stack)-2]
+				*parent.content = append(*parent.content, taskList)
+			} else {
+				r.appendBlock(taskList)
+			}
+			r.push(n, "taskList", &taskList.Content)
+			return ast.WalkContinue, nil
+		}
+
+		listType := "bulletList"
+		if v.IsOrdered() {
+			listType = "orderedList"
+		}
+
+		list := &confluence.ADFList{
+			Type:    listType,
+			Content: []interface{}{},
+		}
+		r.appendBlock(list)
+		r.push(n, listType, &list.Content)
+
+	case ast.KindListItem:
+		if isDecisionItem(n, source) {
+			r.openDecisionItem(n)
+			return ast.WalkContinue, nil
+		}
+
+		if isTaskItem(n) {
+			taskItem := &confluence.ADFTaskItem{
+				Type: "taskItem",
+				Attrs: confluence.TaskItemAttrs{
+					LocalID: r.nextLocalID(),
+					State:   taskState(n),
+				},
+				Content: []interface{}{},
+			}
+			r.appendBlock(taskItem)
+			r.push(n, "taskItem", &taskItem.Content)
+			return ast.WalkContinue, nil
+		}
+
+		listItem := &confluence.ADFListItem{
+			Type:    "listItem",
+			Content: []interface{}{},
+		}
+		r.appendBlock(listItem)
+		r.push(n, "listItem", &listItem.Content)
+
+	case ast.KindThematicBreak:
+		r.appendBlock(&confluence.ADFRule{
+			Type: "rule",
+		})
+
+	case ast.KindBlockquote:
+		if c, ok := parseCallout(n, source); ok {
+			r.openCallout(n, c)
+			return ast.WalkContinue, nil
+		}
+		if isDecisionQuote(n, source) {
+			r.openDecisionList(n)
+			return ast.WalkContinue, nil
+		}
+
+		// ADF does not allow a blockquote inside another blockquote, or in
+		// list items and panels, so the content of the quote is merged into
+		// the enclosing container there.
+		if !adfschema.Allows(r.top().adfType, "blockquote") {
+			return ast.WalkContinue, nil
+		}
+
+		blockquote := &confluence.ADFBlockquote{
+			Type:    "blockquote",
+			Content: []interface{}{},
+		}
+		r.appendBlock(blockquote)
+		r.push(n, "blockquote", &blockquote.Content)
+
+	case ast.KindHTMLBlock:
+		v := n.(*ast.HTMLBlock)
+		text := htmlBlockText(v, source)
+		if detailsStart.MatchString(text) {
+			return ast.WalkContinue, r.openDetails(v, text)
+		}
+		if detailsCloseTag.MatchString(text) {
+			return ast.WalkContinue, r.closeDetails(v, text)
+		}
+		if strings.Contains(text, "placeholder") {
+			r.appendInline(&confluence.ADFPlaceholder{
+				Type: "placeholder",
+				Attrs: confluence.PlaceholderAttrs{
+					Text: "Add your content here",
+				},
+			})
+			return ast.WalkContinue, nil
+		}
+		return ast.WalkContinue, r.renderHTMLBlock(v, text)
+
+	case extast.KindTaskCheckBox:
+		// The checkbox state is rendered on the enclosing task item.
+
+	case extast.KindFootnoteLink:
+		r.renderFootnoteLink(n.(*extast.FootnoteLink))
+
+	case extast.KindFootnoteBacklink:
+		r.renderFootnoteBacklink(n.(*extast.FootnoteBacklink))
+
+	case extast.KindFootnoteList:
+		r.openFootnoteList(n.(*extast.FootnoteList))
+
+	case extast.KindFootnote:
+		listItem := &confluence.ADFListItem{
+			Type:    "listItem",
+			Content: []interface{}{},
+		}
+		r.appendBlock(listItem)
+		r.push(n, "listItem", &listItem.Content)
+
+	case extast.KindTable:
+		table := newTable(r.options)
+		r.appendBlock(table)
+		r.push(n, "table", &table.Content)
+
+	case extast.KindTableHeader, extast.KindTableRow:
+		row := &confluence.ADFTableRow{
+			Type:    "tableRow",
+			Content: []interface{}{},
+		}
+		r.appendBlock(row)
+		r.push(n, "tableRow", &row.Content)
+
+	case extast.KindTableCell:
+		cell, paragraph := newTableCell(n.(*extast.TableCell))
+		r.appendBlock(cell)
+		r.push(n, "paragraph", &paragraph.Content)
 	}
+
+	return ast.WalkContinue, nil
 }
 
 // SerializeToJSON converts an ADFDocument to its JSON representation.
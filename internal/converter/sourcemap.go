@@ -0,0 +1,83 @@
+package converter
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/yuin/goldmark/ast"
+
+	"go-markdown-confluence/internal/confluence"
+)
+
+// SourceMap maps the JSON paths of the nodes of a converted document, such
+// as "$.content[2].content[0]", to the Markdown lines they were converted
+// from. Nodes without a known line are left out.
+type SourceMap map[string]int
+
+// slot is the place of a node in the children of its parent.
+type slot struct {
+	content *[]interface{}
+	index   int
+}
+
+// ConvertToADFWithSourceMap is like ConvertToADFWithOptions, and also
+// returns the source map of the document.
+func ConvertToADFWithSourceMap(n ast.Node, source []byte, options *Options) (*confluence.ADFDocument, SourceMap, error) {
+	doc, lines, err := convert(n, source, options, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sourceMap := make(SourceMap)
+	sourceMap.add(&doc.Content, "$", lines)
+	return doc, sourceMap, nil
+}
+
+// add records the lines of the children in content, whose parent is found
+// at path, and of their own children.
+func (m SourceMap) add(content *[]interface{}, path string, lines map[slot]int) {
+	for i, child := range *content {
+		childPath := fmt.Sprintf("%s.content[%d]", path, i)
+		if line, ok := lines[slot{content, i}]; ok {
+			m[childPath] = line
+		}
+		if children := contentOf(child); children != nil {
+			m.add(children, childPath, lines)
+		}
+	}
+}
+
+// contentOf returns the Content field of an ADF node of the confluence
+// types. Raw ADF is appended as a whole, so its children have no lines of
+// their own.
+func contentOf(node interface{}) *[]interface{} {
+	v := reflect.ValueOf(node)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	field := v.Elem().FieldByName("Content")
+	if !field.IsValid() {
+		return nil
+	}
+	content, _ := field.Addr().Interface().(*[]interface{})
+	return content
+}
+
+// sourceOffset returns the source offset at which n starts, or -1 when it
+// has no text of its own, as for thematic breaks.
+func sourceOffset(n ast.Node) int {
+	for ; n != nil; n = n.FirstChild() {
+		switch v := n.(type) {
+		case *ast.Text:
+			return v.Segment.Start
+		case *ast.FencedCodeBlock:
+			if v.Info != nil {
+				return v.Info.Segment.Start
+			}
+		}
+		if n.Type() == ast.TypeBlock && n.Lines().Len() > 0 {
+			return n.Lines().At(0).Start
+		}
+	}
+	return -1
+}
@@ -0,0 +1,893 @@
+package converter
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	extast "github.com/yuin/goldmark/extension/ast"
+
+	"go-markdown-confluence/internal/confluence"
+	"go-markdown-confluence/internal/emoji"
+	"go-markdown-confluence/internal/mermaid"
+	"go-markdown-confluence/internal/parser"
+)
+
+// ConvertToStorage converts a parsed AST node to the Confluence storage
+// format, the XHTML that Confluence Data Center keeps pages in and that
+// Cloud also accepts. A nil options value is equivalent to DefaultOptions.
+func ConvertToStorage(n ast.Node, source []byte, options *Options) (string, error) {
+	if n == nil {
+		return "", fmt.Errorf("Invalid Markdown: AST node is nil")
+	}
+	if options == nil {
+		options = DefaultOptions()
+	}
+
+	var out strings.Builder
+	r := &storageRenderer{
+		source:  source,
+		options: options,
+		anchors: HeadingAnchors(n, source),
+		out:     &out,
+	}
+	if err := ast.Walk(n, r.walk); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// storageRenderer writes the storage format while walking a goldmark AST.
+// Elements are opened when the walk enters a node and closed when it leaves
+// the node, so the closing markup of the open elements is kept on a stack.
+type storageRenderer struct {
+	source     []byte
+	options    *Options
+	anchors    map[string]string // Confluence anchors by link fragment
+	out        *strings.Builder
+	closers    []closer
+	taskID     int // Last task ID handed out
+	embedDepth int // Number of ![[note]] embeds being transcluded
+	skipTo     int // Source offset before which text is not rendered
+}
+
+// closer is the markup that closes an element once the walk leaves node.
+type closer struct {
+	node   ast.Node
+	start  string // Markup that opened the element
+	markup string
+	tag    string // Tag of an inline HTML element, which is closed with the block at the latest
+}
+
+// calloutMacros maps the ADF panel types of callouts to the storage format
+// macros that look the most alike. Custom panels use the panel macro.
+var calloutMacros = map[string]string{
+	"info":    "info",
+	"note":    "info",
+	"tip":     "tip",
+	"success": "tip",
+	"warning": "note",
+	"error":   "warning",
+}
+
+// imageAlignments maps the layouts of block images to the alignment that
+// Confluence Data Center, which ignores layouts, shows them with.
+var imageAlignments = map[string]string{
+	"align-start": "left",
+	"wrap-left":   "left",
+	"align-end":   "right",
+	"wrap-right":  "right",
+}
+
+// write appends markup to the output.
+func (r *storageRenderer) write(markup string) {
+	r.out.WriteString(markup)
+}
+
+// text appends text, escaped for XHTML.
+func (r *storageRenderer) text(text string) {
+	r.out.WriteString(html.EscapeString(text))
+}
+
+// open writes the markup that starts the element rendering n and keeps the
+// markup that ends it until the walk leaves n.
+func (r *storageRenderer) open(n ast.Node, start, end string) {
+	r.write(start)
+	r.closers = append(r.closers, closer{node: n, start: start, markup: end})
+}
+
+// close writes the closing markup of the elements opened for n. Inline HTML
+// elements that are still open inside them are closed first.
+func (r *storageRenderer) close(n ast.Node) {
+	for {
+		i := len(r.closers) - 1
+		for i >= 0 && r.closers[i].tag != "" && r.closers[i].node != n {
+			i--
+		}
+		if i < 0 || r.closers[i].node != n {
+			return
+		}
+		r.closeAt(i)
+	}
+}
+
+// closeAt closes the i-th open element. XHTML elements cannot overlap, so
+// the elements open inside it are closed before it and opened again after
+// it.
+func (r *storageRenderer) closeAt(i int) {
+	inner := append([]closer{}, r.closers[i+1:]...)
+	for j := len(r.closers) - 1; j >= i; j-- {
+		r.write(r.closers[j].markup)
+	}
+	r.closers = r.closers[:i]
+	for _, c := range inner {
+		r.write(c.start)
+		r.closers = append(r.closers, c)
+	}
+}
+
+// nextTaskID returns a task ID that is unique within the page.
+func (r *storageRenderer) nextTaskID() string {
+	r.taskID++
+	return strconv.Itoa(r.taskID)
+}
+
+// renderMarkdown converts a Markdown fragment, such as the body of a macro
+// fence, at the current position.
+func (r *storageRenderer) renderMarkdown(markdown string) error {
+	return r.renderMarkdownWith(markdown, r.options)
+}
+
+// renderMarkdownWith converts a Markdown fragment at the current position
+// with other options, such as a transcluded note with the resolvers of the
+// note.
+func (r *storageRenderer) renderMarkdownWith(markdown string, options *Options) error {
+	if strings.TrimSpace(markdown) == "" {
+		return nil
+	}
+
+	document := parser.NewMarkdownParser().Parse(markdown)
+	fragment := &storageRenderer{
+		source:     []byte(markdown),
+		options:    options,
+		anchors:    r.anchors,
+		out:        r.out,
+		taskID:     r.taskID,
+		embedDepth: r.embedDepth,
+	}
+	err := ast.Walk(document, fragment.walk)
+	r.taskID = fragment.taskID
+	return err
+}
+
+// warn reports a problem that does not fail the conversion to the Warn
+// option, if set.
+func (r *storageRenderer) warn(message string) {
+	if r.options.Warn != nil {
+		r.options.Warn(message)
+	}
+}
+
+// walk is the ast.Walker that renders each node.
+func (r *storageRenderer) walk(n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		r.close(n)
+		return ast.WalkContinue, nil
+	}
+
+	source := r.source
+	switch n.Kind() {
+	case ast.KindDocument:
+
+	case ast.KindHeading:
+		tag := fmt.Sprintf("h%d", n.(*ast.Heading).Level)
+		r.open(n, "<"+tag+">", "</"+tag+">")
+
+	case ast.KindParagraph, ast.KindTextBlock:
+		// Task bodies hold inline content, so the paragraphs of a task item
+		// are joined with line breaks.
+		if isTaskItem(n.Parent()) {
+			if n.PreviousSibling() != nil {
+				r.write("<br/>")
+			}
+			return ast.WalkContinue, nil
+		}
+
+		if n.Kind() == ast.KindParagraph && isCalloutHeader(n, source) {
+			return ast.WalkSkipChildren, nil
+		}
+
+		if embed, ok := noteEmbed(n); ok {
+			if transcluded, err := r.transclude(embed); transcluded || err != nil {
+				return ast.WalkSkipChildren, err
+			}
+		}
+
+		if n.Kind() == ast.KindParagraph && imageParagraph(n, source) {
+			for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+				if isImage(c) {
+					img, _ := imageOf(c, source, r.options)
+					r.write("<p>" + r.imageMarkup(img, true) + "</p>")
+				}
+			}
+			return ast.WalkSkipChildren, nil
+		}
+
+		// The items of tight lists hold their text without a paragraph.
+		if n.Kind() == ast.KindTextBlock {
+			return ast.WalkContinue, nil
+		}
+
+		r.open(n, "<p>", "</p>")
+		if footnote, ok := isFootnoteStart(n); ok {
+			r.write(storageAnchor(footnoteAnchor(footnote.Index)))
+		}
+		if id, _, ok := paragraphBlockID(n, source); ok {
+			r.write(storageAnchor(id))
+		}
+
+	case ast.KindText:
+		v := n.(*ast.Text)
+		segment := v.Segment
+		if segment.Start < r.skipTo {
+			if segment.Stop <= r.skipTo {
+				return ast.WalkContinue, nil
+			}
+			segment = segment.WithStart(r.skipTo)
+		}
+		if n.NextSibling() == nil {
+			if _, start, ok := paragraphBlockID(n.Parent(), source); ok {
+				segment = segment.WithStop(max(start, segment.Start))
+			}
+		}
+		switch {
+		case !v.IsRaw():
+			r.text(decodeText(segment.Value(source)))
+		case n.Parent().Kind() == ast.KindCodeSpan:
+			// Line endings inside code spans are rendered as spaces.
+			r.text(strings.ReplaceAll(string(segment.Value(source)), "\n", " "))
+		default:
+			r.text(string(segment.Value(source)))
+		}
+		switch {
+		case v.HardLineBreak(), v.SoftLineBreak() && r.options.PreserveNewlines:
+			r.write("<br/>")
+		case v.SoftLineBreak():
+			r.write("\n")
+		}
+
+	case ast.KindEmphasis:
+		tag := "strong"
+		if n.(*ast.Emphasis).Level == 1 {
+			tag = "em"
+		}
+		r.open(n, "<"+tag+">", "</"+tag+">")
+
+	case extast.KindStrikethrough:
+		r.open(n, "<s>", "</s>")
+
+	case ast.KindCodeSpan:
+		if r.skipRawCodeSpan(n.(*ast.CodeSpan)) {
+			r.warn(fmt.Sprintf("raw ADF at line %d cannot be published in the storage format", lineOf(source, r.skipTo)))
+		}
+		r.open(n, "<code>", "</code>")
+
+	case ast.KindLink:
+		r.openLink(n.(*ast.Link))
+
+	case ast.KindAutoLink:
+		v := n.(*ast.AutoLink)
+		r.write(`<a href="` + html.EscapeString(autoLinkURL(v, source)) + `">`)
+		r.text(string(v.Label(source)))
+		r.write("</a>")
+
+	case ast.KindImage:
+		r.renderImage(n)
+		return ast.WalkSkipChildren, nil
+
+	case parser.KindWikiLink:
+		r.renderWikiLink(n.(*parser.WikiLink))
+
+	case parser.KindMacro:
+		v := n.(*parser.Macro)
+		r.write(storageMacro(v.Name, macroParameters(v.Parameters), ""))
+
+	case parser.KindEmoji:
+		r.renderEmoji(n.(*parser.Emoji))
+
+	case ast.KindRawHTML:
+		return ast.WalkSkipChildren, r.renderRawHTML(n.(*ast.RawHTML))
+
+	case ast.KindCodeBlock, ast.KindFencedCodeBlock:
+		var language string
+		if fenced, ok := n.(*ast.FencedCodeBlock); ok {
+			language = string(fenced.Language(source))
+		}
+		code := string(n.Lines().Value(source))
+
+		switch {
+		case isRawADFLanguage(language):
+			err := fmt.Errorf("raw ADF at line %d cannot be published in the storage format", lineOf(source, n.(*ast.FencedCodeBlock).Info.Segment.Start))
+			if r.options.StrictADF {
+				return ast.WalkSkipChildren, err
+			}
+			r.warn(err.Error())
+			r.write(codeMacro("json", code))
+		case language == "confluence-macro":
+			return ast.WalkSkipChildren, r.renderMacroFence(n.(*ast.FencedCodeBlock))
+		case language == "math":
+			r.renderMath(code, true)
+		case language == "mermaid":
+			imgPath, _ := mermaid.RenderDiagram(code)
+			r.write("<p>" + r.imageMarkup(image{src: imgPath}, true) + "</p>")
+		default:
+			r.write(codeMacro(language, code))
+		}
+		return ast.WalkSkipChildren, nil
+
+	case parser.KindMathBlock:
+		r.renderMath(string(n.Lines().Value(source)), true)
+		return ast.WalkSkipChildren, nil
+
+	case parser.KindMathInline:
+		r.renderMath(string(n.(*parser.MathInline).Value(source)), false)
+		return ast.WalkSkipChildren, nil
+
+	case ast.KindList:
+		v := n.(*ast.List)
+		switch {
+		case isTaskList(n):
+			r.open(n, "<ac:task-list>", "</ac:task-list>")
+		case v.IsOrdered() && v.Start > 1:
+			r.open(n, fmt.Sprintf(`<ol start="%d">`, v.Start), "</ol>")
+		case v.IsOrdered():
+			r.open(n, "<ol>", "</ol>")
+		default:
+			r.open(n, "<ul>", "</ul>")
+		}
+
+	case ast.KindListItem:
+		if isTaskItem(n) {
+			status := "incomplete"
+			if taskState(n) == "DONE" {
+				status = "complete"
+			}
+			r.open(n, "<ac:task><ac:task-id>"+r.nextTaskID()+"</ac:task-id><ac:task-status>"+status+"</ac:task-status><ac:task-body>", "</ac:task-body></ac:task>")
+			return ast.WalkContinue, nil
+		}
+		r.open(n, "<li>", "</li>")
+
+	case ast.KindThematicBreak:
+		r.write("<hr/>")
+
+	case ast.KindBlockquote:
+		if c, ok := parseCallout(n, source); ok {
+			r.openCallout(n, c)
+			return ast.WalkContinue, nil
+		}
+		r.open(n, "<blockquote>", "</blockquote>")
+
+	case ast.KindHTMLBlock:
+		v := n.(*ast.HTMLBlock)
+		text := htmlBlockText(v, source)
+		if detailsStart.MatchString(text) {
+			return ast.WalkContinue, r.openDetails(v, text)
+		}
+		if detailsCloseTag.MatchString(text) {
+			return ast.WalkContinue, r.closeDetails(v, text)
+		}
+		if strings.Contains(text, "placeholder") {
+			r.write("<ac:placeholder>Add your content here</ac:placeholder>")
+			return ast.WalkContinue, nil
+		}
+		return ast.WalkContinue, r.renderHTMLBlock(v, text)
+
+	case extast.KindTaskCheckBox:
+		// The checkbox state is rendered on the enclosing task.
+
+	case extast.KindFootnoteLink:
+		v := n.(*extast.FootnoteLink)
+		r.write(storageAnchor(footnoteRefAnchor(v.Index, v.RefIndex)))
+		r.write("<sup>" + storageLink("", footnoteAnchor(v.Index), fmt.Sprintf("[%d]", v.Index)) + "</sup>")
+
+	case extast.KindFootnoteBacklink:
+		v := n.(*extast.FootnoteBacklink)
+		if n.PreviousSibling() != nil {
+			r.write(" ")
+		}
+		r.write(storageLink("", footnoteRefAnchor(v.Index, v.RefIndex), "↩"))
+
+	case extast.KindFootnoteList:
+		r.write("<h2>")
+		r.text(r.options.FootnotesTitle)
+		r.write("</h2>")
+		r.open(n, "<ol>", "</ol>")
+
+	case extast.KindFootnote:
+		r.open(n, "<li>", "</li>")
+
+	case extast.KindTable:
+		start := "<table>"
+		if layout := r.options.TableLayout; layout != "" && layout != "default" {
+			start = `<table data-layout="` + html.EscapeString(layout) + `">`
+		}
+		r.open(n, start+"<tbody>", "</tbody></table>")
+
+	case extast.KindTableHeader, extast.KindTableRow:
+		r.open(n, "<tr>", "</tr>")
+
+	case extast.KindTableCell:
+		v := n.(*extast.TableCell)
+		tag := "td"
+		if n.Parent() != nil && n.Parent().Kind() == extast.KindTableHeader {
+			tag = "th"
+		}
+		start := "<" + tag + ">"
+		switch v.Alignment {
+		case extast.AlignCenter:
+			start = "<" + tag + ` style="text-align: center;">`
+		case extast.AlignRight:
+			start = "<" + tag + ` style="text-align: right;">`
+		}
+		r.open(n, start+"<p>", "</p></"+tag+">")
+	}
+
+	return ast.WalkContinue, nil
+}
+
+// openLink opens the link n. Fragments of the page itself and pages found
+// by the ResolvePage option become Confluence links; other destinations
+// become HTML links to the href returned by the ResolveLink option.
+func (r *storageRenderer) openLink(n *ast.Link) {
+	destination := string(n.Destination)
+	if fragment, ok := strings.CutPrefix(destination, "#"); ok {
+		if anchor, ok := r.anchors[fragment]; ok {
+			fragment = anchor
+		}
+		r.open(n, storageLinkStart("", fragment)+"<ac:link-body>", "</ac:link-body></ac:link>")
+		return
+	}
+	if r.options.ResolvePage != nil {
+		if title, anchor, ok := r.options.ResolvePage(destination); ok {
+			r.open(n, storageLinkStart(title, anchor)+"<ac:link-body>", "</ac:link-body></ac:link>")
+			return
+		}
+	}
+
+	href := destination
+	if r.options.ResolveLink != nil {
+		href = r.options.ResolveLink(destination)
+	}
+	start := `<a href="` + html.EscapeString(href) + `"`
+	if len(n.Title) > 0 {
+		start += ` title="` + html.EscapeString(string(n.Title)) + `"`
+	}
+	r.open(n, start+">", "</a>")
+}
+
+// renderWikiLink writes a wikilink as a link to the page or anchor it
+// names, or an image embed as an image.
+func (r *storageRenderer) renderWikiLink(n *parser.WikiLink) {
+	if isImageEmbed(n) {
+		r.renderImage(n)
+		return
+	}
+
+	text := wikiLinkText(n)
+	if n.Target == "" {
+		r.write(storageLink("", FragmentAnchor(n.Fragment, r.anchors), text))
+		return
+	}
+	if r.options.ResolveWikiPage != nil {
+		if title, anchor, ok := r.options.ResolveWikiPage(n.Target, n.Fragment); ok {
+			r.write(storageLink(title, anchor, text))
+			return
+		}
+	}
+	r.write(`<a href="` + html.EscapeString(wikiLinkHref(n, r.anchors, r.options)) + `">`)
+	r.text(text)
+	r.write("</a>")
+}
+
+// transclude replaces an ![[note]] embed with the content of the note. It
+// returns false when the note cannot be transcluded and the embed should be
+// rendered as a link instead.
+func (r *storageRenderer) transclude(n *parser.WikiLink) (bool, error) {
+	if r.options.Transclude == nil || r.embedDepth >= maxEmbedDepth {
+		return false, nil
+	}
+	markdown, options, ok := r.options.Transclude(n.Target, n.Fragment)
+	if !ok {
+		return false, nil
+	}
+	if options == nil {
+		options = r.options
+	}
+
+	r.embedDepth++
+	err := r.renderMarkdownWith(markdown, options)
+	r.embedDepth--
+	return true, err
+}
+
+// renderImage writes the image or image embed n within text and skips the
+// attribute block that follows it.
+func (r *storageRenderer) renderImage(n ast.Node) {
+	img, end := imageOf(n, r.source, r.options)
+	if end > r.skipTo {
+		r.skipTo = end
+	}
+	r.write(r.imageMarkup(img, false))
+}
+
+// imageMarkup returns the ac:image element of img: the attachment that the
+// ResolveAttachment option publishes it as, or else its URL. Block images
+// are centered unless they set a layout.
+func (r *storageRenderer) imageMarkup(img image, block bool) string {
+	var b strings.Builder
+	b.WriteString("<ac:image")
+	if block {
+		layout := img.layout
+		if layout == "" {
+			layout = "center"
+		}
+		align, ok := imageAlignments[layout]
+		if !ok {
+			align = "center"
+		}
+		fmt.Fprintf(&b, ` ac:align="%s" ac:layout="%s"`, align, html.EscapeString(layout))
+	}
+	if img.alt != "" {
+		fmt.Fprintf(&b, ` ac:alt="%s"`, html.EscapeString(img.alt))
+	}
+	if img.width > 0 {
+		fmt.Fprintf(&b, ` ac:width="%d"`, img.width)
+	}
+	if img.height > 0 {
+		fmt.Fprintf(&b, ` ac:height="%d"`, img.height)
+	}
+	b.WriteString(">")
+
+	filename, ok := "", false
+	if r.options.ResolveAttachment != nil {
+		filename, ok = r.options.ResolveAttachment(img.src)
+	}
+	if ok {
+		fmt.Fprintf(&b, `<ri:attachment ri:filename="%s"/>`, html.EscapeString(filename))
+	} else {
+		fmt.Fprintf(&b, `<ri:url ri:value="%s"/>`, html.EscapeString(img.src))
+	}
+	b.WriteString("</ac:image>")
+	return b.String()
+}
+
+// renderEmoji writes the emoji named by a shortcode as its text, looking it
+// up in the custom emoji of the options before the bundled table. Unknown
+// shortcodes are kept as they are.
+func (r *storageRenderer) renderEmoji(n *parser.Emoji) {
+	name := n.Name(r.source)
+	e, ok := r.options.Emoji[name]
+	if !ok {
+		e, ok = emoji.Lookup(name)
+	}
+	switch {
+	case !ok:
+		r.text(string(n.Segment.Value(r.source)))
+	case e.Text != "":
+		r.text(e.Text)
+	default:
+		r.text(e.ShortName)
+	}
+}
+
+// renderMath writes a LaTeX expression in the form selected by the Math
+// option, falling back to code like the ADF renderer does.
+func (r *storageRenderer) renderMath(latex string, block bool) {
+	latex = strings.TrimSpace(latex)
+
+	switch r.options.Math {
+	case MathExtension:
+		key := r.options.MathInlineKey
+		if block {
+			key = r.options.MathBlockKey
+		}
+		if key != "" {
+			r.write(storageMacro(key, nil, "<ac:plain-text-body>"+cdata(latex)+"</ac:plain-text-body>"))
+			return
+		}
+
+	case MathImage:
+		// As in ADF, math that is not published as an attachment falls
+		// back to code.
+		if r.options.MathRenderer == nil || r.options.ResolveAttachment == nil {
+			break
+		}
+		if src, err := r.options.MathRenderer(latex, block); err == nil {
+			if _, ok := r.options.ResolveAttachment(src); !ok {
+				break
+			}
+			img := image{src: src, alt: latex}
+			if block {
+				r.write("<p>" + r.imageMarkup(img, true) + "</p>")
+				return
+			}
+			r.write(r.imageMarkup(img, false))
+			return
+		}
+	}
+
+	if block {
+		r.write(codeMacro("latex", latex))
+		return
+	}
+	r.write("<code>")
+	r.text(latex)
+	r.write("</code>")
+}
+
+// renderMacroFence renders a confluence-macro fence as a structured macro.
+// The Markdown body of the fence, if any, becomes the macro's rich text
+// body.
+func (r *storageRenderer) renderMacroFence(n *ast.FencedCodeBlock) error {
+	info := string(n.Info.Segment.Value(r.source))
+	fields := strings.Fields(info)
+	if len(fields) < 2 {
+		return fmt.Errorf("confluence-macro block at line %d has no macro name", lineOf(r.source, n.Info.Segment.Start))
+	}
+	name := fields[1]
+	rest := strings.TrimSpace(info)
+	rest = strings.TrimSpace(rest[len(fields[0]):])
+	params := macroParameters(rest[len(name):])
+
+	body := string(n.Lines().Value(r.source))
+	if strings.TrimSpace(body) == "" {
+		r.write(storageMacro(name, params, ""))
+		return nil
+	}
+
+	r.write(storageMacroStart(name, params) + "<ac:rich-text-body>")
+	err := r.renderMarkdown(body)
+	r.write("</ac:rich-text-body></ac:structured-macro>")
+	return err
+}
+
+// openCallout opens the macro that renders the callout blockquote n: an
+// expand for a foldable callout, and otherwise the panel macro closest to
+// the callout type. The header line is left out of the body.
+func (r *storageRenderer) openCallout(n ast.Node, c *callout) {
+	r.skipTo = c.headerEnd
+
+	name, params := "expand", map[string]string{"title": calloutTitle(c)}
+	if !c.foldable {
+		style, ok := calloutStyles[c.kind]
+		if !ok {
+			style = calloutStyles["note"]
+		}
+		name, params = calloutMacros[style.panelType], map[string]string{}
+		if style.panelType == "custom" {
+			name, params["bgColor"] = "panel", style.color
+		}
+		if c.title != "" {
+			params["title"] = c.title
+		}
+	}
+	r.open(n, storageMacroStart(name, params)+"<ac:rich-text-body>", "</ac:rich-text-body></ac:structured-macro>")
+}
+
+// openDetails renders an HTML block that starts a <details> element as an
+// expand macro titled with the <summary> text, which stays open up to the
+// block that closes the element, like the ADF renderer's expand.
+func (r *storageRenderer) openDetails(n *ast.HTMLBlock, text string) error {
+	open := detailsStart.FindStringIndex(text)
+	body := text[open[1]:]
+
+	title := ""
+	if m := summaryElement.FindStringSubmatchIndex(body); m != nil {
+		title = strings.TrimSpace(html.UnescapeString(htmlTag.ReplaceAllString(body[m[4]:m[5]], "")))
+		body = body[m[1]:]
+	}
+
+	var closer ast.Node = n
+	if end := detailsCloseTag.FindStringIndex(body); end != nil {
+		body = body[:end[0]]
+	} else if closer = detailsCloser(n, r.source); closer == nil {
+		closer = n.Parent()
+	}
+	r.open(closer, storageMacroStart("expand", map[string]string{"title": title})+"<ac:rich-text-body>", "</ac:rich-text-body></ac:structured-macro>")
+
+	return r.renderMarkdown(body)
+}
+
+// closeDetails renders any Markdown in front of the closing </details> tag of
+// the HTML block n that closes the innermost open expand.
+func (r *storageRenderer) closeDetails(n *ast.HTMLBlock, text string) error {
+	if len(r.closers) == 0 || r.closers[len(r.closers)-1].node != n {
+		return nil
+	}
+	end := detailsCloseTag.FindStringIndex(text)
+	return r.renderMarkdown(text[:end[0]])
+}
+
+// renderRawHTML renders an inline HTML tag. Tags of the supported subset
+// open or close the matching storage format element, <br> becomes a line
+// break, comments are dropped and other tags are handled according to
+// Options.UnsupportedHTML. Elements left open are closed with the block.
+func (r *storageRenderer) renderRawHTML(n *ast.RawHTML) error {
+	var raw strings.Builder
+	for i := 0; i < n.Segments.Len(); i++ {
+		segment := n.Segments.At(i)
+		raw.Write(segment.Value(r.source))
+	}
+	text := raw.String()
+
+	if strings.HasPrefix(text, "<!--") {
+		return nil
+	}
+
+	m := htmlTagPattern.FindStringSubmatch(text)
+	if m == nil {
+		return r.unsupportedHTML(text, n.Segments.At(0).Start)
+	}
+	closing, tag, selfClosing := m[1] == "/", strings.ToLower(m[2]), m[3] == "/"
+
+	if tag == "br" {
+		r.write("<br/>")
+		return nil
+	}
+
+	mark, supported := htmlMark(tag, text)
+	if !supported {
+		return r.unsupportedHTML(text, n.Segments.At(0).Start)
+	}
+
+	switch {
+	case selfClosing:
+	case closing:
+		for i := len(r.closers) - 1; i >= 0; i-- {
+			if c := r.closers[i]; c.tag == tag {
+				r.closeAt(i)
+				break
+			} else if c.tag == "" && c.node.Type() == ast.TypeBlock {
+				break
+			}
+		}
+	default:
+		block := n.Parent()
+		for block.Type() != ast.TypeBlock {
+			block = block.Parent()
+		}
+		start, end := markMarkup(mark)
+		r.write(start)
+		r.closers = append(r.closers, closer{node: block, start: start, markup: end, tag: tag})
+	}
+	return nil
+}
+
+// renderHTMLBlock handles an HTML block that has no storage format
+// equivalent according to Options.UnsupportedHTML. Kept blocks are
+// published line by line as literal text.
+func (r *storageRenderer) renderHTMLBlock(n *ast.HTMLBlock, text string) error {
+	if n.Lines().Len() == 0 || strings.HasPrefix(strings.TrimSpace(text), "<!--") {
+		return nil
+	}
+
+	switch r.options.UnsupportedHTML {
+	case HTMLKeep:
+		lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+		for i, line := range lines {
+			lines[i] = html.EscapeString(line)
+		}
+		r.write("<p>" + strings.Join(lines, "<br/>") + "</p>")
+	case HTMLFail:
+		return fmt.Errorf("unsupported HTML block at line %d: %s", lineOf(r.source, n.Lines().At(0).Start), firstLine(text))
+	}
+	return nil
+}
+
+// unsupportedHTML handles an inline HTML tag outside the supported subset
+// according to Options.UnsupportedHTML.
+func (r *storageRenderer) unsupportedHTML(text string, offset int) error {
+	switch r.options.UnsupportedHTML {
+	case HTMLKeep:
+		r.text(text)
+	case HTMLFail:
+		return fmt.Errorf("unsupported HTML tag at line %d: %s", lineOf(r.source, offset), text)
+	}
+	return nil
+}
+
+// skipRawCodeSpan reports whether the code span n is followed by the {=adf}
+// attribute of raw ADF, which the storage format cannot hold, and skips the
+// attribute so the span is published as code.
+func (r *storageRenderer) skipRawCodeSpan(n *ast.CodeSpan) bool {
+	next, ok := n.NextSibling().(*ast.Text)
+	if !ok || n.FirstChild() == nil || !strings.HasPrefix(string(r.source[next.Segment.Start:]), string(rawADFAttribute)) {
+		return false
+	}
+	r.skipTo = next.Segment.Start + len(rawADFAttribute)
+	return true
+}
+
+// markMarkup returns the storage format markup that starts and ends the
+// text an ADF mark of the supported inline HTML subset applies to.
+func markMarkup(mark confluence.Mark) (start, end string) {
+	tag := mark.Type
+	switch mark.Type {
+	case "subsup":
+		tag = mark.Attrs.Type
+	case "underline":
+		tag = "u"
+	case "strike":
+		tag = "s"
+	case "backgroundColor":
+		return `<span style="background-color: ` + mark.Attrs.Color + `;">`, "</span>"
+	}
+	return "<" + tag + ">", "</" + tag + ">"
+}
+
+// storageMacroStart returns the start of a structured macro with the given
+// parameters, written in the order of their names so the output is stable.
+// An empty name is the macro's default parameter.
+func storageMacroStart(name string, params map[string]string) string {
+	names := make([]string, 0, len(params))
+	for param := range params {
+		names = append(names, param)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<ac:structured-macro ac:name="%s">`, html.EscapeString(name))
+	for _, param := range names {
+		fmt.Fprintf(&b, `<ac:parameter ac:name="%s">%s</ac:parameter>`, html.EscapeString(param), html.EscapeString(params[param]))
+	}
+	return b.String()
+}
+
+// storageMacro returns a structured macro with the given parameters and
+// body markup.
+func storageMacro(name string, params map[string]string, body string) string {
+	return storageMacroStart(name, params) + body + "</ac:structured-macro>"
+}
+
+// storageAnchor returns an anchor macro that links can target with
+// ac:anchor.
+func storageAnchor(name string) string {
+	return storageMacro("anchor", map[string]string{"": name}, "")
+}
+
+// codeMacro returns a code macro holding code in the given language.
+func codeMacro(language, code string) string {
+	params := map[string]string{}
+	if language != "" {
+		params["language"] = language
+	}
+	return storageMacro("code", params, "<ac:plain-text-body>"+cdata(code)+"</ac:plain-text-body>")
+}
+
+// storageLinkStart returns the start of a Confluence link to the anchor of
+// the page titled title, or of the page itself when title is empty.
+func storageLinkStart(title, anchor string) string {
+	start := "<ac:link>"
+	if anchor != "" {
+		start = `<ac:link ac:anchor="` + html.EscapeString(anchor) + `">`
+	}
+	if title != "" {
+		start += `<ri:page ri:content-title="` + html.EscapeString(title) + `"/>`
+	}
+	return start
+}
+
+// storageLink returns a Confluence link with plain text to the anchor of
+// the page titled title, or of the page itself when title is empty.
+func storageLink(title, anchor, text string) string {
+	return storageLinkStart(title, anchor) + "<ac:plain-text-link-body>" + cdata(text) + "</ac:plain-text-link-body></ac:link>"
+}
+
+// cdata returns text as a CDATA section, splitting the section where text
+// contains its end marker.
+func cdata(text string) string {
+	return "<![CDATA[" + strings.ReplaceAll(text, "]]>", "]]]]><![CDATA[>") + "]]>"
+}
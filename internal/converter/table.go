@@ -0,0 +1,47 @@
+package converter
+
+import (
+	extast "github.com/yuin/goldmark/extension/ast"
+
+	"go-markdown-confluence/internal/confluence"
+)
+
+// newTable returns an empty ADF table carrying the table attrs from options.
+func newTable(options *Options) *confluence.ADFTable {
+	return &confluence.ADFTable{
+		Type: "table",
+		Attrs: confluence.TableAttrs{
+			IsNumberColumnEnabled: options.TableNumberColumn,
+			Layout:                options.TableLayout,
+		},
+		Content: []interface{}{},
+	}
+}
+
+// newTableCell returns an ADF cell for a GFM table cell together with the
+// paragraph that receives the cell's inline content. Cells of the header row
+// become tableHeader nodes, and column alignment is carried over as an
+// alignment mark on the paragraph.
+func newTableCell(cell *extast.TableCell) (*confluence.ADFTableCell, *confluence.ADFParagraph) {
+	cellType := "tableCell"
+	if cell.Parent() != nil && cell.Parent().Kind() == extast.KindTableHeader {
+		cellType = "tableHeader"
+	}
+
+	paragraph := &confluence.ADFParagraph{
+		Type:    "paragraph",
+		Content: []interface{}{},
+	}
+
+	switch cell.Alignment {
+	case extast.AlignCenter:
+		paragraph.Marks = []confluence.Mark{{Type: "alignment", Attrs: &confluence.MarkAttrs{Align: "center"}}}
+	case extast.AlignRight:
+		paragraph.Marks = []confluence.Mark{{Type: "alignment", Attrs: &confluence.MarkAttrs{Align: "end"}}}
+	}
+
+	return &confluence.ADFTableCell{
+		Type:    cellType,
+		Content: []interface{}{paragraph},
+	}, paragraph
+}
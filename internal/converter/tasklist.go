@@ -0,0 +1,48 @@
+package converter
+
+import (
+	"github.com/yuin/goldmark/ast"
+	extast "github.com/yuin/goldmark/extension/ast"
+)
+
+// taskCheckBox returns the checkbox that starts the list item n, or nil when
+// the item is not a task.
+func taskCheckBox(n ast.Node) *extast.TaskCheckBox {
+	block := n.FirstChild()
+	if block == nil {
+		return nil
+	}
+	checkBox, _ := block.FirstChild().(*extast.TaskCheckBox)
+	return checkBox
+}
+
+// isTaskList reports whether the list n renders as an ADF taskList. That is
+// the case when any of its items starts with a checkbox, or when the list is
+// nested in a task item, since ADF task lists can only nest other task lists.
+func isTaskList(n ast.Node) bool {
+	if n.Kind() != ast.KindList {
+		return false
+	}
+	if isTaskItem(n.Parent()) {
+		return true
+	}
+	for item := n.FirstChild(); item != nil; item = item.NextSibling() {
+		if taskCheckBox(item) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// isTaskItem reports whether n is an item of a task list.
+func isTaskItem(n ast.Node) bool {
+	return n != nil && n.Kind() == ast.KindListItem && isTaskList(n.Parent())
+}
+
+// taskState returns the ADF state of the task item n.
+func taskState(n ast.Node) string {
+	if checkBox := taskCheckBox(n); checkBox != nil && checkBox.IsChecked {
+		return "DONE"
+	}
+	return "TODO"
+}
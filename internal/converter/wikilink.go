@@ -0,0 +1,151 @@
+package converter
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+
+	"go-markdown-confluence/internal/confluence"
+	"go-markdown-confluence/internal/parser"
+)
+
+// maxEmbedDepth limits how deeply ![[note]] embeds are transcluded, so notes
+// that embed each other do not recurse forever.
+const maxEmbedDepth = 4
+
+// imageExtensions lists the file extensions of embeds rendered as images.
+var imageExtensions = map[string]bool{
+	".png":  true,
+	".jpg":  true,
+	".jpeg": true,
+	".gif":  true,
+	".svg":  true,
+	".webp": true,
+	".bmp":  true,
+}
+
+// blockMarker matches the " ^block-id" marker that ends a paragraph that
+// wikilinks can reference as [[Note#^block-id]].
+var blockMarker = regexp.MustCompile(`[ \t]+\^([A-Za-z0-9-]+)[ \t]*$`)
+
+// isImageEmbed reports whether n embeds an image file.
+func isImageEmbed(n *parser.WikiLink) bool {
+	return n.Embed && imageExtensions[strings.ToLower(path.Ext(n.Target))]
+}
+
+// noteEmbed returns the ![[note]] embed that makes up the whole paragraph n,
+// if any.
+func noteEmbed(n ast.Node) (*parser.WikiLink, bool) {
+	if n.ChildCount() != 1 {
+		return nil, false
+	}
+	link, ok := n.FirstChild().(*parser.WikiLink)
+	if !ok || !link.Embed || link.Target == "" || isImageEmbed(link) {
+		return nil, false
+	}
+	return link, true
+}
+
+// transclude replaces an ![[note]] embed with the content of the note. It
+// returns false when the note cannot be transcluded and the embed should be
+// rendered as a link instead.
+func (r *renderer) transclude(n *parser.WikiLink) (bool, error) {
+	if r.options.Transclude == nil || r.embedDepth >= maxEmbedDepth {
+		return false, nil
+	}
+	markdown, options, ok := r.options.Transclude(n.Target, n.Fragment)
+	if !ok {
+		return false, nil
+	}
+	if options == nil {
+		options = r.options
+	}
+
+	r.embedDepth++
+	err := r.renderMarkdownWith(markdown, options)
+	r.embedDepth--
+	return true, err
+}
+
+// renderWikiLink appends a wikilink as linked text, or an image embed as an
+// image.
+func (r *renderer) renderWikiLink(n *parser.WikiLink) {
+	if isImageEmbed(n) {
+		r.renderImage(n)
+		return
+	}
+
+	r.appendInline(&confluence.ADFText{
+		Type:  "text",
+		Text:  wikiLinkText(n),
+		Marks: append(r.inlineMarks(n), linkMark(wikiLinkHref(n, r.anchors, r.options), "")),
+	})
+}
+
+// wikiLinkText returns the text a wikilink is displayed with: its alias, or
+// the target and fragment as Obsidian shows them.
+func wikiLinkText(n *parser.WikiLink) string {
+	switch {
+	case n.Alias != "":
+		return n.Alias
+	case n.Target == "":
+		return strings.TrimPrefix(n.Fragment, "^")
+	case n.Fragment != "":
+		return n.Target + " > " + n.Fragment
+	}
+	return n.Target
+}
+
+// wikiLinkHref returns the href of a wikilink. Links within the page point
+// at the anchor of the heading or block; links to other notes are resolved
+// with the ResolveWikiLink option and otherwise keep the escaped note name.
+func wikiLinkHref(n *parser.WikiLink, anchors map[string]string, options *Options) string {
+	if n.Target == "" {
+		return "#" + FragmentAnchor(n.Fragment, anchors)
+	}
+	if options.ResolveWikiLink != nil {
+		if href, ok := options.ResolveWikiLink(n.Target, n.Fragment); ok {
+			return href
+		}
+	}
+
+	href := url.PathEscape(n.Target)
+	if n.Fragment != "" {
+		href += "#" + url.PathEscape(n.Fragment)
+	}
+	return href
+}
+
+// FragmentAnchor returns the Confluence anchor for the fragment of a
+// wikilink: the anchor of a "^block-id" reference, or that of the heading
+// named by the fragment among the heading anchors of the target page.
+func FragmentAnchor(fragment string, anchors map[string]string) string {
+	if id, ok := strings.CutPrefix(fragment, "^"); ok {
+		return id
+	}
+	if anchor, ok := anchors[HeadingSlug(fragment)]; ok {
+		return anchor
+	}
+	return confluenceAnchor(fragment, map[string]int{})
+}
+
+// paragraphBlockID returns the block ID that ends paragraph n and the source
+// offset at which its marker starts.
+func paragraphBlockID(n ast.Node, source []byte) (string, int, bool) {
+	if n.Kind() != ast.KindParagraph {
+		return "", 0, false
+	}
+	last, ok := n.LastChild().(*ast.Text)
+	if !ok {
+		return "", 0, false
+	}
+	value := last.Segment.Value(source)
+	m := blockMarker.FindSubmatchIndex(value)
+	if m == nil {
+		return "", 0, false
+	}
+	return string(value[m[2]:m[3]]), last.Segment.Start + m[0], true
+}
@@ -0,0 +1,94 @@
+// Package emoji maps emoji shortcodes such as :smile: to Confluence emoji.
+package emoji
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Emoji is a Confluence emoji.
+type Emoji struct {
+	ShortName string `json:"shortName"` // Shortcode including the colons, e.g. ":smile:"
+	ID        string `json:"id"`        // Confluence emoji ID
+	Text      string `json:"text"`      // Text shown where the emoji cannot be rendered
+}
+
+//go:embed shortcodes.txt
+var shortcodes string
+
+var (
+	standardOnce sync.Once
+	standard     map[string]Emoji
+)
+
+// Lookup returns the standard emoji for a shortcode name without colons.
+func Lookup(name string) (Emoji, bool) {
+	standardOnce.Do(loadStandard)
+	e, ok := standard[name]
+	return e, ok
+}
+
+// loadStandard parses the bundled table of shortcodes. Each line holds a
+// shortcode name and the emoji characters, separated by a tab.
+func loadStandard() {
+	standard = make(map[string]Emoji)
+	for _, line := range strings.Split(shortcodes, "\n") {
+		name, text, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		standard[name] = Emoji{
+			ShortName: ":" + name + ":",
+			ID:        unicodeID(text),
+			Text:      text,
+		}
+	}
+}
+
+// unicodeID returns the Confluence ID of a standard emoji: its code points in
+// lower-case hex joined by dashes, without variation selectors.
+func unicodeID(text string) string {
+	var points []string
+	for _, r := range text {
+		if r == '\ufe0f' {
+			continue
+		}
+		points = append(points, fmt.Sprintf("%x", r))
+	}
+	return strings.Join(points, "-")
+}
+
+// LoadFile reads a map of custom emoji from a JSON file. The file maps
+// shortcode names without colons to objects with the emoji "id" and an
+// optional fallback "text":
+//
+//	{"partyparrot": {"id": "a1b2c3", "text": ":partyparrot:"}}
+//
+// The short name of each emoji is derived from its key.
+func LoadFile(path string) (map[string]Emoji, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading emoji map: %w", err)
+	}
+
+	var custom map[string]Emoji
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return nil, fmt.Errorf("error parsing emoji map %s: %w", path, err)
+	}
+
+	for name, e := range custom {
+		if e.ID == "" {
+			return nil, fmt.Errorf("emoji map %s: emoji %q has no id", path, name)
+		}
+		e.ShortName = ":" + name + ":"
+		if e.Text == "" {
+			e.Text = e.ShortName
+		}
+		custom[name] = e
+	}
+	return custom, nil
+}
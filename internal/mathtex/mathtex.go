@@ -0,0 +1,56 @@
+// Package mathtex renders LaTeX math expressions to image files.
+package mathtex
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// RenderFormula renders a LaTeX expression to an SVG file in dir using the
+// tex2svg CLI from mathjax-node-cli and returns the path of the file. Inline
+// expressions are typeset in text style, display expressions in display style.
+// The file is named by FileName, so a formula that is already in dir is not
+// rendered again. An error is returned when tex2svg is not installed or fails.
+func RenderFormula(latex string, display bool, dir string) (string, error) {
+	file := filepath.Join(dir, FileName(latex, display))
+	if _, err := os.Stat(file); err == nil {
+		return file, nil
+	}
+
+	bin, err := exec.LookPath("tex2svg")
+	if err != nil {
+		return "", fmt.Errorf("tex2svg not found: %w", err)
+	}
+
+	args := []string{}
+	if !display {
+		args = append(args, "--inline")
+	}
+	args = append(args, latex)
+
+	out, err := exec.Command(bin, args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("tex2svg failed: %w", err)
+	}
+
+	if err := os.WriteFile(file, out, 0644); err != nil {
+		return "", err
+	}
+	return file, nil
+}
+
+// FileName returns the name of the SVG file of a formula: a hash of the
+// expression and its style, so that different formulas attached to the
+// same page never share a name.
+func FileName(latex string, display bool) string {
+	style := "inline:"
+	if display {
+		style = "display:"
+	}
+	sum := sha256.Sum256([]byte(style + latex))
+	return "formula-" + hex.EncodeToString(sum[:8]) + ".svg"
+}
@@ -0,0 +1,75 @@
+package parser
+
+import (
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Emoji is an emoji shortcode such as :smile:. The parser accepts any
+// well-formed shortcode; whether it names a known emoji is decided when
+// rendering.
+type Emoji struct {
+	ast.BaseInline
+	// Segment is the shortcode including the colons.
+	Segment text.Segment
+}
+
+// KindEmoji is the NodeKind of Emoji nodes.
+var KindEmoji = ast.NewNodeKind("Emoji")
+
+// Kind implements ast.Node.Kind.
+func (n *Emoji) Kind() ast.NodeKind {
+	return KindEmoji
+}
+
+// Dump implements ast.Node.Dump.
+func (n *Emoji) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{
+		"Name": n.Name(source),
+	}, nil)
+}
+
+// Name returns the shortcode name without the colons.
+func (n *Emoji) Name(source []byte) string {
+	value := n.Segment.Value(source)
+	return string(value[1 : len(value)-1])
+}
+
+type emojiParser struct{}
+
+func (s *emojiParser) Trigger() []byte {
+	return []byte{':'}
+}
+
+// Parse recognises a shortcode made of letters, digits, "_", "+" and "-"
+// between colons.
+func (s *emojiParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, segment := block.PeekLine()
+	i := 1
+	for ; i < len(line) && isShortcodeChar(line[i]); i++ {
+	}
+	if i == 1 || i >= len(line) || line[i] != ':' {
+		return nil
+	}
+	block.Advance(i + 1)
+	return &Emoji{Segment: text.NewSegment(segment.Start, segment.Start+i+1)}
+}
+
+func isShortcodeChar(c byte) bool {
+	return util.IsAlphaNumeric(c) || c == '_' || c == '+' || c == '-'
+}
+
+type emojiExtension struct{}
+
+// EmojiShortcodes is a goldmark extension that parses emoji shortcodes into
+// Emoji nodes.
+var EmojiShortcodes goldmark.Extender = &emojiExtension{}
+
+func (e *emojiExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithInlineParsers(util.Prioritized(&emojiParser{}, 999)),
+	)
+}
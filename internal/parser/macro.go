@@ -0,0 +1,83 @@
+package parser
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Macro is an inline Confluence macro written as {{macro:name parameters}},
+// such as {{macro:status colour=Green title=Done}}.
+type Macro struct {
+	ast.BaseInline
+	// Name is the macro key.
+	Name string
+	// Parameters holds the key=value parameters after the name, unparsed.
+	Parameters string
+}
+
+// KindMacro is the NodeKind of Macro nodes.
+var KindMacro = ast.NewNodeKind("Macro")
+
+// Kind implements ast.Node.Kind.
+func (n *Macro) Kind() ast.NodeKind {
+	return KindMacro
+}
+
+// Dump implements ast.Node.Dump.
+func (n *Macro) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{
+		"Name":       n.Name,
+		"Parameters": n.Parameters,
+	}, nil)
+}
+
+var (
+	macroOpener = []byte("{{macro:")
+	macroCloser = []byte("}}")
+)
+
+type macroParser struct{}
+
+func (s *macroParser) Trigger() []byte {
+	return []byte{'{'}
+}
+
+// Parse recognises {{macro:name parameters}} on a single line.
+func (s *macroParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	if !bytes.HasPrefix(line, macroOpener) {
+		return nil
+	}
+
+	inner := line[len(macroOpener):]
+	end := bytes.Index(inner, macroCloser)
+	if end <= 0 {
+		return nil
+	}
+
+	name, parameters, _ := strings.Cut(string(inner[:end]), " ")
+	if name == "" {
+		return nil
+	}
+
+	block.Advance(len(macroOpener) + end + len(macroCloser))
+	return &Macro{Name: name, Parameters: strings.TrimSpace(parameters)}
+}
+
+type macroExtension struct{}
+
+// Macros is a goldmark extension that parses inline Confluence macros into
+// Macro nodes.
+var Macros goldmark.Extender = &macroExtension{}
+
+func (e *macroExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithInlineParsers(util.Prioritized(&macroParser{}, 199)),
+	)
+}
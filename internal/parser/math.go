@@ -0,0 +1,203 @@
+package parser
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// MathInline is an inline LaTeX expression written as $...$, or as $$...$$
+// in the middle of a line.
+type MathInline struct {
+	ast.BaseInline
+	// Segment is the LaTeX source between the dollar signs.
+	Segment text.Segment
+	// Display reports whether the expression was written with double dollar
+	// signs and is meant to be typeset in display style.
+	Display bool
+}
+
+// KindMathInline is the NodeKind of MathInline nodes.
+var KindMathInline = ast.NewNodeKind("MathInline")
+
+// Kind implements ast.Node.Kind.
+func (n *MathInline) Kind() ast.NodeKind {
+	return KindMathInline
+}
+
+// Dump implements ast.Node.Dump.
+func (n *MathInline) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{
+		"Value": string(n.Value(source)),
+	}, nil)
+}
+
+// Value returns the LaTeX source of the expression.
+func (n *MathInline) Value(source []byte) []byte {
+	return n.Segment.Value(source)
+}
+
+// MathBlock is a display LaTeX expression delimited by lines starting and
+// ending with $$. The LaTeX source is held in the node's lines.
+type MathBlock struct {
+	ast.BaseBlock
+	closed bool // The closing $$ has been read
+}
+
+// KindMathBlock is the NodeKind of MathBlock nodes.
+var KindMathBlock = ast.NewNodeKind("MathBlock")
+
+// Kind implements ast.Node.Kind.
+func (n *MathBlock) Kind() ast.NodeKind {
+	return KindMathBlock
+}
+
+// IsRaw implements ast.Node.IsRaw.
+func (n *MathBlock) IsRaw() bool {
+	return true
+}
+
+// Dump implements ast.Node.Dump.
+func (n *MathBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+var mathDelimiter = []byte("$$")
+
+type mathBlockParser struct{}
+
+func (b *mathBlockParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+func (b *mathBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 || !bytes.HasPrefix(line[pos:], mathDelimiter) {
+		return nil, parser.NoChildren
+	}
+
+	node := &MathBlock{}
+	start := segment.Start + pos + len(mathDelimiter)
+	rest := util.TrimRightSpace(line[pos+len(mathDelimiter):])
+	if bytes.HasSuffix(rest, mathDelimiter) {
+		// $$ x $$ on a single line.
+		stop := start + len(rest) - len(mathDelimiter)
+		if stop > start {
+			node.Lines().Append(text.NewSegment(start, stop))
+		}
+		node.closed = true
+	} else if !util.IsBlank(rest) {
+		node.Lines().Append(text.NewSegment(start, segment.Stop))
+	}
+	return node, parser.NoChildren
+}
+
+func (b *mathBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	block := node.(*MathBlock)
+	if block.closed {
+		return parser.Close
+	}
+
+	line, segment := reader.PeekLine()
+	if line == nil {
+		return parser.Close
+	}
+	trimmed := util.TrimRightSpace(line)
+	if bytes.HasSuffix(trimmed, mathDelimiter) {
+		stop := segment.Start + len(trimmed) - len(mathDelimiter)
+		if !util.IsBlank(line[:len(trimmed)-len(mathDelimiter)]) {
+			block.Lines().Append(text.NewSegment(segment.Start, stop))
+		}
+		block.closed = true
+		advanceLine(reader, line, segment)
+		return parser.Close
+	}
+
+	block.Lines().Append(segment)
+	advanceLine(reader, line, segment)
+	return parser.Continue | parser.NoChildren
+}
+
+// advanceLine consumes the rest of the current line except its newline, so
+// the line is not offered to other block parsers.
+func advanceLine(reader text.Reader, line []byte, segment text.Segment) {
+	newline := 0
+	if line[len(line)-1] == '\n' {
+		newline = 1
+	}
+	reader.Advance(segment.Stop - segment.Start - newline + segment.Padding)
+}
+
+func (b *mathBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (b *mathBlockParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (b *mathBlockParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+type mathInlineParser struct{}
+
+func (s *mathInlineParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+// Parse recognises $...$ and $$...$$ on a single line. Following Pandoc, the
+// opening dollar sign must be followed by a non-space character and the
+// closing one preceded by a non-space character and not followed by a digit
+// or another dollar sign, so prices such as "$5 and $10" stay plain text.
+func (s *mathInlineParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, segment := block.PeekLine()
+	opener := 1
+	if len(line) > 1 && line[1] == '$' {
+		opener = 2
+	}
+	if opener >= len(line) || util.IsSpace(line[opener]) || line[opener] == '$' {
+		return nil
+	}
+
+	for i := opener + 1; i < len(line); i++ {
+		switch {
+		case line[i] == '\\':
+			i++
+		case line[i] == '\n':
+			return nil
+		case line[i] == '$':
+			if opener == 2 && (i+1 >= len(line) || line[i+1] != '$') {
+				continue
+			}
+			if util.IsSpace(line[i-1]) {
+				continue
+			}
+			if opener == 1 && (line[i-1] == '$' || i+1 < len(line) && (line[i+1] == '$' || util.IsNumeric(line[i+1]))) {
+				continue
+			}
+			block.Advance(i + opener)
+			return &MathInline{
+				Segment: text.NewSegment(segment.Start+opener, segment.Start+i),
+				Display: opener == 2,
+			}
+		}
+	}
+	return nil
+}
+
+type mathExtension struct{}
+
+// Math is a goldmark extension that parses LaTeX math written between dollar
+// signs into MathInline and MathBlock nodes.
+var Math goldmark.Extender = &mathExtension{}
+
+func (e *mathExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithBlockParsers(util.Prioritized(&mathBlockParser{}, 90)),
+		parser.WithInlineParsers(util.Prioritized(&mathInlineParser{}, 150)),
+	)
+}
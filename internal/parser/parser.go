@@ -20,11 +20,17 @@ func NewMarkdownParser() *MarkdownParser {
 	p := parser.NewParser(
 		parser.WithBlockParsers(parser.DefaultBlockParsers()...),
 		parser.WithInlineParsers(parser.DefaultInlineParsers()...),
+		parser.WithHeadingAttribute(),
 	)
 
 	md := goldmark.New(
 		goldmark.WithExtensions(
 			extension.GFM,
+			extension.Footnote,
+			Math,
+			EmojiShortcodes,
+			WikiLinks,
+			Macros,
 		),
 		goldmark.WithParser(p),
 	)
@@ -0,0 +1,98 @@
+package parser
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// WikiLink is an Obsidian-style [[Target#Fragment|Alias]] link, or an
+// ![[Target]] embed.
+type WikiLink struct {
+	ast.BaseInline
+	// Target is the note or file name; it is empty for links within the
+	// same note.
+	Target string
+	// Fragment is the heading, or "^" followed by the block ID, after "#".
+	Fragment string
+	// Alias is the text after "|".
+	Alias string
+	// Embed reports whether the link was written as ![[...]].
+	Embed bool
+}
+
+// KindWikiLink is the NodeKind of WikiLink nodes.
+var KindWikiLink = ast.NewNodeKind("WikiLink")
+
+// Kind implements ast.Node.Kind.
+func (n *WikiLink) Kind() ast.NodeKind {
+	return KindWikiLink
+}
+
+// Dump implements ast.Node.Dump.
+func (n *WikiLink) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{
+		"Target":   n.Target,
+		"Fragment": n.Fragment,
+		"Alias":    n.Alias,
+	}, nil)
+}
+
+var (
+	wikiLinkOpener = []byte("[[")
+	wikiLinkCloser = []byte("]]")
+)
+
+type wikiLinkParser struct{}
+
+func (s *wikiLinkParser) Trigger() []byte {
+	return []byte{'[', '!'}
+}
+
+// Parse recognises [[...]] and ![[...]] on a single line.
+func (s *wikiLinkParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	start := 0
+	if line[0] == '!' {
+		start = 1
+	}
+	if !bytes.HasPrefix(line[start:], wikiLinkOpener) {
+		return nil
+	}
+
+	inner := line[start+len(wikiLinkOpener):]
+	end := bytes.Index(inner, wikiLinkCloser)
+	if end <= 0 || bytes.ContainsAny(inner[:end], "[]\n") {
+		return nil
+	}
+
+	link := &WikiLink{Embed: start == 1}
+	target := string(inner[:end])
+	target, link.Alias, _ = strings.Cut(target, "|")
+	target, link.Fragment, _ = strings.Cut(target, "#")
+	link.Target = strings.TrimSpace(target)
+	link.Fragment = strings.TrimSpace(link.Fragment)
+	if link.Target == "" && link.Fragment == "" {
+		return nil
+	}
+
+	block.Advance(start + len(wikiLinkOpener) + end + len(wikiLinkCloser))
+	return link
+}
+
+type wikiLinkExtension struct{}
+
+// WikiLinks is a goldmark extension that parses Obsidian-style wikilinks and
+// embeds into WikiLink nodes.
+var WikiLinks goldmark.Extender = &wikiLinkExtension{}
+
+func (e *wikiLinkExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithInlineParsers(util.Prioritized(&wikiLinkParser{}, 199)),
+	)
+}
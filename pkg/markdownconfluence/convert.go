@@ -6,8 +6,11 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/yuin/goldmark/ast"
+	"go-markdown-confluence/internal/adfschema"
 	"go-markdown-confluence/internal/confluence"
 	"go-markdown-confluence/internal/converter"
+	"go-markdown-confluence/internal/emoji"
 	"go-markdown-confluence/internal/parser"
 	"gopkg.in/yaml.v3"
 	"os"
@@ -24,18 +27,79 @@ type ConfluenceClient interface {
 	UpdatePage(pageID, title, content, spaceKey string, version int) error
 	// GetPageByTitle retrieves a page by its title.
 	GetPageByTitle(spaceKey, title string) (*confluence.Page, error)
-	// UploadAttachment uploads an attachment to the given page and returns the attachment ID or URL.
-	UploadAttachment(pageID, filePath string) error
+	// UploadAttachment uploads an attachment to the given page and returns the uploaded attachment.
+	UploadAttachment(pageID, filePath string) (*confluence.Attachment, error)
 }
 
+// RenderOptions controls how Markdown constructs are rendered to ADF.
+type RenderOptions = converter.Options
+
+// DefaultRenderOptions returns the render options used by Convert.
+func DefaultRenderOptions() *RenderOptions {
+	return converter.DefaultOptions()
+}
+
+// HTMLPolicy decides what happens to HTML that has no ADF equivalent.
+type HTMLPolicy = converter.HTMLPolicy
+
+// Policies for HTML that has no ADF equivalent.
+const (
+	HTMLDrop = converter.HTMLDrop // Leave the HTML out of the page
+	HTMLKeep = converter.HTMLKeep // Publish the HTML as literal text
+	HTMLFail = converter.HTMLFail // Fail the conversion
+)
+
+// Emoji is a Confluence emoji that a shortcode such as :smile: stands for.
+type Emoji = emoji.Emoji
+
+// LoadEmojiFile reads a JSON map of custom emoji, keyed by shortcode name
+// without colons, for use as RenderOptions.Emoji.
+func LoadEmojiFile(path string) (map[string]Emoji, error) {
+	return emoji.LoadFile(path)
+}
+
+// MathMode decides how LaTeX math is published.
+type MathMode = converter.MathMode
+
+// Ways of publishing LaTeX math.
+const (
+	MathExtension = converter.MathExtension // Confluence math macros
+	MathImage     = converter.MathImage     // Rendered images
+	MathCode      = converter.MathCode      // LaTeX source as code
+)
+
+// Deployment is the kind of Confluence site pages are published to.
+type Deployment = confluence.Deployment
+
+// Kinds of Confluence sites.
+const (
+	Cloud      = confluence.Cloud      // Confluence Cloud
+	DataCenter = confluence.DataCenter // Confluence Server or Data Center
+)
+
+// Format is the format Markdown is converted to for publishing.
+type Format string
+
+// Formats of converted pages.
+const (
+	FormatADF     Format = "adf"     // Atlassian Document Format JSON, for Confluence Cloud
+	FormatStorage Format = "storage" // Confluence storage format XHTML, for Data Center and Cloud
+)
+
 // ConversionResult holds the result of a Markdown file conversion.
 type ConversionResult struct {
-	FilePath         string   // Original Markdown file path
-	Title            string   // Page title derived from filename
-	ConvertedContent string   // Converted content in ADF JSON format
-	TargetPath       string   // Target path after applying mapping
-	ImagePaths       []string // Paths to image files referenced in the Markdown
-	PageID           string   // Existing Confluence page ID for updates
+	FilePath         string      // Original Markdown file path
+	Title            string      // Page title derived from filename
+	ConvertedContent string      // Converted content in ADF JSON or storage format
+	TargetPath       string      // Target path after applying mapping
+	ImagePaths       []string    // Paths to image files referenced in the Markdown
+	PageID           string      // Existing Confluence page ID for updates
+	UnresolvedLinks  []string    // Relative links to Markdown files outside the publish set
+	Warnings         []string    // Problems that did not fail the conversion, such as invalid raw ADF
+	Violations       []Violation // ADF schema violations, when ConvertDirectoryOptions.Validate is set
+
+	source       *sourceFile // File the result was converted from
+	pendingLinks bool        // Whether some links or images wait for pages or attachments that are not published yet
 }
 
 // Convert takes a Markdown string and converts it to a Confluence-compatible format.
@@ -45,19 +109,6 @@ func stripObsidianComments(markdown string) string {
 	return re.ReplaceAllString(markdown, "")
 }
 
-func replaceWikiLinks(markdown string) string {
-	re := regexp.MustCompile(`\[\[(.*?)\]\]`)
-	return re.ReplaceAllStringFunc(markdown, func(m string) string {
-		match := re.FindStringSubmatch(m)
-		if len(match) < 2 {
-			return m
-		}
-		inner := match[1]
-		escaped := strings.ReplaceAll(inner, " ", "%20")
-		return "[" + inner + "](" + escaped + ")"
-	})
-}
-
 func extractImagePaths(markdown string) []string {
 	re := regexp.MustCompile(`!\[[^\]]*\]\(([^)]+)\)`)
 	matches := re.FindAllStringSubmatch(markdown, -1)
@@ -92,12 +143,80 @@ func extractFrontmatter(markdown string) (map[string]interface{}, string) {
 }
 
 func Convert(markdown string) (string, error) {
+	return ConvertWithOptions(markdown, nil)
+}
+
+// ConvertWithOptions is like Convert but renders with the given options.
+// A nil options value is equivalent to DefaultRenderOptions.
+func ConvertWithOptions(markdown string, options *RenderOptions) (string, error) {
+	adfDocument, _, err := convertMarkdown(markdown, options)
+	if err != nil || adfDocument == nil {
+		return "", err
+	}
+	return serializeDocument(adfDocument)
+}
+
+// ConvertToStorage is like ConvertWithOptions but converts Markdown to the
+// Confluence storage format instead of ADF. A nil options value is
+// equivalent to DefaultRenderOptions.
+func ConvertToStorage(markdown string, options *RenderOptions) (string, error) {
+	document, source, err := parseMarkdown(markdown)
+	if err != nil || document == nil {
+		return "", err
+	}
+
+	storage, err := converter.ConvertToStorage(document, source, options)
+	if err != nil {
+		return "", fmt.Errorf("failed to convert Markdown to Confluence format: %w", err)
+	}
+	return storage, nil
+}
+
+// Violation is a place where converted Markdown breaks the ADF schema, with
+// the JSON path of the offending node and the Markdown line it came from.
+type Violation = adfschema.Violation
+
+// Validate converts Markdown to ADF and checks the result against the
+// bundled ADF schema. It returns the violations found, if any.
+func Validate(markdown string) ([]Violation, error) {
+	return ValidateWithOptions(markdown, nil)
+}
+
+// ValidateWithOptions is like Validate but renders with the given options.
+// A nil options value is equivalent to DefaultRenderOptions.
+func ValidateWithOptions(markdown string, options *RenderOptions) ([]Violation, error) {
+	adfDocument, sourceMap, err := convertMarkdown(markdown, options)
+	if err != nil || adfDocument == nil {
+		return nil, err
+	}
+	return adfschema.Validate(adfDocument, sourceMap)
+}
+
+// convertMarkdown converts Markdown to an ADF document and the source map
+// of its nodes. The document is nil for empty Markdown.
+func convertMarkdown(markdown string, options *RenderOptions) (*confluence.ADFDocument, converter.SourceMap, error) {
+	document, markdownBytes, err := parseMarkdown(markdown)
+	if err != nil || document == nil {
+		return nil, nil, err
+	}
+
+	adfDocument, sourceMap, err := converter.ConvertToADFWithSourceMap(document, markdownBytes, options)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to convert Markdown to Confluence format: %w", err)
+	}
+
+	return adfDocument, sourceMap, nil
+}
+
+// parseMarkdown parses Markdown without its Obsidian comments and returns
+// the AST together with the source it refers to. The AST is nil for empty
+// Markdown.
+func parseMarkdown(markdown string) (ast.Node, []byte, error) {
 	markdown = stripObsidianComments(markdown)
-	markdown = replaceWikiLinks(markdown)
 
 	for _, r := range markdown {
 		if r == '\x00' {
-			return "", fmt.Errorf("invalid Markdown: contains null character")
+			return nil, nil, fmt.Errorf("invalid Markdown: contains null character")
 		}
 	}
 
@@ -105,19 +224,18 @@ func Convert(markdown string) (string, error) {
 	document := mdParser.Parse(markdown)
 
 	if document == nil {
-		return "", nil
+		return nil, nil, nil
 	}
 
 	if !document.HasChildren() {
-		return "", fmt.Errorf("invalid Markdown: parsed AST has no children")
+		return nil, nil, fmt.Errorf("invalid Markdown: parsed AST has no children")
 	}
 
-	markdownBytes := []byte(markdown)
-	adfDocument, err := converter.ConvertToADF(document, markdownBytes)
-	if err != nil {
-		return "", fmt.Errorf("failed to convert Markdown to Confluence format: %w", err)
-	}
+	return document, []byte(markdown), nil
+}
 
+// serializeDocument returns the JSON of an ADF document.
+func serializeDocument(adfDocument *confluence.ADFDocument) (string, error) {
 	jsonContent, err := converter.SerializeToJSON(adfDocument)
 	if err != nil {
 		return "", fmt.Errorf("failed to serialize Confluence document to JSON: %w", err)
@@ -126,6 +244,16 @@ func Convert(markdown string) (string, error) {
 	return jsonContent, nil
 }
 
+// containsString reports whether values contains value.
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -135,9 +263,26 @@ func min(a, b int) int {
 
 // ConvertDirectoryOptions holds options for the ConvertDirectory function.
 type ConvertDirectoryOptions struct {
-	DryRun          bool   // If true, skip uploading to Confluence
-	OutputDirectory string // Directory to save converted files (only used when DryRun is true)
-	DefaultSpaceKey string // Default space key to use for Confluence
+	DryRun          bool           // If true, skip uploading to Confluence
+	OutputDirectory string         // Directory to save converted files (only used when DryRun is true)
+	DefaultSpaceKey string         // Default space key to use for Confluence
+	BaseURL         string         // Confluence URL that links between pages are built from, ending with the context path
+	Deployment      Deployment     // Kind of site published to, which decides the form of page links; Cloud when empty
+	Format          Format         // Format pages are converted to; FormatStorage for DataCenter and FormatADF otherwise when empty
+	Render          *RenderOptions // Options for rendering Markdown to ADF or storage format
+	Warn            func(string)   // Receives warnings such as links outside the publish set (optional)
+	Validate        bool           // If true, check every page against the ADF schema and publish nothing if one fails
+}
+
+// format returns the format pages are converted to.
+func (o *ConvertDirectoryOptions) format() Format {
+	switch {
+	case o.Format != "":
+		return o.Format
+	case o.Deployment == DataCenter:
+		return FormatStorage
+	}
+	return FormatADF
 }
 
 // DefaultConvertOptions returns the default options for ConvertDirectory.
@@ -146,6 +291,8 @@ func DefaultConvertOptions() *ConvertDirectoryOptions {
 		DryRun:          false,
 		OutputDirectory: "",
 		DefaultSpaceKey: "DOCS",
+		BaseURL:         "/wiki",
+		Render:          DefaultRenderOptions(),
 	}
 }
 
@@ -156,67 +303,57 @@ func ConvertDirectoryWithResults(dirPath string, fileMapping map[string]string,
 	if options == nil {
 		options = DefaultConvertOptions()
 	}
+	results, resolver, err := convertDirectory(dirPath, fileMapping, options, options.DefaultSpaceKey)
+	resolver.cleanup()
+	return results, err
+}
 
-	var results []ConversionResult
-
-	var markdownFiles []string
-	err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return fmt.Errorf("error accessing path %s: %w", path, err)
-		}
-
-		if info.IsDir() {
-			return nil
-		}
-
-		if filepath.Ext(path) != ".md" {
-			return nil
+// convertDirectory converts every Markdown file under dirPath. Links between
+// the files resolve to the pages of spaceKey that are already known; the
+// returned resolver resolves them again once more pages are published, and
+// must be cleaned up once they are.
+func convertDirectory(dirPath string, fileMapping map[string]string, options *ConvertDirectoryOptions, spaceKey string) (_ []ConversionResult, _ *linkResolver, err error) {
+	switch options.format() {
+	case FormatADF:
+	case FormatStorage:
+		if options.Validate {
+			return nil, nil, fmt.Errorf("pages in storage format cannot be checked against the ADF schema")
 		}
+	default:
+		return nil, nil, fmt.Errorf("unknown format %q, expected %q or %q", options.Format, FormatADF, FormatStorage)
+	}
 
-		markdownFiles = append(markdownFiles, path)
-		return nil
-	})
-
+	set, err := readSourceSet(dirPath)
 	if err != nil {
-		return nil, fmt.Errorf("error finding markdown files: %w", err)
+		return nil, nil, err
 	}
-
-	// Process each markdown file
-	for _, path := range markdownFiles {
-		contentBytes, err := os.ReadFile(path)
+	resolver := &linkResolver{set: set, mapping: fileMapping, baseURL: options.BaseURL, space: spaceKey, deployment: options.Deployment}
+	defer func() {
 		if err != nil {
-			return nil, fmt.Errorf("failed to read file %s: %w", path, err)
+			resolver.cleanup()
 		}
+	}()
 
-		fm, body := extractFrontmatter(string(contentBytes))
-		imagePaths := extractImagePaths(body)
-		pageID, _ := fm["connie-page-id"].(string)
+	var results []ConversionResult
 
-		confluenceContent, err := Convert(body)
+	// Process each markdown file
+	for _, file := range set.files {
+		result, err := convertFile(file, fileMapping, resolver, options)
 		if err != nil {
-			return nil, fmt.Errorf("failed to convert file %s: %w", path, err)
-		}
-
-		targetPath, exists := fileMapping[path]
-		if !exists {
-			targetPath = path
+			return nil, nil, err
 		}
 
-		title := filepath.Base(targetPath)
-		title = title[:len(title)-len(filepath.Ext(title))]
-		if v, ok := fm["connie-title"].(string); ok && v != "" {
-			title = v
+		if options.Warn != nil {
+			for _, warning := range result.Warnings {
+				options.Warn(fmt.Sprintf("%s: %s", file.path, warning))
+			}
+			for _, link := range result.UnresolvedLinks {
+				options.Warn(fmt.Sprintf("%s: link to %s is outside the publish set", file.path, link))
+			}
 		}
 
 		// Add result to the collection
-		results = append(results, ConversionResult{
-			FilePath:         path,
-			Title:            title,
-			ConvertedContent: confluenceContent,
-			TargetPath:       targetPath,
-			ImagePaths:       imagePaths,
-			PageID:           pageID,
-		})
+		results = append(results, result)
 
 		// Save converted content to file if in dry run mode with output directory specified
 		if options.DryRun && options.OutputDirectory != "" {
@@ -224,11 +361,11 @@ func ConvertDirectoryWithResults(dirPath string, fileMapping map[string]string,
 
 			// Create output directory if it doesn't exist
 			if err := os.MkdirAll(outputDir, 0755); err != nil {
-				return nil, fmt.Errorf("failed to create output directory %s: %w", outputDir, err)
+				return nil, nil, fmt.Errorf("failed to create output directory %s: %w", outputDir, err)
 			}
 
 			// Create a subfolder structure mirroring the original path if needed
-			relPath, err := filepath.Rel(dirPath, filepath.Dir(path))
+			relPath, err := filepath.Rel(dirPath, filepath.Dir(file.path))
 			if err != nil {
 				relPath = "" // If we can't get a relative path, use the root output directory
 			}
@@ -236,18 +373,181 @@ func ConvertDirectoryWithResults(dirPath string, fileMapping map[string]string,
 			// Update ConvertDirectoryWithResults to handle nested folder structures
 			outputSubdir := filepath.Join(outputDir, relPath)
 			if err := os.MkdirAll(outputSubdir, 0755); err != nil {
-				return nil, fmt.Errorf("failed to create output subdirectory %s: %w", outputSubdir, err)
+				return nil, nil, fmt.Errorf("failed to create output subdirectory %s: %w", outputSubdir, err)
 			}
 
 			// Ensure the nested folder structure is preserved in the output directory
-			outputPath := filepath.Join(outputSubdir, title+".json")
-			if err := os.WriteFile(outputPath, []byte(confluenceContent), 0644); err != nil {
-				return nil, fmt.Errorf("failed to write converted file %s: %w", outputPath, err)
+			extension := ".json"
+			if options.format() == FormatStorage {
+				extension = ".xhtml"
+			}
+			outputPath := filepath.Join(outputSubdir, result.Title+extension)
+			if err := os.WriteFile(outputPath, []byte(result.ConvertedContent), 0644); err != nil {
+				return nil, nil, fmt.Errorf("failed to write converted file %s: %w", outputPath, err)
 			}
 		}
 	}
 
-	return results, nil
+	return results, resolver, nil
+}
+
+// convertFile converts a single Markdown file of a directory, resolving its
+// relative links with resolver.
+func convertFile(file *sourceFile, fileMapping map[string]string, resolver *linkResolver, options *ConvertDirectoryOptions) (ConversionResult, error) {
+	result := ConversionResult{
+		FilePath:   file.path,
+		ImagePaths: extractImagePaths(file.body),
+		PageID:     file.pageID,
+		source:     file,
+	}
+
+	render := DefaultRenderOptions()
+	if options.Render != nil {
+		copied := *options.Render
+		render = &copied
+	}
+	if render.Math == MathImage && render.MathRenderer == nil {
+		render.MathRenderer = resolver.renderFormula
+	}
+	render.Warn = func(message string) {
+		result.Warnings = append(result.Warnings, message)
+	}
+	bindResolvers(render, file.path, &result, resolver, options.format())
+
+	if options.format() == FormatStorage {
+		var err error
+		result.ConvertedContent, err = ConvertToStorage(file.body, render)
+		if err != nil {
+			return ConversionResult{}, fmt.Errorf("failed to convert file %s: %w", file.path, err)
+		}
+		result.TargetPath, result.Title = pageTitle(file, fileMapping)
+		return result, nil
+	}
+
+	adfDocument, sourceMap, err := convertMarkdown(file.body, render)
+	if err != nil {
+		return ConversionResult{}, fmt.Errorf("failed to convert file %s: %w", file.path, err)
+	}
+	if adfDocument != nil {
+		result.ConvertedContent, err = serializeDocument(adfDocument)
+		if err != nil {
+			return ConversionResult{}, fmt.Errorf("failed to convert file %s: %w", file.path, err)
+		}
+	}
+
+	if options.Validate && adfDocument != nil {
+		result.Violations, err = adfschema.Validate(adfDocument, sourceMap)
+		if err != nil {
+			return ConversionResult{}, fmt.Errorf("failed to validate file %s: %w", file.path, err)
+		}
+		for i := range result.Violations {
+			if result.Violations[i].Line > 0 {
+				result.Violations[i].Line += file.bodyLine
+			}
+		}
+	}
+
+	result.TargetPath, result.Title = pageTitle(file, fileMapping)
+	return result, nil
+}
+
+// bindResolvers sets the resolvers of render for Markdown of the file at
+// path: the file of result, or a note that it embeds. What they find is
+// recorded in result, with images by their path from the file of result,
+// which they are attached to.
+func bindResolvers(render *RenderOptions, path string, result *ConversionResult, resolver *linkResolver, format Format) {
+	render.ResolveLink = func(destination string) string {
+		href, pending, outside := resolver.resolve(path, destination)
+		if pending {
+			result.pendingLinks = true
+		}
+		if outside {
+			result.UnresolvedLinks = append(result.UnresolvedLinks, destination)
+		}
+		return href
+	}
+	render.ResolveWikiLink = func(target, fragment string) (string, bool) {
+		href, ok, pending, outside := resolver.resolveWikiLink(path, target, fragment)
+		if pending {
+			result.pendingLinks = true
+		}
+		if outside {
+			result.UnresolvedLinks = append(result.UnresolvedLinks, "[["+target+"]]")
+		}
+		return href, ok
+	}
+	render.ResolveImage = func(src string) (string, string, bool) {
+		absPath, ok := resolver.imageFile(path, src)
+		if !ok {
+			return "", "", false
+		}
+		result.addImage(path, src, absPath)
+		if attachment, ok := result.source.attachments[absPath]; ok {
+			return attachment.FileID, attachment.Collection, true
+		}
+		result.pendingLinks = true
+		return "", "", false
+	}
+	render.Transclude = func(target, fragment string) (string, *RenderOptions, bool) {
+		markdown, notePath, ok := resolver.transclude(path, target, fragment)
+		if !ok {
+			return "", nil, false
+		}
+		note := *render
+		bindResolvers(&note, notePath, result, resolver, format)
+		return markdown, &note, true
+	}
+
+	if format == FormatStorage {
+		// Storage format links to pages by title and to attachments by
+		// file name, so nothing waits for pages or uploads.
+		render.ResolvePage = func(destination string) (string, string, bool) {
+			return resolver.resolvePage(path, destination)
+		}
+		render.ResolveWikiPage = func(target, fragment string) (string, string, bool) {
+			return resolver.resolveWikiPage(path, target, fragment)
+		}
+		render.ResolveAttachment = func(src string) (string, bool) {
+			absPath, ok := resolver.imageFile(path, src)
+			if !ok {
+				return "", false
+			}
+			result.addImage(path, src, absPath)
+			return filepath.Base(absPath), true
+		}
+	}
+}
+
+// addImage records an image that Markdown of the file at path refers to as
+// src, by its path from the file of the result.
+func (result *ConversionResult) addImage(path, src, absPath string) {
+	if path != result.FilePath && !filepath.IsAbs(src) {
+		if dir, err := filepath.Abs(filepath.Dir(result.FilePath)); err == nil {
+			if rel, err := filepath.Rel(dir, absPath); err == nil {
+				src = filepath.ToSlash(rel)
+			}
+		}
+	}
+	if !containsString(result.ImagePaths, src) {
+		result.ImagePaths = append(result.ImagePaths, src)
+	}
+}
+
+// pageTitle returns the target path of a file after applying the file
+// mapping, and the title of its page: the connie-title of its frontmatter,
+// or else the base name of the target path.
+func pageTitle(file *sourceFile, fileMapping map[string]string) (targetPath, title string) {
+	targetPath, exists := fileMapping[file.path]
+	if !exists {
+		targetPath = file.path
+	}
+
+	title = filepath.Base(targetPath)
+	title = title[:len(title)-len(filepath.Ext(title))]
+	if v, ok := file.front["connie-title"].(string); ok && v != "" {
+		title = v
+	}
+	return targetPath, title
 }
 
 // ConvertDirectory takes a directory path, processes all Markdown files within it,
@@ -264,11 +564,11 @@ func ConvertDirectoryWithOptions(dirPath string, fileMapping map[string]string,
 	}
 
 	if options.DryRun {
-		_, err := ConvertDirectoryWithResults(dirPath, fileMapping, options)
+		results, err := ConvertDirectoryWithResults(dirPath, fileMapping, options)
 		if err != nil {
 			return fmt.Errorf("error during dry run conversion: %w", err)
 		}
-		return nil
+		return validationError(results)
 	}
 
 	if len(fileMapping) == 0 {
@@ -285,14 +585,26 @@ func ConvertDirectoryWithOptions(dirPath string, fileMapping map[string]string,
 		})
 	}
 
-	results, err := ConvertDirectoryWithResults(dirPath, fileMapping, options)
+	if spaceKey == "" {
+		spaceKey = options.DefaultSpaceKey
+	}
+
+	if options.Deployment == DataCenter && options.format() != FormatStorage {
+		return fmt.Errorf("Confluence Data Center pages can only be published in %s format", FormatStorage)
+	}
+
+	results, resolver, err := convertDirectory(dirPath, fileMapping, options, spaceKey)
 	if err != nil {
 		return err
 	}
+	defer resolver.cleanup()
+	if err := validationError(results); err != nil {
+		return err
+	}
 
 	parentPageIDs := make(map[string]string)
 
-	for _, result := range results {
+	for i, result := range results {
 		relPath, err := filepath.Rel(dirPath, filepath.Dir(result.FilePath))
 		if err != nil {
 			return fmt.Errorf("failed to determine relative path for %s: %w", result.FilePath, err)
@@ -318,25 +630,119 @@ func ConvertDirectoryWithOptions(dirPath string, fileMapping map[string]string,
 			currentParentID = pageID
 		}
 
+		// Images are attached to the page, so a new page is created before
+		// its images are uploaded and updated with them afterwards. Existing
+		// pages get their images first and are updated once.
 		pageID := result.PageID
-		if pageID != "" {
-			err = confluenceClient.UpdatePage(pageID, result.Title, result.ConvertedContent, spaceKey, 1)
-			if err != nil {
-				return fmt.Errorf("failed to update page %s: %w", pageID, err)
-			}
-		} else {
+		if pageID == "" {
 			pageID, err = confluenceClient.CreatePage(spaceKey, result.Title, result.ConvertedContent, currentParentID)
 			if err != nil {
 				return fmt.Errorf("failed to upload file %s to Confluence: %w", result.FilePath, err)
 			}
 		}
 
-		for _, img := range result.ImagePaths {
-			absPath := filepath.Join(filepath.Dir(result.FilePath), img)
-			if err := confluenceClient.UploadAttachment(pageID, absPath); err != nil {
-				return fmt.Errorf("failed to upload attachment %s: %w", absPath, err)
+		result.source.pageID = pageID
+
+		uploaded, err := uploadImages(confluenceClient, pageID, result, resolver)
+		if err != nil {
+			return err
+		}
+
+		if result.PageID != "" {
+			if uploaded {
+				result, err = convertFile(result.source, fileMapping, resolver, options)
+				if err != nil {
+					return err
+				}
+				results[i] = result
+			}
+			version, err := nextVersion(confluenceClient, spaceKey, result.Title, 1)
+			if err != nil {
+				return err
+			}
+			err = confluenceClient.UpdatePage(pageID, result.Title, result.ConvertedContent, spaceKey, version)
+			if err != nil {
+				return fmt.Errorf("failed to update page %s: %w", pageID, err)
 			}
 		}
 	}
+
+	return updateForwardLinks(results, fileMapping, confluenceClient, resolver, options, spaceKey)
+}
+
+// nextVersion returns the version number that an update of the page titled
+// title must carry, or fallback when the page or its version is not found.
+func nextVersion(confluenceClient ConfluenceClient, spaceKey, title string, fallback int) (int, error) {
+	page, err := confluenceClient.GetPageByTitle(spaceKey, title)
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up page %s: %w", title, err)
+	}
+	if page == nil || page.Version == nil {
+		return fallback, nil
+	}
+	return page.Version.Number + 1, nil
+}
+
+// validationError returns an error listing the ADF schema violations of
+// the results, or nil if there are none.
+func validationError(results []ConversionResult) error {
+	var lines []string
+	for _, result := range results {
+		for _, violation := range result.Violations {
+			lines = append(lines, fmt.Sprintf("%s: %s", result.FilePath, violation))
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d ADF schema violations:\n%s", len(lines), strings.Join(lines, "\n"))
+}
+
+// uploadImages attaches the local images and rendered formulas of a
+// converted file to its page, skipping those already attached, and reports
+// whether any was uploaded.
+func uploadImages(confluenceClient ConfluenceClient, pageID string, result ConversionResult, resolver *linkResolver) (bool, error) {
+	uploaded := false
+	for _, img := range result.ImagePaths {
+		absPath, ok := resolver.imageFile(result.FilePath, img)
+		if !ok {
+			continue
+		}
+		if _, ok := result.source.attachments[absPath]; ok {
+			continue
+		}
+
+		attachment, err := confluenceClient.UploadAttachment(pageID, absPath)
+		if err != nil {
+			return uploaded, fmt.Errorf("failed to upload attachment %s: %w", absPath, err)
+		}
+		result.source.attachments[absPath] = attachment
+		uploaded = true
+	}
+	return uploaded, nil
+}
+
+// updateForwardLinks converts again the pages that link to files published
+// after them, now that every page has an ID, and updates them.
+func updateForwardLinks(results []ConversionResult, fileMapping map[string]string, confluenceClient ConfluenceClient, resolver *linkResolver, options *ConvertDirectoryOptions, spaceKey string) error {
+	for _, result := range results {
+		if !result.pendingLinks {
+			continue
+		}
+
+		updated, err := convertFile(result.source, fileMapping, resolver, options)
+		if err != nil {
+			return err
+		}
+
+		version, err := nextVersion(confluenceClient, spaceKey, updated.Title, 2)
+		if err != nil {
+			return err
+		}
+
+		if err := confluenceClient.UpdatePage(updated.PageID, updated.Title, updated.ConvertedContent, spaceKey, version); err != nil {
+			return fmt.Errorf("failed to update links of page %s: %w", updated.PageID, err)
+		}
+	}
 	return nil
 }
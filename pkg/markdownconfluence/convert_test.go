@@ -1,12 +1,17 @@
 package markdownconfluence
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"go-markdown-confluence/internal/confluence"
+	"go-markdown-confluence/internal/mathtex"
 )
 
 func TestConvert(t *testing.T) {
@@ -38,11 +43,7 @@ func TestConvert(t *testing.T) {
       "content": [
         {
           "type": "text",
-          "text": "This is a"
-        },
-        {
-          "type": "text",
-          "text": " test."
+          "text": "This is a test."
         }
       ]
     }
@@ -83,32 +84,228 @@ func TestConvertToADFWithNewFeatures(t *testing.T) {
 		{
 			name:     "Emoji",
 			markdown: ":smile:",
-			expected: `{"type":"doc","content":[{"type":"emoji","attrs":{"shortName":":smile:"}}]}`,
+			expected: `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"emoji","attrs":{"shortName":":smile:","id":"1f604","text":"😄"}}]}]}`,
 		},
 		{
 			name:     "Placeholder",
 			markdown: "<div>placeholder</div>",
-			expected: `{"type":"doc","content":[{"type":"placeholder","attrs":{"text":"Add your content here"}}]}`,
+			expected: `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"placeholder","attrs":{"text":"Add your content here"}}]}]}`,
 		},
 		{
 			name:     "Task List",
 			markdown: "- [ ] Task 1\n- [x] Task 2",
-			expected: `{"type":"doc","content":[{"type":"taskList","content":[]}]}`,
+			expected: `{"type":"doc","content":[{"type":"taskList","attrs":{"localId":"1"},"content":[
+				{"type":"taskItem","attrs":{"localId":"2","state":"TODO"},"content":[{"type":"text","text":"Task 1"}]},
+				{"type":"taskItem","attrs":{"localId":"3","state":"DONE"},"content":[{"type":"text","text":"Task 2"}]}
+			]}]}`,
+		},
+		{
+			name:     "Nested Task List",
+			markdown: "- [ ] Parent with **bold**\n  - [x] Child",
+			expected: `{"type":"doc","content":[{"type":"taskList","attrs":{"localId":"1"},"content":[
+				{"type":"taskItem","attrs":{"localId":"2","state":"TODO"},"content":[{"type":"text","text":"Parent with "},{"type":"text","text":"bold","marks":[{"type":"strong"}]}]},
+				{"type":"taskList","attrs":{"localId":"3"},"content":[
+					{"type":"taskItem","attrs":{"localId":"4","state":"DONE"},"content":[{"type":"text","text":"Child"}]}
+				]}
+			]}]}`,
+		},
+		{
+			name:     "Short List Items",
+			markdown: "- [\n- x",
+			expected: `{"type":"doc","content":[{"type":"bulletList","content":[
+				{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"["}]}]},
+				{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"x"}]}]}
+			]}]}`,
 		},
 		{
 			name:     "Decision Item",
 			markdown: "> Decision: Approve the proposal",
-			expected: `{"type":"doc","content":[{"type":"decisionItem","attrs":{"state":"DECIDED"}}]}`,
+			expected: `{"type":"doc","content":[{"type":"decisionList","attrs":{"localId":"1"},"content":[
+				{"type":"decisionItem","attrs":{"localId":"2","state":"DECIDED"},"content":[{"type":"text","text":"Approve the proposal"}]}
+			]}]}`,
+		},
+		{
+			name:     "Decision Quote With Several Decisions",
+			markdown: "> Decision: Use Go\n>\n> Undecided: Pick a database",
+			expected: `{"type":"doc","content":[{"type":"decisionList","attrs":{"localId":"1"},"content":[
+				{"type":"decisionItem","attrs":{"localId":"2","state":"DECIDED"},"content":[{"type":"text","text":"Use Go"}]},
+				{"type":"decisionItem","attrs":{"localId":"3","state":"UNDECIDED"},"content":[{"type":"text","text":"Pick a database"}]}
+			]}]}`,
+		},
+		{
+			name:     "Quote Mixing Decisions And Text",
+			markdown: "> Decision: Use Go\n>\n> Everyone agreed.",
+			expected: `{"type":"doc","content":[{"type":"blockquote","content":[
+				{"type":"paragraph","content":[{"type":"text","text":"Decision: Use Go"}]},
+				{"type":"paragraph","content":[{"type":"text","text":"Everyone agreed."}]}
+			]}]}`,
+		},
+		{
+			name:     "Quote With Decision And List",
+			markdown: "> Decision: Use Go\n>\n> - fast\n> - simple",
+			expected: `{"type":"doc","content":[{"type":"blockquote","content":[
+				{"type":"paragraph","content":[{"type":"text","text":"Decision: Use Go"}]},
+				{"type":"bulletList","content":[
+					{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"fast"}]}]},
+					{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"simple"}]}]}
+				]}
+			]}]}`,
+		},
+		{
+			name:     "Decision List",
+			markdown: "- Decision: Use Go\n- Undecided: Pick a *database*",
+			expected: `{"type":"doc","content":[{"type":"decisionList","attrs":{"localId":"1"},"content":[
+				{"type":"decisionItem","attrs":{"localId":"2","state":"DECIDED"},"content":[{"type":"text","text":"Use Go"}]},
+				{"type":"decisionItem","attrs":{"localId":"3","state":"UNDECIDED"},"content":[{"type":"text","text":"Pick a "},{"type":"text","text":"database","marks":[{"type":"em"}]}]}
+			]}]}`,
 		},
 		{
 			name:     "Callout",
 			markdown: "> [!note] important info",
-			expected: `{"type":"doc","content":[{"type":"panel","attrs":{"panelType":"info"},"content":[{"type":"paragraph","content":[{"type":"text","text":"important info"}]}]}]}`,
+			expected: `{"type":"doc","content":[{"type":"panel","attrs":{"panelType":"note"},"content":[{"type":"paragraph","content":[{"type":"text","text":"important info","marks":[{"type":"strong"}]}]}]}]}`,
+		},
+		{
+			name:     "Callout With Nested Content",
+			markdown: "> [!warning]\n> Read this:\n> - [docs](https://example.com)",
+			expected: `{"type":"doc","content":[{"type":"panel","attrs":{"panelType":"warning"},"content":[
+				{"type":"paragraph","content":[{"type":"text","text":"Read this:"}]},
+				{"type":"bulletList","content":[{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"docs","marks":[{"type":"link","attrs":{"href":"https://example.com"}}]}]}]}]}
+			]}]}`,
+		},
+		{
+			name:     "Custom Callout",
+			markdown: "> [!bug]\n> Crashes on start",
+			expected: `{"type":"doc","content":[{"type":"panel","attrs":{"panelType":"custom","panelIcon":":beetle:","panelIconText":"🐞","panelColor":"#FFEBE6"},"content":[
+				{"type":"paragraph","content":[{"type":"text","text":"Crashes on start"}]}
+			]}]}`,
+		},
+		{
+			name:     "Foldable Callout",
+			markdown: "> [!faq]- Why *Go*?\n> Because.",
+			expected: `{"type":"doc","content":[{"type":"expand","attrs":{"title":"Why Go?"},"content":[
+				{"type":"paragraph","content":[{"type":"text","text":"Because."}]}
+			]}]}`,
+		},
+		{
+			name:     "Empty Callouts",
+			markdown: "> [!tip]- Folded\n\n> [!note]",
+			expected: `{"type":"doc","content":[
+				{"type":"expand","attrs":{"title":"Folded"},"content":[{"type":"paragraph","content":[]}]},
+				{"type":"panel","attrs":{"panelType":"note"},"content":[{"type":"paragraph","content":[]}]}
+			]}`,
 		},
 		{
 			name:     "WikiLink",
 			markdown: "[[Page Title]]",
-			expected: `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"link","attrs":{"href":"Page%20Title"},"content":[]},{"type":"text","text":"Page Title"}]}]}`,
+			expected: `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"Page Title","marks":[{"type":"link","attrs":{"href":"Page%20Title"}}]}]}]}`,
+		},
+		{
+			name:     "WikiLink to block in page",
+			markdown: "Key point ^key-1\n\nSee [[#^key-1]].",
+			expected: `{"type":"doc","content":[
+				{"type":"paragraph","content":[
+					{"type":"inlineExtension","attrs":{"extensionType":"com.atlassian.confluence.macro.core","extensionKey":"anchor","parameters":{"macroParams":{"":{"value":"key-1"}}}}},
+					{"type":"text","text":"Key point"}
+				]},
+				{"type":"paragraph","content":[
+					{"type":"text","text":"See "},
+					{"type":"text","text":"key-1","marks":[{"type":"link","attrs":{"href":"#key-1"}}]},
+					{"type":"text","text":"."}
+				]}
+			]}`,
+		},
+		{
+			name:     "Link",
+			markdown: "[Example](https://example.com \"Example Website\")",
+			expected: `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"Example","marks":[{"type":"link","attrs":{"href":"https://example.com","title":"Example Website"}}]}]}]}`,
+		},
+		{
+			name:     "Autolink",
+			markdown: "<team@example.com>",
+			expected: `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"team@example.com","marks":[{"type":"link","attrs":{"href":"mailto:team@example.com"}}]}]}]}`,
+		},
+		{
+			name:     "Footnote",
+			markdown: "Text[^1].\n\n[^1]: Note.",
+			expected: `{"type":"doc","content":[
+				{"type":"paragraph","content":[
+					{"type":"text","text":"Text"},
+					{"type":"inlineExtension","attrs":{"extensionType":"com.atlassian.confluence.macro.core","extensionKey":"anchor","parameters":{"macroParams":{"":{"value":"fnref-1"}}}}},
+					{"type":"text","text":"[1]","marks":[{"type":"link","attrs":{"href":"#fn-1"}},{"type":"subsup","attrs":{"type":"sup"}}]},
+					{"type":"text","text":"."}
+				]},
+				{"type":"heading","attrs":{"level":2},"content":[{"type":"text","text":"Footnotes"}]},
+				{"type":"orderedList","content":[{"type":"listItem","content":[{"type":"paragraph","content":[
+					{"type":"inlineExtension","attrs":{"extensionType":"com.atlassian.confluence.macro.core","extensionKey":"anchor","parameters":{"macroParams":{"":{"value":"fn-1"}}}}},
+					{"type":"text","text":"Note. "},
+					{"type":"text","text":"↩","marks":[{"type":"link","attrs":{"href":"#fnref-1"}}]}
+				]}]}]}
+			]}`,
+		},
+		{
+			name:     "Footnote Inside Formatting",
+			markdown: "<sup>see[^1]</sup> <kbd>Ctrl[^1]</kbd>\n\n[^1]: Note.",
+			expected: `{"type":"doc","content":[
+				{"type":"paragraph","content":[
+					{"type":"text","text":"see","marks":[{"type":"subsup","attrs":{"type":"sup"}}]},
+					{"type":"inlineExtension","attrs":{"extensionType":"com.atlassian.confluence.macro.core","extensionKey":"anchor","parameters":{"macroParams":{"":{"value":"fnref-1"}}}}},
+					{"type":"text","text":"[1]","marks":[{"type":"subsup","attrs":{"type":"sup"}},{"type":"link","attrs":{"href":"#fn-1"}}]},
+					{"type":"text","text":" "},
+					{"type":"text","text":"Ctrl","marks":[{"type":"code"}]},
+					{"type":"inlineExtension","attrs":{"extensionType":"com.atlassian.confluence.macro.core","extensionKey":"anchor","parameters":{"macroParams":{"":{"value":"fnref-1-1"}}}}},
+					{"type":"text","text":"[1]","marks":[{"type":"link","attrs":{"href":"#fn-1"}},{"type":"subsup","attrs":{"type":"sup"}}]}
+				]},
+				{"type":"heading","attrs":{"level":2},"content":[{"type":"text","text":"Footnotes"}]},
+				{"type":"orderedList","content":[{"type":"listItem","content":[{"type":"paragraph","content":[
+					{"type":"inlineExtension","attrs":{"extensionType":"com.atlassian.confluence.macro.core","extensionKey":"anchor","parameters":{"macroParams":{"":{"value":"fn-1"}}}}},
+					{"type":"text","text":"Note. "},
+					{"type":"text","text":"↩","marks":[{"type":"link","attrs":{"href":"#fnref-1"}}]},
+					{"type":"text","text":" "},
+					{"type":"text","text":"↩","marks":[{"type":"link","attrs":{"href":"#fnref-1-1"}}]}
+				]}]}]}
+			]}`,
+		},
+		{
+			name:     "Details",
+			markdown: "<details>\n<summary>Click to expand!</summary>\n\n### Hidden\n\n- item\n\n</details>",
+			expected: `{"type":"doc","content":[{"type":"expand","attrs":{"title":"Click to expand!"},"content":[
+				{"type":"heading","attrs":{"level":3},"content":[{"type":"text","text":"Hidden"}]},
+				{"type":"bulletList","content":[{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"item"}]}]}]}
+			]}]}`,
+		},
+		{
+			name:     "Nested Details",
+			markdown: "> [!faq]- Why?\n> <details><summary>More</summary>\n> Body\n> </details>",
+			expected: `{"type":"doc","content":[{"type":"expand","attrs":{"title":"Why?"},"content":[
+				{"type":"nestedExpand","attrs":{"title":"More"},"content":[{"type":"paragraph","content":[{"type":"text","text":"Body"}]}]}
+			]}]}`,
+		},
+		{
+			name:     "Empty Details",
+			markdown: "<details><summary>s</summary></details>\n\n<details><summary>t</summary>\n\n</details>\n\n> [!faq]- Why?\n> <details><summary>In</summary></details>",
+			expected: `{"type":"doc","content":[
+				{"type":"expand","attrs":{"title":"s"},"content":[{"type":"paragraph","content":[]}]},
+				{"type":"expand","attrs":{"title":"t"},"content":[{"type":"paragraph","content":[]}]},
+				{"type":"expand","attrs":{"title":"Why?"},"content":[
+					{"type":"nestedExpand","attrs":{"title":"In"},"content":[{"type":"paragraph","content":[]}]}
+				]}
+			]}`,
+		},
+		{
+			name:     "Details Inside Callout",
+			markdown: "> [!info]\n> Read this.\n> <details><summary>More</summary>\n> Body\n> </details>",
+			expected: `{"type":"doc","content":[
+				{"type":"panel","attrs":{"panelType":"info"},"content":[{"type":"paragraph","content":[{"type":"text","text":"Read this."}]}]},
+				{"type":"expand","attrs":{"title":"More"},"content":[{"type":"paragraph","content":[{"type":"text","text":"Body"}]}]}
+			]}`,
+		},
+		{
+			name:     "Empty Code Block",
+			markdown: "```\n```\n\n```go\n```",
+			expected: `{"type":"doc","content":[
+				{"type":"codeBlock","attrs":{},"content":[]},
+				{"type":"codeBlock","attrs":{"language":"go"},"content":[]}
+			]}`,
 		},
 		{
 			name:     "Raw ADF",
@@ -118,7 +315,7 @@ func TestConvertToADFWithNewFeatures(t *testing.T) {
 		{
 			name:     "Mermaid",
 			markdown: "```mermaid\nflowchart TD; A-->B\n```",
-			expected: `{"type":"doc","content":[{"type":"image","attrs":{"src":""}}]}`,
+			expected: `{"type":"doc","content":[{"type":"mediaSingle","attrs":{"layout":"center"},"content":[{"type":"media","attrs":{"type":"external","url":""}}]}]}`,
 		},
 	}
 
@@ -130,7 +327,7 @@ func TestConvertToADFWithNewFeatures(t *testing.T) {
 			}
 
 			if c.name == "Mermaid" {
-				assert.Contains(t, result, "\"image\"")
+				assert.Contains(t, result, "\"mediaSingle\"")
 				return
 			}
 
@@ -139,6 +336,905 @@ func TestConvertToADFWithNewFeatures(t *testing.T) {
 	}
 }
 
+func TestConvertInlineMarks(t *testing.T) {
+	cases := []struct {
+		name     string
+		markdown string
+		expected string
+	}{
+		{
+			name:     "Strong and emphasis",
+			markdown: "plain **bold** and *italic* text",
+			expected: `[{"type":"text","text":"plain "},{"type":"text","text":"bold","marks":[{"type":"strong"}]},{"type":"text","text":" and "},{"type":"text","text":"italic","marks":[{"type":"em"}]},{"type":"text","text":" text"}]`,
+		},
+		{
+			name:     "Bold italic",
+			markdown: "***both***",
+			expected: `[{"type":"text","text":"both","marks":[{"type":"em"},{"type":"strong"}]}]`,
+		},
+		{
+			name:     "Strike inside emphasis",
+			markdown: "*a ~~b~~*",
+			expected: `[{"type":"text","text":"a ","marks":[{"type":"em"}]},{"type":"text","text":"b","marks":[{"type":"em"},{"type":"strike"}]}]`,
+		},
+		{
+			name:     "Formatting inside a link",
+			markdown: "[**bold** `code`](https://example.com)",
+			expected: `[{"type":"text","text":"bold","marks":[{"type":"link","attrs":{"href":"https://example.com"}},{"type":"strong"}]},{"type":"text","text":" ","marks":[{"type":"link","attrs":{"href":"https://example.com"}}]},{"type":"text","text":"code","marks":[{"type":"link","attrs":{"href":"https://example.com"}},{"type":"code"}]}]`,
+		},
+		{
+			name:     "Code span drops formatting marks",
+			markdown: "**`x`**",
+			expected: `[{"type":"text","text":"x","marks":[{"type":"code"}]}]`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			result, err := Convert(c.markdown)
+			assert.NoError(t, err)
+			assert.JSONEq(t, `{"type":"doc","content":[{"type":"paragraph","content":`+c.expected+`}]}`, result)
+		})
+	}
+}
+
+func TestConvertInlineHTML(t *testing.T) {
+	t.Run("Supported subset", func(t *testing.T) {
+		result, err := Convert("H<sub>2</sub>O, <kbd>Ctrl</kbd>, <u>u</u>, <del>d</del>, <mark>m</mark><br>next")
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"type":"doc","content":[{"type":"paragraph","content":[
+			{"type":"text","text":"H"},
+			{"type":"text","text":"2","marks":[{"type":"subsup","attrs":{"type":"sub"}}]},
+			{"type":"text","text":"O, "},
+			{"type":"text","text":"Ctrl","marks":[{"type":"code"}]},
+			{"type":"text","text":", "},
+			{"type":"text","text":"u","marks":[{"type":"underline"}]},
+			{"type":"text","text":", "},
+			{"type":"text","text":"d","marks":[{"type":"strike"}]},
+			{"type":"text","text":", "},
+			{"type":"text","text":"m","marks":[{"type":"backgroundColor","attrs":{"color":"#fedec8"}}]},
+			{"type":"hardBreak"},
+			{"type":"text","text":"next"}
+		]}]}`, result)
+	})
+
+	t.Run("Unsupported tags are dropped by default", func(t *testing.T) {
+		result, err := Convert(`<span class="x">text</span>`)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"text"}]}]}`, result)
+	})
+
+	t.Run("Unsupported tags kept as text", func(t *testing.T) {
+		options := DefaultRenderOptions()
+		options.UnsupportedHTML = HTMLKeep
+		result, err := ConvertWithOptions(`<span class="x">text</span>`, options)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"<span class=\"x\">text</span>"}]}]}`, result)
+	})
+
+	t.Run("Unsupported tags fail", func(t *testing.T) {
+		options := DefaultRenderOptions()
+		options.UnsupportedHTML = HTMLFail
+		_, err := ConvertWithOptions("first\n\nsecond <span>text</span>", options)
+		assert.ErrorContains(t, err, "unsupported HTML tag at line 3: <span>")
+	})
+
+	t.Run("Mark with a background color", func(t *testing.T) {
+		result, err := Convert(`<mark style="background-color: #D3F1A7">green</mark> <mark style="color: red">default</mark>`)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"type":"doc","content":[{"type":"paragraph","content":[
+			{"type":"text","text":"green","marks":[{"type":"backgroundColor","attrs":{"color":"#d3f1a7"}}]},
+			{"type":"text","text":" "},
+			{"type":"text","text":"default","marks":[{"type":"backgroundColor","attrs":{"color":"#fedec8"}}]}
+		]}]}`, result)
+	})
+
+	t.Run("Blocks with blank lines kept as text", func(t *testing.T) {
+		options := DefaultRenderOptions()
+		options.UnsupportedHTML = HTMLKeep
+		result, err := ConvertWithOptions("<pre>\none\n\ntwo\n</pre>", options)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"type":"doc","content":[{"type":"paragraph","content":[
+			{"type":"text","text":"<pre>"},{"type":"hardBreak"},
+			{"type":"text","text":"one"},{"type":"hardBreak"},
+			{"type":"hardBreak"},
+			{"type":"text","text":"two"},{"type":"hardBreak"},
+			{"type":"text","text":"</pre>"}
+		]}]}`, result)
+	})
+}
+
+func TestConvertHeadingAnchors(t *testing.T) {
+	markdown := "# Math Expressions\n\n## Setup {#install}\n\n## Math Expressions\n\n" +
+		"[a](#math-expressions) [b](#math-expressions-1) [c](#install) [d](#missing)"
+	expected := `{"type":"doc","content":[
+		{"type":"heading","attrs":{"level":1},"content":[{"type":"text","text":"Math Expressions"}]},
+		{"type":"heading","attrs":{"level":2},"content":[{"type":"text","text":"Setup"}]},
+		{"type":"heading","attrs":{"level":2},"content":[{"type":"text","text":"Math Expressions"}]},
+		{"type":"paragraph","content":[
+			{"type":"text","text":"a","marks":[{"type":"link","attrs":{"href":"#Math-Expressions"}}]},
+			{"type":"text","text":" "},
+			{"type":"text","text":"b","marks":[{"type":"link","attrs":{"href":"#Math-Expressions.1"}}]},
+			{"type":"text","text":" "},
+			{"type":"text","text":"c","marks":[{"type":"link","attrs":{"href":"#Setup"}}]},
+			{"type":"text","text":" "},
+			{"type":"text","text":"d","marks":[{"type":"link","attrs":{"href":"#missing"}}]}
+		]}
+	]}`
+
+	result, err := Convert(markdown)
+	assert.NoError(t, err)
+	assert.JSONEq(t, expected, result)
+}
+
+func TestConvertEmoji(t *testing.T) {
+	t.Run("Shortcodes in text", func(t *testing.T) {
+		result, err := Convert("Done :white_check_mark: at 10:30:45, see :note: and `:smile:`")
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"type":"doc","content":[{"type":"paragraph","content":[
+			{"type":"text","text":"Done "},
+			{"type":"emoji","attrs":{"shortName":":white_check_mark:","id":"2705","text":"✅"}},
+			{"type":"text","text":" at 10:30:45, see :note: and "},
+			{"type":"text","text":":smile:","marks":[{"type":"code"}]}
+		]}]}`, result)
+	})
+
+	t.Run("Custom emoji", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "emoji.json")
+		err := os.WriteFile(path, []byte(`{"partyparrot":{"id":"a1b2c3"},"smile":{"id":"d4e5f6","text":"(smile)"}}`), 0644)
+		assert.NoError(t, err)
+
+		options := DefaultRenderOptions()
+		options.Emoji, err = LoadEmojiFile(path)
+		assert.NoError(t, err)
+
+		result, err := ConvertWithOptions(":partyparrot: :smile:", options)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"type":"doc","content":[{"type":"paragraph","content":[
+			{"type":"emoji","attrs":{"shortName":":partyparrot:","id":"a1b2c3","text":":partyparrot:"}},
+			{"type":"text","text":" "},
+			{"type":"emoji","attrs":{"shortName":":smile:","id":"d4e5f6","text":"(smile)"}}
+		]}]}`, result)
+	})
+}
+
+func TestConvertLineBreaks(t *testing.T) {
+	cases := []struct {
+		name     string
+		markdown string
+		expected string
+	}{
+		{
+			name:     "Soft break",
+			markdown: "first\nsecond",
+			expected: `[{"type":"text","text":"first second"}]`,
+		},
+		{
+			name:     "Hard breaks",
+			markdown: "10 Main St  \nSpringfield\\\nUSA",
+			expected: `[
+				{"type":"text","text":"10 Main St"},
+				{"type":"hardBreak"},
+				{"type":"text","text":"Springfield"},
+				{"type":"hardBreak"},
+				{"type":"text","text":"USA"}
+			]`,
+		},
+		{
+			name:     "Escapes and entities",
+			markdown: "\\*not em\\* &amp; &copy; &#35; &#x41; \\&amp; &bogus;",
+			expected: `[{"type":"text","text":"*not em* & © # A &amp; &bogus;"}]`,
+		},
+		{
+			name:     "Code spans are literal",
+			markdown: "`a\\*&amp;\nb`",
+			expected: `[{"type":"text","text":"a\\*&amp; b","marks":[{"type":"code"}]}]`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			result, err := Convert(c.markdown)
+			assert.NoError(t, err)
+			assert.JSONEq(t, `{"type":"doc","content":[{"type":"paragraph","content":`+c.expected+`}]}`, result)
+		})
+	}
+
+	t.Run("Preserve newlines", func(t *testing.T) {
+		options := DefaultRenderOptions()
+		options.PreserveNewlines = true
+		result, err := ConvertWithOptions("first\nsecond", options)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"type":"doc","content":[{"type":"paragraph","content":[
+			{"type":"text","text":"first"},
+			{"type":"hardBreak"},
+			{"type":"text","text":"second"}
+		]}]}`, result)
+	})
+}
+
+func TestConvertMath(t *testing.T) {
+	markdown := "Inline $E=mc^2$ costs $5 and $10\n$$ x^2 $$"
+
+	t.Run("Extension", func(t *testing.T) {
+		result, err := Convert(markdown)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"type":"doc","content":[
+			{"type":"paragraph","content":[
+				{"type":"text","text":"Inline "},
+				{"type":"inlineExtension","attrs":{"extensionType":"com.atlassian.confluence.macro.core","extensionKey":"mathinline",
+					"parameters":{"macroParams":{"body":{"value":"E=mc^2"}}},"text":"E=mc^2"}},
+				{"type":"text","text":" costs $5 and $10"}
+			]},
+			{"type":"extension","attrs":{"extensionType":"com.atlassian.confluence.macro.core","extensionKey":"mathblock",
+				"parameters":{"macroParams":{"body":{"value":"x^2"}}},"text":"x^2"}}
+		]}`, result)
+	})
+
+	t.Run("Image without attachment", func(t *testing.T) {
+		// A rendered file that is not attached to the page is of no use on
+		// it, so the formulas fall back to code.
+		options := DefaultRenderOptions()
+		options.Math = MathImage
+		options.MathRenderer = func(latex string, display bool) (string, error) {
+			if display {
+				return "", errors.New("no renderer")
+			}
+			return "/tmp/math.svg", nil
+		}
+		result, err := ConvertWithOptions(markdown, options)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"type":"doc","content":[
+			{"type":"paragraph","content":[
+				{"type":"text","text":"Inline "},
+				{"type":"text","text":"E=mc^2","marks":[{"type":"code"}]},
+				{"type":"text","text":" costs $5 and $10"}
+			]},
+			{"type":"codeBlock","attrs":{"language":"latex"},"content":[{"type":"text","text":"x^2"}]}
+		]}`, result)
+	})
+
+	t.Run("Code fallback", func(t *testing.T) {
+		options := DefaultRenderOptions()
+		options.MathInlineKey = ""
+		result, err := ConvertWithOptions("$a \\ne 0$", options)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"type":"doc","content":[{"type":"paragraph","content":[
+			{"type":"text","text":"a \\ne 0","marks":[{"type":"code"}]}
+		]}]}`, result)
+	})
+}
+
+func TestConvertImages(t *testing.T) {
+	cases := []struct {
+		name     string
+		markdown string
+		expected string
+	}{
+		{
+			name:     "Image",
+			markdown: "![Logo](https://example.com/logo.png)",
+			expected: `{"type":"doc","content":[
+				{"type":"mediaSingle","attrs":{"layout":"center"},"content":[
+					{"type":"media","attrs":{"type":"external","url":"https://example.com/logo.png","alt":"Logo"}}
+				]}
+			]}`,
+		},
+		{
+			name:     "Attributes",
+			markdown: "![Logo](logo.png){width=400 layout=wide}\n![Icon](icon.png){height=20px}",
+			expected: `{"type":"doc","content":[
+				{"type":"mediaSingle","attrs":{"layout":"wide","width":400,"widthType":"pixel"},"content":[
+					{"type":"media","attrs":{"type":"external","url":"logo.png","alt":"Logo","width":400}}
+				]},
+				{"type":"mediaSingle","attrs":{"layout":"center"},"content":[
+					{"type":"media","attrs":{"type":"external","url":"icon.png","alt":"Icon","height":20}}
+				]}
+			]}`,
+		},
+		{
+			name:     "Embed",
+			markdown: "![[shot.png|400x300]]\n\n![[photo.jpg|A photo]]",
+			expected: `{"type":"doc","content":[
+				{"type":"mediaSingle","attrs":{"layout":"center","width":400,"widthType":"pixel"},"content":[
+					{"type":"media","attrs":{"type":"external","url":"shot.png","width":400,"height":300}}
+				]},
+				{"type":"mediaSingle","attrs":{"layout":"center"},"content":[
+					{"type":"media","attrs":{"type":"external","url":"photo.jpg","alt":"A photo"}}
+				]}
+			]}`,
+		},
+		{
+			name:     "Inline",
+			markdown: "See ![the icon](https://example.com/icon.png){width=16} here",
+			expected: `{"type":"doc","content":[{"type":"paragraph","content":[
+				{"type":"text","text":"See "},
+				{"type":"text","text":"the icon","marks":[{"type":"link","attrs":{"href":"https://example.com/icon.png"}}]},
+				{"type":"text","text":" here"}
+			]}]}`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			result, err := Convert(c.markdown)
+			assert.NoError(t, err)
+			assert.JSONEq(t, c.expected, result)
+		})
+	}
+
+	t.Run("Attachments", func(t *testing.T) {
+		options := DefaultRenderOptions()
+		options.ResolveImage = func(src string) (string, string, bool) {
+			return "file-" + src, "contentId-1", src != "https://example.com/logo.png"
+		}
+		result, err := ConvertWithOptions("![Diagram](diagram.png)\n\nSee ![icon](icon.png) and ![logo](https://example.com/logo.png)", options)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"type":"doc","content":[
+			{"type":"mediaSingle","attrs":{"layout":"center"},"content":[
+				{"type":"media","attrs":{"type":"file","id":"file-diagram.png","collection":"contentId-1","alt":"Diagram"}}
+			]},
+			{"type":"paragraph","content":[
+				{"type":"text","text":"See "},
+				{"type":"mediaInline","attrs":{"type":"file","id":"file-icon.png","collection":"contentId-1","alt":"icon"}},
+				{"type":"text","text":" and "},
+				{"type":"text","text":"logo","marks":[{"type":"link","attrs":{"href":"https://example.com/logo.png"}}]}
+			]}
+		]}`, result)
+	})
+}
+
+func TestConvertMacros(t *testing.T) {
+	cases := []struct {
+		name     string
+		markdown string
+		expected string
+	}{
+		{
+			name:     "Extension",
+			markdown: "```confluence-macro toc maxLevel=3 title=\"On this page\"\n```",
+			expected: `{"type":"doc","content":[
+				{"type":"extension","attrs":{"extensionType":"com.atlassian.confluence.macro.core","extensionKey":"toc","parameters":{"macroParams":{"maxLevel":{"value":"3"},"title":{"value":"On this page"}}}}}
+			]}`,
+		},
+		{
+			name:     "Bodied extension",
+			markdown: "```confluence-macro excerpt hidden=true\nThe **short** version.\n```",
+			expected: `{"type":"doc","content":[
+				{"type":"bodiedExtension","attrs":{"extensionType":"com.atlassian.confluence.macro.core","extensionKey":"excerpt","parameters":{"macroParams":{"hidden":{"value":"true"}}}},"content":[
+					{"type":"paragraph","content":[
+						{"type":"text","text":"The "},
+						{"type":"text","text":"short","marks":[{"type":"strong"}]},
+						{"type":"text","text":" version."}
+					]}
+				]}
+			]}`,
+		},
+		{
+			name:     "Inline extension",
+			markdown: "State: {{macro:status colour=Green title=\"In progress\"}} {{macro:anchor setup}}",
+			expected: `{"type":"doc","content":[{"type":"paragraph","content":[
+				{"type":"text","text":"State: "},
+				{"type":"inlineExtension","attrs":{"extensionType":"com.atlassian.confluence.macro.core","extensionKey":"status","parameters":{"macroParams":{"colour":{"value":"Green"},"title":{"value":"In progress"}}}}},
+				{"type":"text","text":" "},
+				{"type":"inlineExtension","attrs":{"extensionType":"com.atlassian.confluence.macro.core","extensionKey":"anchor","parameters":{"macroParams":{"":{"value":"setup"}}}}}
+			]}]}`,
+		},
+		{
+			name:     "Template braces",
+			markdown: "Set {{ .Values.name }} here",
+			expected: `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"Set {{ .Values.name }} here"}]}]}`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			result, err := Convert(c.markdown)
+			assert.NoError(t, err)
+			assert.JSONEq(t, c.expected, result)
+		})
+	}
+
+	t.Run("Missing name", func(t *testing.T) {
+		_, err := Convert("Intro\n\n```confluence-macro\n```")
+		assert.ErrorContains(t, err, "confluence-macro block at line 3 has no macro name")
+	})
+}
+
+func TestConvertRawADF(t *testing.T) {
+	cases := []struct {
+		name     string
+		markdown string
+		expected string
+	}{
+		{
+			name:     "Array",
+			markdown: "```adf\n[{\"type\":\"rule\"},{\"type\":\"paragraph\",\"content\":[{\"type\":\"text\",\"text\":\"After\"}]}]\n```",
+			expected: `{"type":"doc","content":[
+				{"type":"rule"},
+				{"type":"paragraph","content":[{"type":"text","text":"After"}]}
+			]}`,
+		},
+		{
+			name:     "Inline node in fence",
+			markdown: "```adf\n{\"type\":\"status\",\"attrs\":{\"text\":\"DONE\",\"color\":\"green\"}}\n```",
+			expected: `{"type":"doc","content":[
+				{"type":"paragraph","content":[{"type":"status","attrs":{"text":"DONE","color":"green"}}]}
+			]}`,
+		},
+		{
+			name:     "Inline code span",
+			markdown: "State `{\"type\":\"status\",\"attrs\":{\"text\":\"WIP\",\"color\":\"yellow\"}}`{=adf} today",
+			expected: `{"type":"doc","content":[{"type":"paragraph","content":[
+				{"type":"text","text":"State "},
+				{"type":"status","attrs":{"text":"WIP","color":"yellow"}},
+				{"type":"text","text":" today"}
+			]}]}`,
+		},
+		{
+			name:     "Invalid fence",
+			markdown: "```adf\n{\"type\":\"paragraph\",\"content\":[{\"type\":\"rule\"}]}\n```",
+			expected: `{"type":"doc","content":[
+				{"type":"codeBlock","attrs":{"language":"adf"},"content":[{"type":"text","text":"{\"type\":\"paragraph\",\"content\":[{\"type\":\"rule\"}]}\n"}]}
+			]}`,
+		},
+		{
+			name:     "Invalid code span",
+			markdown: "Bad `{\"type\":\"bogus\"}`{=adf} node",
+			expected: `{"type":"doc","content":[{"type":"paragraph","content":[
+				{"type":"text","text":"Bad "},
+				{"type":"text","text":"{\"type\":\"bogus\"}","marks":[{"type":"code"}]},
+				{"type":"text","text":" node"}
+			]}]}`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			result, err := Convert(c.markdown)
+			assert.NoError(t, err)
+			assert.JSONEq(t, c.expected, result)
+		})
+	}
+
+	errorCases := []struct {
+		name     string
+		markdown string
+		expected string
+	}{
+		{
+			name:     "Bad JSON",
+			markdown: "Intro\n\n```adf\n{\"type\":\n```",
+			expected: "invalid ADF at line 3: invalid JSON",
+		},
+		{
+			name:     "Missing attribute",
+			markdown: "```adf\n[{\"type\":\"rule\"},{\"type\":\"heading\",\"content\":[]}]\n```",
+			expected: "invalid ADF at line 1: $[1].attrs: heading node has no level attribute",
+		},
+		{
+			name:     "Misplaced node",
+			markdown: "```adf\n{\"type\":\"paragraph\",\"content\":[{\"type\":\"rule\"}]}\n```",
+			expected: "invalid ADF at line 1: $.content[0]: paragraph node cannot contain rule node",
+		},
+		{
+			name:     "Block node in text",
+			markdown: "Text `{\"type\":\"rule\"}`{=adf}",
+			expected: "invalid ADF at line 1: block nodes cannot be injected within text",
+		},
+	}
+
+	for _, c := range errorCases {
+		t.Run("Strict "+c.name, func(t *testing.T) {
+			var warnings []string
+			options := DefaultRenderOptions()
+			options.Warn = func(message string) { warnings = append(warnings, message) }
+
+			_, err := ConvertWithOptions(c.markdown, options)
+			assert.NoError(t, err)
+			if assert.Len(t, warnings, 1) {
+				assert.Contains(t, warnings[0], c.expected)
+			}
+
+			options.StrictADF = true
+			_, err = ConvertWithOptions(c.markdown, options)
+			assert.ErrorContains(t, err, c.expected)
+		})
+	}
+
+	t.Run("Directory", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "page.md")
+		assert.NoError(t, os.WriteFile(path, []byte("# Page\n\n```adf\n{\"type\":\"bogus\"}\n```"), 0644))
+
+		var warnings []string
+		options := DefaultConvertOptions()
+		options.Warn = func(message string) { warnings = append(warnings, message) }
+		_, err := ConvertDirectoryWithResults(dir, nil, options)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{path + `: invalid ADF at line 3: $: unknown node type "bogus"`}, warnings)
+
+		options.Render.StrictADF = true
+		_, err = ConvertDirectoryWithResults(dir, nil, options)
+		assert.ErrorContains(t, err, path)
+		assert.ErrorContains(t, err, `invalid ADF at line 3: $: unknown node type "bogus"`)
+	})
+}
+
+func TestConvertToStorage(t *testing.T) {
+	cases := []struct {
+		name     string
+		markdown string
+		expected string
+	}{
+		{
+			name:     "Text",
+			markdown: "# Title\n\nSome *em*, **strong**, `a < b` and ~~old~~ text\nwith [a link](https://example.com \"Example\") & [a heading](#title).",
+			expected: `<h1>Title</h1><p>Some <em>em</em>, <strong>strong</strong>, <code>a &lt; b</code> and <s>old</s> text` + "\n" +
+				`with <a href="https://example.com" title="Example">a link</a> &amp; <ac:link ac:anchor="Title"><ac:link-body>a heading</ac:link-body></ac:link>.</p>`,
+		},
+		{
+			name:     "Lists",
+			markdown: "- one\n- two\n\n3. three\n4. four",
+			expected: `<ul><li>one</li><li>two</li></ul><ol start="3"><li>three</li><li>four</li></ol>`,
+		},
+		{
+			name:     "Task list",
+			markdown: "- [ ] Write docs\n- [x] Ship it\n  - [ ] Announce",
+			expected: `<ac:task-list>` +
+				`<ac:task><ac:task-id>1</ac:task-id><ac:task-status>incomplete</ac:task-status><ac:task-body>Write docs</ac:task-body></ac:task>` +
+				`<ac:task><ac:task-id>2</ac:task-id><ac:task-status>complete</ac:task-status><ac:task-body>Ship it` +
+				`<ac:task-list><ac:task><ac:task-id>3</ac:task-id><ac:task-status>incomplete</ac:task-status><ac:task-body>Announce</ac:task-body></ac:task></ac:task-list>` +
+				`</ac:task-body></ac:task>` +
+				`</ac:task-list>`,
+		},
+		{
+			name:     "Code",
+			markdown: "```go\nfmt.Println(\"]]>\")\n```",
+			expected: `<ac:structured-macro ac:name="code"><ac:parameter ac:name="language">go</ac:parameter>` +
+				`<ac:plain-text-body><![CDATA[fmt.Println("]]]]><![CDATA[>")` + "\n" + `]]></ac:plain-text-body></ac:structured-macro>`,
+		},
+		{
+			name:     "Callouts",
+			markdown: "> [!WARNING] Mind the gap\n> Stand back.\n\n> [!bug]\n> Crashes.",
+			expected: `<ac:structured-macro ac:name="note"><ac:parameter ac:name="title">Mind the gap</ac:parameter><ac:rich-text-body><p>Stand back.</p></ac:rich-text-body></ac:structured-macro>` +
+				`<ac:structured-macro ac:name="panel"><ac:parameter ac:name="bgColor">#FFEBE6</ac:parameter><ac:rich-text-body><p>Crashes.</p></ac:rich-text-body></ac:structured-macro>`,
+		},
+		{
+			name:     "Expand",
+			markdown: "> [!faq]- Why?\n> Because.\n\n<details>\n<summary>More</summary>\n\nHidden *text*\n\n</details>",
+			expected: `<ac:structured-macro ac:name="expand"><ac:parameter ac:name="title">Why?</ac:parameter><ac:rich-text-body><p>Because.</p></ac:rich-text-body></ac:structured-macro>` +
+				`<ac:structured-macro ac:name="expand"><ac:parameter ac:name="title">More</ac:parameter><ac:rich-text-body><p>Hidden <em>text</em></p></ac:rich-text-body></ac:structured-macro>`,
+		},
+		{
+			name:     "Macros",
+			markdown: "```confluence-macro toc maxLevel=3\n```\n\n```confluence-macro excerpt\nThe **short** version.\n```\n\nState: {{macro:status colour=Green title=\"In progress\"}}",
+			expected: `<ac:structured-macro ac:name="toc"><ac:parameter ac:name="maxLevel">3</ac:parameter></ac:structured-macro>` +
+				`<ac:structured-macro ac:name="excerpt"><ac:rich-text-body><p>The <strong>short</strong> version.</p></ac:rich-text-body></ac:structured-macro>` +
+				`<p>State: <ac:structured-macro ac:name="status"><ac:parameter ac:name="colour">Green</ac:parameter><ac:parameter ac:name="title">In progress</ac:parameter></ac:structured-macro></p>`,
+		},
+		{
+			name:     "Images",
+			markdown: "![Logo](https://example.com/logo.png){width=200 layout=align-end}\n\nInline ![icon](https://example.com/icon.png) here",
+			expected: `<p><ac:image ac:align="right" ac:layout="align-end" ac:alt="Logo" ac:width="200"><ri:url ri:value="https://example.com/logo.png"/></ac:image></p>` +
+				`<p>Inline <ac:image ac:alt="icon"><ri:url ri:value="https://example.com/icon.png"/></ac:image> here</p>`,
+		},
+		{
+			name:     "Table",
+			markdown: "| a | b |\n|:-:|--:|\n| 1 | 2 |",
+			expected: `<table><tbody><tr><th style="text-align: center;"><p>a</p></th><th style="text-align: right;"><p>b</p></th></tr>` +
+				`<tr><td style="text-align: center;"><p>1</p></td><td style="text-align: right;"><p>2</p></td></tr></tbody></table>`,
+		},
+		{
+			name:     "Overlapping HTML",
+			markdown: "<b>bold *both</b> em* and <mark>marked",
+			expected: `<p><strong>bold <em>both</em></strong><em> em</em> and <span style="background-color: #fedec8;">marked</span></p>`,
+		},
+		{
+			name:     "Mark with a background color",
+			markdown: `<mark style="background: #ffd5d2">red</mark>`,
+			expected: `<p><span style="background-color: #ffd5d2;">red</span></p>`,
+		},
+		{
+			name:     "Footnotes and emoji",
+			markdown: "Done :smile:[^1]\n\n[^1]: Really.",
+			expected: `<p>Done 😄<ac:structured-macro ac:name="anchor"><ac:parameter ac:name="">fnref-1</ac:parameter></ac:structured-macro>` +
+				`<sup><ac:link ac:anchor="fn-1"><ac:plain-text-link-body><![CDATA[[1]]]></ac:plain-text-link-body></ac:link></sup></p>` +
+				`<h2>Footnotes</h2><ol><li><p><ac:structured-macro ac:name="anchor"><ac:parameter ac:name="">fn-1</ac:parameter></ac:structured-macro>Really. ` +
+				`<ac:link ac:anchor="fnref-1"><ac:plain-text-link-body><![CDATA[↩]]></ac:plain-text-link-body></ac:link></p></li></ol>`,
+		},
+		{
+			name:     "Math",
+			markdown: "Inline $x^2$\n\n$$\n\\int f\n$$",
+			expected: `<p>Inline <ac:structured-macro ac:name="mathinline"><ac:plain-text-body><![CDATA[x^2]]></ac:plain-text-body></ac:structured-macro></p>` +
+				`<ac:structured-macro ac:name="mathblock"><ac:plain-text-body><![CDATA[\int f]]></ac:plain-text-body></ac:structured-macro>`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			result, err := ConvertToStorage(c.markdown, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, c.expected, result)
+		})
+	}
+
+	t.Run("Page links and attachments", func(t *testing.T) {
+		options := DefaultRenderOptions()
+		options.ResolvePage = func(destination string) (string, string, bool) {
+			return "Setup guide", "Install", destination == "setup.md#install"
+		}
+		options.ResolveWikiPage = func(target, fragment string) (string, string, bool) {
+			return "Setup guide", "", target == "setup"
+		}
+		options.ResolveAttachment = func(src string) (string, bool) {
+			return "diagram.png", src == "img/diagram.png"
+		}
+
+		result, err := ConvertToStorage("[Install](setup.md#install), [[setup|the guide]] and [[Q&A]]\n\n![[img/diagram.png|400]]", options)
+		assert.NoError(t, err)
+		assert.Equal(t, `<p><ac:link ac:anchor="Install"><ri:page ri:content-title="Setup guide"/><ac:link-body>Install</ac:link-body></ac:link>, `+
+			`<ac:link><ri:page ri:content-title="Setup guide"/><ac:plain-text-link-body><![CDATA[the guide]]></ac:plain-text-link-body></ac:link> and `+
+			`<a href="Q&amp;A">Q&amp;A</a></p>`+
+			`<p><ac:image ac:align="center" ac:layout="center" ac:width="400"><ri:attachment ri:filename="diagram.png"/></ac:image></p>`, result)
+	})
+
+	t.Run("Raw ADF", func(t *testing.T) {
+		var warnings []string
+		options := DefaultRenderOptions()
+		options.Warn = func(message string) { warnings = append(warnings, message) }
+
+		result, err := ConvertToStorage("```adf\n{\"type\":\"rule\"}\n```", options)
+		assert.NoError(t, err)
+		assert.Equal(t, `<ac:structured-macro ac:name="code"><ac:parameter ac:name="language">json</ac:parameter><ac:plain-text-body><![CDATA[{"type":"rule"}`+"\n"+`]]></ac:plain-text-body></ac:structured-macro>`, result)
+		assert.Equal(t, []string{"raw ADF at line 1 cannot be published in the storage format"}, warnings)
+
+		options.StrictADF = true
+		_, err = ConvertToStorage("```adf\n{\"type\":\"rule\"}\n```", options)
+		assert.ErrorContains(t, err, "raw ADF at line 1 cannot be published in the storage format")
+	})
+}
+
+func TestValidate(t *testing.T) {
+	valid := []struct {
+		name     string
+		markdown string
+	}{
+		{"Inline marks", "Some **bold**, *em*, ~~strike~~, `code`, [link](https://example.com), <sub>sub</sub> and <kbd>Ctrl</kbd>."},
+		{"Lists and tasks", "- [ ] todo\n- [x] done\n\n1. one\n2. two\n   - inner\n\n- DECISION: chosen"},
+		{"Callouts", "> [!NOTE] Title\n> body\n\n> [!TIP]- Folded\n> hidden"},
+		{"Empty callouts", "> [!TIP]- Folded\n\n> [!NOTE]"},
+		{"Empty details", "<details><summary>s</summary></details>\n\n> [!faq]- Why?\n> <details><summary>In</summary>\n>\n> </details>"},
+		{"Table", "| a | b |\n|---|---|\n| 1 | **2** |"},
+		{"Empty code blocks", "```\n```\n\n```go\n```"},
+		{"Code, math and rules", "```go\nfmt.Println()\n```\n\n$$\nE=mc^2\n$$\n\n---\n\nInline $x^2$ math."},
+		{"Images and footnotes", "![alt](https://example.com/a.png){width=300 layout=wide}\n\nText[^1].\n\n[^1]: The note."},
+		{"Footnotes inside formatting", "<sup>see[^1]</sup>, <sub>low[^1]</sub>, <kbd>Ctrl[^1]</kbd> and [link[^1]](https://example.com)\n\n[^1]: The note."},
+		{"Macros and raw ADF", "```confluence-macro info title=\"Hi\"\nBody\n```\n\n{{macro:status title=OK}} `{\"type\":\"status\",\"attrs\":{\"text\":\"OK\",\"color\":\"green\"}}`{=adf}"},
+	}
+
+	for _, c := range valid {
+		t.Run(c.name, func(t *testing.T) {
+			violations, err := Validate(c.markdown)
+			assert.NoError(t, err)
+			assert.Empty(t, violations)
+		})
+	}
+
+	t.Run("Violations", func(t *testing.T) {
+		markdown := "# Doc\n\n- item\n\n  ```adf\n  {\"type\":\"panel\",\"attrs\":{\"panelType\":\"info\"},\"content\":[{\"type\":\"paragraph\"}]}\n  ```\n\n- table\n\n  ```adf\n  {\"type\":\"table\",\"content\":[{\"type\":\"tableRow\",\"content\":[{\"type\":\"tableCell\",\"content\":[{\"type\":\"paragraph\"}]}]}]}\n  ```"
+		violations, err := Validate(markdown)
+		assert.NoError(t, err)
+		assert.Equal(t, []Violation{
+			{Path: "$.content[1].content[0].content[1]", Message: "listItem node cannot contain panel node", Line: 5},
+			{Path: "$.content[1].content[1].content[1]", Message: "listItem node cannot contain table node", Line: 11},
+		}, violations)
+		assert.Equal(t, "line 5: $.content[1].content[0].content[1]: listItem node cannot contain panel node", violations[0].String())
+	})
+
+	t.Run("Directory", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "page.md")
+		markdown := "---\nconnie-title: Page\n---\n# Page\n\n- item\n\n  ```adf\n  {\"type\":\"rule\"}\n  ```"
+		assert.NoError(t, os.WriteFile(path, []byte(markdown), 0644))
+
+		options := DefaultConvertOptions()
+		results, err := ConvertDirectoryWithResults(dir, nil, options)
+		assert.NoError(t, err)
+		assert.Empty(t, results[0].Violations)
+
+		options.Validate = true
+		results, err = ConvertDirectoryWithResults(dir, nil, options)
+		assert.NoError(t, err)
+		assert.Equal(t, []Violation{
+			{Path: "$.content[1].content[0].content[1]", Message: "listItem node cannot contain rule node", Line: 8},
+		}, results[0].Violations)
+
+		client := &recordingClient{created: map[string]string{}, updated: map[string]string{}}
+		err = ConvertDirectoryWithOptions(dir, nil, client, options, "DOCS")
+		assert.ErrorContains(t, err, path+": line 8: $.content[1].content[0].content[1]: listItem node cannot contain rule node")
+		assert.Empty(t, client.created)
+	})
+}
+
+func TestConvertNesting(t *testing.T) {
+	cases := []struct {
+		name     string
+		markdown string
+		expected string
+		warnings []string
+	}{
+		{
+			name:     "List inside blockquote",
+			markdown: "> - one\n> - two",
+			expected: `{"type":"doc","content":[{"type":"blockquote","content":[{"type":"bulletList","content":[
+				{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"one"}]}]},
+				{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"two"}]}]}
+			]}]}]}`,
+		},
+		{
+			name:     "Nested list",
+			markdown: "1. first\n   - inner\n2. second",
+			expected: `{"type":"doc","content":[{"type":"orderedList","content":[
+				{"type":"listItem","content":[
+					{"type":"paragraph","content":[{"type":"text","text":"first"}]},
+					{"type":"bulletList","content":[{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"inner"}]}]}]}
+				]},
+				{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"second"}]}]}
+			]}]}`,
+		},
+		{
+			name:     "Paragraphs and code inside list item",
+			markdown: "- first\n\n  second\n\n  ```go\n  x := 1\n  ```",
+			expected: `{"type":"doc","content":[{"type":"bulletList","content":[{"type":"listItem","content":[
+				{"type":"paragraph","content":[{"type":"text","text":"first"}]},
+				{"type":"paragraph","content":[{"type":"text","text":"second"}]},
+				{"type":"codeBlock","attrs":{"language":"go"},"content":[{"type":"text","text":"x := 1\n"}]}
+			]}]}]}`,
+		},
+		{
+			name:     "Nested blockquote",
+			markdown: "> outer\n>> inner",
+			expected: `{"type":"doc","content":[{"type":"blockquote","content":[
+				{"type":"paragraph","content":[{"type":"text","text":"outer"}]},
+				{"type":"paragraph","content":[{"type":"text","text":"inner"}]}
+			]}]}`,
+		},
+		{
+			name:     "Heading inside blockquote",
+			markdown: "> # Title\n> text",
+			expected: `{"type":"doc","content":[{"type":"blockquote","content":[
+				{"type":"paragraph","content":[{"type":"text","text":"Title","marks":[{"type":"strong"}]}]},
+				{"type":"paragraph","content":[{"type":"text","text":"text"}]}
+			]}]}`,
+		},
+		{
+			name:     "Heading inside list item",
+			markdown: "- item\n\n  ## Sub **bold**",
+			expected: `{"type":"doc","content":[{"type":"bulletList","content":[{"type":"listItem","content":[
+				{"type":"paragraph","content":[{"type":"text","text":"item"}]},
+				{"type":"paragraph","content":[{"type":"text","text":"Sub bold","marks":[{"type":"strong"}]}]}
+			]}]}]}`,
+		},
+		{
+			name:     "Table inside list item",
+			markdown: "- item\n\n  | a |\n  |---|\n  | 1 |\n- next",
+			expected: `{"type":"doc","content":[
+				{"type":"bulletList","content":[
+					{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"item"}]}]},
+					{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"next"}]}]}
+				]},
+				{"type":"table","attrs":{"isNumberColumnEnabled":false,"layout":"default"},"content":[
+					{"type":"tableRow","content":[{"type":"tableHeader","content":[{"type":"paragraph","content":[{"type":"text","text":"a"}]}]}]},
+					{"type":"tableRow","content":[{"type":"tableCell","content":[{"type":"paragraph","content":[{"type":"text","text":"1"}]}]}]}
+				]}
+			]}`,
+			warnings: []string{"table at line 3 cannot be inside a listItem and is published after the bulletList"},
+		},
+		{
+			name:     "Table inside blockquote",
+			markdown: "> | a |\n> |---|\n> | 1 |",
+			expected: `{"type":"doc","content":[
+				{"type":"table","attrs":{"isNumberColumnEnabled":false,"layout":"default"},"content":[
+					{"type":"tableRow","content":[{"type":"tableHeader","content":[{"type":"paragraph","content":[{"type":"text","text":"a"}]}]}]},
+					{"type":"tableRow","content":[{"type":"tableCell","content":[{"type":"paragraph","content":[{"type":"text","text":"1"}]}]}]}
+				]}
+			]}`,
+			warnings: []string{"table at line 1 cannot be inside a blockquote and is published after the blockquote"},
+		},
+		{
+			name:     "Blockquote inside list item",
+			markdown: "- item\n\n  > quoted",
+			expected: `{"type":"doc","content":[{"type":"bulletList","content":[{"type":"listItem","content":[
+				{"type":"paragraph","content":[{"type":"text","text":"item"}]},
+				{"type":"paragraph","content":[{"type":"text","text":"quoted"}]}
+			]}]}]}`,
+		},
+		{
+			name:     "Blocks inside task item",
+			markdown: "- [ ] task\n\n  more\n\n  ```go\n  x := 1\n  ```\n\n  > quoted\n- [x] done",
+			expected: `{"type":"doc","content":[
+				{"type":"taskList","attrs":{"localId":"1"},"content":[
+					{"type":"taskItem","attrs":{"localId":"2","state":"TODO"},"content":[
+						{"type":"text","text":"task"},{"type":"hardBreak"},{"type":"text","text":"more"},{"type":"hardBreak"},{"type":"text","text":"quoted"}
+					]},
+					{"type":"taskItem","attrs":{"localId":"3","state":"DONE"},"content":[{"type":"text","text":"done"}]}
+				]},
+				{"type":"codeBlock","attrs":{"language":"go"},"content":[{"type":"text","text":"x := 1\n"}]}
+			]}`,
+			warnings: []string{"codeBlock at line 5 cannot be inside a taskItem and is published after the taskList"},
+		},
+		{
+			name:     "Details inside list item",
+			markdown: "- item\n\n  <details>\n  <summary>More</summary>\n\n  hidden\n\n  </details>\n- next",
+			expected: `{"type":"doc","content":[
+				{"type":"bulletList","content":[
+					{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"item"}]}]},
+					{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"next"}]}]}
+				]},
+				{"type":"expand","attrs":{"title":"More"},"content":[{"type":"paragraph","content":[{"type":"text","text":"hidden"}]}]}
+			]}`,
+			warnings: []string{"expand at line 3 cannot be inside a listItem and is published after the bulletList"},
+		},
+		{
+			name:     "Empty list item",
+			markdown: "- item\n-\n",
+			expected: `{"type":"doc","content":[{"type":"bulletList","content":[
+				{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"item"}]}]},
+				{"type":"listItem","content":[{"type":"paragraph","content":[]}]}
+			]}]}`,
+		},
+		{
+			name:     "Empty blockquote",
+			markdown: "before\n\n>\n\nafter",
+			expected: `{"type":"doc","content":[
+				{"type":"paragraph","content":[{"type":"text","text":"before"}]},
+				{"type":"paragraph","content":[{"type":"text","text":"after"}]}
+			]}`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var warnings []string
+			options := DefaultRenderOptions()
+			options.Warn = func(message string) { warnings = append(warnings, message) }
+
+			result, err := ConvertWithOptions(c.markdown, options)
+			assert.NoError(t, err)
+			assert.JSONEq(t, c.expected, result)
+			assert.Equal(t, c.warnings, warnings)
+
+			// Whatever the Markdown nests, the ADF keeps to the schema.
+			violations, err := Validate(c.markdown)
+			assert.NoError(t, err)
+			assert.Empty(t, violations)
+		})
+	}
+}
+
+func TestConvertTable(t *testing.T) {
+	markdown := "| Name | Price |\n|:-----|------:|\n| **Item** | $10 |"
+	expected := `{"type":"doc","content":[{"type":"table","attrs":{"isNumberColumnEnabled":true,"layout":"wide"},"content":[
+		{"type":"tableRow","content":[
+			{"type":"tableHeader","content":[{"type":"paragraph","content":[{"type":"text","text":"Name"}]}]},
+			{"type":"tableHeader","content":[{"type":"paragraph","content":[{"type":"text","text":"Price"}],"marks":[{"type":"alignment","attrs":{"align":"end"}}]}]}
+		]},
+		{"type":"tableRow","content":[
+			{"type":"tableCell","content":[{"type":"paragraph","content":[{"type":"text","text":"Item","marks":[{"type":"strong"}]}]}]},
+			{"type":"tableCell","content":[{"type":"paragraph","content":[{"type":"text","text":"$10"}],"marks":[{"type":"alignment","attrs":{"align":"end"}}]}]}
+		]}
+	]}]}`
+
+	options := DefaultRenderOptions()
+	options.TableLayout = "wide"
+	options.TableNumberColumn = true
+
+	result, err := ConvertWithOptions(markdown, options)
+	assert.NoError(t, err)
+	assert.JSONEq(t, expected, result)
+}
+
 func TestExtractImagePaths(t *testing.T) {
 	md := "![](image.png)\n![alt](pics/photo.jpg)"
 	paths := extractImagePaths(md)
@@ -156,3 +1252,274 @@ func TestConvertDirectoryWithResults_ImagePaths(t *testing.T) {
 		assert.Equal(t, []string{"img.png"}, results[0].ImagePaths)
 	}
 }
+
+// recordingClient is a ConfluenceClient that records the pages it is asked
+// to create and update.
+type recordingClient struct {
+	created  map[string]string // Content of created pages by title
+	updated  map[string]string // Content of updated pages by page ID
+	uploaded []string          // Attached files as "<page ID>/<file name>"
+}
+
+func (c *recordingClient) CreateParentPage(spaceKey, title, parentID string) (string, error) {
+	return "parent-" + title, nil
+}
+
+func (c *recordingClient) CreatePage(spaceKey, title, content, parentID string) (string, error) {
+	c.created[title] = content
+	return "id-" + title, nil
+}
+
+func (c *recordingClient) UpdatePage(pageID, title, content, spaceKey string, version int) error {
+	c.updated[pageID] = content
+	return nil
+}
+
+func (c *recordingClient) GetPageByTitle(spaceKey, title string) (*confluence.Page, error) {
+	return nil, nil
+}
+
+func (c *recordingClient) UploadAttachment(pageID, filePath string) (*confluence.Attachment, error) {
+	c.uploaded = append(c.uploaded, pageID+"/"+filepath.Base(filePath))
+	return &confluence.Attachment{
+		ID:         "att-" + filepath.Base(filePath),
+		Title:      filepath.Base(filePath),
+		FileID:     "file-" + filepath.Base(filePath),
+		Collection: "contentId-" + pageID,
+	}, nil
+}
+
+func TestConvertDirectoryWithResults_WikiLinks(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "guides"), 0755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "assets"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "index.md"), []byte(
+		"[[Setup#Install Steps|install]] [[Missing]]\n\n![[Setup#Install Steps]]\n\n![[logo.png]]"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "guides", "Setup.md"), []byte(
+		"---\nconnie-page-id: \"42\"\n---\n# Setup\n\n## Install Steps\n\nRun it.\n\n## Other\n\nNot embedded."), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "assets", "logo.png"), []byte("png"), 0644))
+
+	results, err := ConvertDirectoryWithResults(dir, nil, nil)
+	assert.NoError(t, err)
+	if !assert.Len(t, results, 2) {
+		return
+	}
+
+	index := results[1]
+	assert.Equal(t, filepath.Join(dir, "index.md"), index.FilePath)
+	assert.Equal(t, []string{"assets/logo.png"}, index.ImagePaths)
+	assert.Equal(t, []string{"[[Missing]]"}, index.UnresolvedLinks)
+	assert.JSONEq(t, `{"type":"doc","content":[
+		{"type":"paragraph","content":[
+			{"type":"text","text":"install","marks":[{"type":"link","attrs":{"href":"/wiki/spaces/DOCS/pages/42#Install-Steps"}}]},
+			{"type":"text","text":" "},
+			{"type":"text","text":"Missing","marks":[{"type":"link","attrs":{"href":"Missing"}}]}
+		]},
+		{"type":"heading","attrs":{"level":2},"content":[{"type":"text","text":"Install Steps"}]},
+		{"type":"paragraph","content":[{"type":"text","text":"Run it."}]},
+		{"type":"mediaSingle","attrs":{"layout":"center"},"content":[{"type":"media","attrs":{"type":"external","url":"assets/logo.png"}}]}
+	]}`, index.ConvertedContent)
+}
+
+func TestConvertDirectoryWithOptions_RelativeLinks(t *testing.T) {
+	dir := t.TempDir()
+	docs := filepath.Join(dir, "docs")
+	assert.NoError(t, os.Mkdir(docs, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(docs, "a.md"), []byte("[b](b.md#code-samples) [out](../out.md)"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(docs, "b.md"), []byte("## Code Samples\n\n[a](./a.md)"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "out.md"), []byte("outside"), 0644))
+
+	client := &recordingClient{created: map[string]string{}, updated: map[string]string{}}
+	var warnings []string
+	options := DefaultConvertOptions()
+	options.BaseURL = "https://example.atlassian.net/wiki"
+	options.Warn = func(message string) { warnings = append(warnings, message) }
+
+	err := ConvertDirectoryWithOptions(docs, map[string]string{}, client, options, "DOCS")
+	assert.NoError(t, err)
+
+	// Pages are created with links to unpublished pages left as they are,
+	// then updated once every page has an ID.
+	assert.Contains(t, client.created["a"], `"href": "b.md#code-samples"`)
+	assert.Contains(t, client.updated["id-a"], `"href": "https://example.atlassian.net/wiki/spaces/DOCS/pages/id-b#Code-Samples"`)
+	assert.Contains(t, client.updated["id-a"], `"href": "../out.md"`)
+	assert.Contains(t, client.updated["id-b"], `"href": "https://example.atlassian.net/wiki/spaces/DOCS/pages/id-a"`)
+
+	assert.Equal(t, []string{filepath.Join(docs, "a.md") + ": link to ../out.md is outside the publish set"}, warnings)
+}
+
+func TestConvertDirectoryWithOptions_DataCenter(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a.md"), []byte("[b](b.md) and [[b#Setup]]\n\n![Diagram](diagram.png)"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "b.md"), []byte("---\nconnie-title: Setup guide\n---\n## Setup"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "diagram.png"), []byte("png"), 0644))
+
+	options := DefaultConvertOptions()
+	options.BaseURL = "/confluence"
+	options.Deployment = DataCenter
+
+	// Data Center pages are converted to storage format, which links to
+	// pages by title and to attachments by file name, so the links resolve
+	// before any page is published.
+	client := &recordingClient{created: map[string]string{}, updated: map[string]string{}}
+	err := ConvertDirectoryWithOptions(dir, nil, client, options, "OPS")
+	assert.NoError(t, err)
+	assert.Equal(t, `<p><ac:link><ri:page ri:content-title="Setup guide"/><ac:link-body>b</ac:link-body></ac:link> and `+
+		`<ac:link ac:anchor="Setup"><ri:page ri:content-title="Setup guide"/><ac:plain-text-link-body><![CDATA[b > Setup]]></ac:plain-text-link-body></ac:link></p>`+
+		`<p><ac:image ac:align="center" ac:layout="center" ac:alt="Diagram"><ri:attachment ri:filename="diagram.png"/></ac:image></p>`, client.created["a"])
+	assert.Equal(t, "<h2>Setup</h2>", client.created["Setup guide"])
+	assert.Equal(t, []string{"id-a/diagram.png"}, client.uploaded)
+	assert.Empty(t, client.updated)
+
+	options.Format = FormatADF
+	err = ConvertDirectoryWithOptions(dir, nil, client, options, "OPS")
+	assert.EqualError(t, err, "Confluence Data Center pages can only be published in storage format")
+}
+
+func TestConvertDirectoryWithResults_Storage(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a.md"), []byte("See [elsewhere](../other.md) and [[Missing]]."), 0644))
+
+	var warnings []string
+	options := DefaultConvertOptions()
+	options.DryRun = true
+	options.OutputDirectory = filepath.Join(dir, "out")
+	options.Format = FormatStorage
+	options.Warn = func(message string) { warnings = append(warnings, message) }
+
+	results, err := ConvertDirectoryWithResults(dir, nil, options)
+	assert.NoError(t, err)
+	assert.Equal(t, `<p>See <a href="../other.md">elsewhere</a> and <a href="Missing">Missing</a>.</p>`, results[0].ConvertedContent)
+	assert.Equal(t, []string{"../other.md", "[[Missing]]"}, results[0].UnresolvedLinks)
+	assert.Len(t, warnings, 2)
+
+	written, err := os.ReadFile(filepath.Join(dir, "out", "a.xhtml"))
+	assert.NoError(t, err)
+	assert.Equal(t, results[0].ConvertedContent, string(written))
+
+	options.Validate = true
+	_, err = ConvertDirectoryWithResults(dir, nil, options)
+	assert.EqualError(t, err, "pages in storage format cannot be checked against the ADF schema")
+
+	options.Validate = false
+	options.Format = "html"
+	_, err = ConvertDirectoryWithResults(dir, nil, options)
+	assert.EqualError(t, err, `unknown format "html", expected "adf" or "storage"`)
+}
+
+func TestConvertDirectoryWithOptions_Images(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "new.md"), []byte("![Diagram](diagram.png){width=400}\n\n![[diagram.png]]"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "old.md"), []byte("---\nconnie-page-id: \"7\"\n---\n![](diagram.png) ![](https://example.com/logo.png)"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "diagram.png"), []byte("png"), 0644))
+
+	client := &recordingClient{created: map[string]string{}, updated: map[string]string{}}
+	err := ConvertDirectoryWithOptions(dir, map[string]string{}, client, nil, "DOCS")
+	assert.NoError(t, err)
+
+	// Each page gets its own copy of the image, uploaded once.
+	assert.Equal(t, []string{"id-new/diagram.png", "7/diagram.png"}, client.uploaded)
+
+	// A new page is created before its images can be attached, then updated
+	// to reference them.
+	assert.Contains(t, client.created["new"], `"url": "diagram.png"`)
+	assert.NotContains(t, client.updated["id-new"], `"url": "diagram.png"`)
+	assert.Contains(t, client.updated["id-new"], `"id": "file-diagram.png"`)
+	assert.Contains(t, client.updated["id-new"], `"collection": "contentId-id-new"`)
+
+	// An existing page is only updated once its images are attached.
+	assert.Contains(t, client.updated["7"], `"collection": "contentId-7"`)
+	assert.Contains(t, client.updated["7"], `"url": "https://example.com/logo.png"`)
+}
+
+func TestConvertDirectoryWithOptions_MathImages(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the stand-in tex2svg is a shell script")
+	}
+
+	// A stand-in for tex2svg that writes the expression into the SVG.
+	bin := t.TempDir()
+	script := "#!/bin/sh\nfor last; do :; done\necho \"<svg><!-- $last --></svg>\"\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(bin, "tex2svg"), []byte(script), 0755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "math.md"), []byte("Inline $a^2$ and $b^2$\n\n$$ a^2 $$"), 0644))
+
+	client := &recordingClient{created: map[string]string{}, updated: map[string]string{}}
+	options := DefaultConvertOptions()
+	options.Render.Math = MathImage
+	assert.NoError(t, ConvertDirectoryWithOptions(dir, map[string]string{}, client, options, "DOCS"))
+
+	// Each formula, inline or display, is attached under its own name.
+	inlineA := mathtex.FileName("a^2", false)
+	inlineB := mathtex.FileName("b^2", false)
+	displayA := mathtex.FileName("a^2", true)
+	assert.Equal(t, []string{"id-math/" + inlineA, "id-math/" + inlineB, "id-math/" + displayA}, client.uploaded)
+
+	assert.JSONEq(t, `{"type":"doc","content":[
+		{"type":"paragraph","content":[
+			{"type":"text","text":"Inline "},
+			{"type":"mediaInline","attrs":{"type":"file","id":"file-`+inlineA+`","collection":"contentId-id-math","alt":"a^2"}},
+			{"type":"text","text":" and "},
+			{"type":"mediaInline","attrs":{"type":"file","id":"file-`+inlineB+`","collection":"contentId-id-math","alt":"b^2"}}
+		]},
+		{"type":"mediaSingle","attrs":{"layout":"center"},"content":[
+			{"type":"media","attrs":{"type":"file","id":"file-`+displayA+`","collection":"contentId-id-math","alt":"a^2"}}
+		]}
+	]}`, client.updated["id-math"])
+
+	// The rendered formulas are removed once the pages are published.
+	entries, err := os.ReadDir(tmp)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestConvertDirectoryWithOptions_EmbeddedNote(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "notes"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "index.md"), []byte("![[Shared]]"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a.md"), []byte("# A"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "notes", "Shared.md"), []byte("See [a](../a.md).\n\n![Pic](pic.png)"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "notes", "pic.png"), []byte("png"), 0644))
+
+	var warnings []string
+	options := DefaultConvertOptions()
+	options.Warn = func(message string) { warnings = append(warnings, message) }
+
+	t.Run("ADF", func(t *testing.T) {
+		warnings = nil
+		client := &recordingClient{created: map[string]string{}, updated: map[string]string{}}
+		assert.NoError(t, ConvertDirectoryWithOptions(dir, map[string]string{}, client, options, "DOCS"))
+
+		// The links and images of the note resolve from its own directory,
+		// and its images are attached to the page that embeds it.
+		assert.Empty(t, warnings)
+		assert.Contains(t, client.uploaded, "id-index/pic.png")
+		assert.Contains(t, client.updated["id-index"], `"href": "/wiki/spaces/DOCS/pages/id-a"`)
+		assert.Contains(t, client.updated["id-index"], `"id": "file-pic.png"`)
+	})
+
+	t.Run("Storage", func(t *testing.T) {
+		warnings = nil
+		storage := *options
+		storage.Format = FormatStorage
+		results, err := ConvertDirectoryWithResults(dir, nil, &storage)
+		assert.NoError(t, err)
+		assert.Empty(t, warnings)
+
+		var index *ConversionResult
+		for i := range results {
+			if filepath.Base(results[i].FilePath) == "index.md" {
+				index = &results[i]
+			}
+		}
+		if assert.NotNil(t, index) {
+			assert.Equal(t, []string{"notes/pic.png"}, index.ImagePaths)
+			assert.Contains(t, index.ConvertedContent, `<ri:page ri:content-title="a"/>`)
+			assert.Contains(t, index.ConvertedContent, `<ri:attachment ri:filename="pic.png"/>`)
+		}
+	})
+}
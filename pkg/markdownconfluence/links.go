@@ -0,0 +1,429 @@
+package markdownconfluence
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"go-markdown-confluence/internal/confluence"
+	"go-markdown-confluence/internal/converter"
+	"go-markdown-confluence/internal/mathtex"
+	"go-markdown-confluence/internal/parser"
+)
+
+// sourceFile is a Markdown file of a directory being converted.
+type sourceFile struct {
+	path     string                 // Path as found when walking the directory
+	front    map[string]interface{} // YAML frontmatter
+	body     string                 // Markdown without the frontmatter
+	bodyLine int                    // Number of lines before the body, taken by the frontmatter
+	anchors  map[string]string      // Confluence anchors of the headings by fragment
+	pageID   string                 // ID of the published page, once known
+
+	attachments map[string]*confluence.Attachment // Images uploaded to the page by absolute path
+}
+
+// sourceSet is the set of files of a directory being converted.
+type sourceSet struct {
+	files  []*sourceFile            // Markdown files in walk order
+	byPath map[string]*sourceFile   // Markdown files by absolute path
+	byName map[string][]*sourceFile // Markdown files by lower-case base name without extension
+	assets map[string][]string      // Absolute paths of other files by lower-case base name
+}
+
+// readSourceSet reads every Markdown file under dirPath and indexes the
+// other files, which wikilinks may embed.
+func readSourceSet(dirPath string) (*sourceSet, error) {
+	set := &sourceSet{
+		byPath: make(map[string]*sourceFile),
+		byName: make(map[string][]*sourceFile),
+		assets: make(map[string][]string),
+	}
+
+	err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("error accessing path %s: %w", path, err)
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("failed to resolve path %s: %w", path, err)
+		}
+
+		if filepath.Ext(path) != ".md" {
+			name := strings.ToLower(info.Name())
+			set.assets[name] = append(set.assets[name], absPath)
+			return nil
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read file %s: %w", path, err)
+		}
+
+		front, body := extractFrontmatter(string(content))
+		pageID, _ := front["connie-page-id"].(string)
+		file := &sourceFile{
+			path:        path,
+			front:       front,
+			body:        body,
+			bodyLine:    strings.Count(string(content[:len(content)-len(body)]), "\n"),
+			anchors:     headingAnchors(body),
+			pageID:      pageID,
+			attachments: make(map[string]*confluence.Attachment),
+		}
+
+		name := strings.ToLower(strings.TrimSuffix(info.Name(), filepath.Ext(path)))
+		set.files = append(set.files, file)
+		set.byPath[absPath] = file
+		set.byName[name] = append(set.byName[name], file)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error finding markdown files: %w", err)
+	}
+
+	return set, nil
+}
+
+// findNote returns the Markdown file a wikilink target names from the file
+// at path. Targets are matched by base name across the whole set; a target
+// with a folder, such as "guides/Setup", must also match the end of the
+// path. Among several matches, a file in the same folder as the linking file
+// wins, then the first one found.
+func (set *sourceSet) findNote(path, target string) *sourceFile {
+	target = strings.TrimSuffix(filepath.ToSlash(target), ".md")
+	name := strings.ToLower(target[strings.LastIndex(target, "/")+1:])
+
+	var found *sourceFile
+	for _, file := range set.byName[name] {
+		if !hasPathSuffix(strings.TrimSuffix(file.path, ".md"), target) {
+			continue
+		}
+		if filepath.Dir(file.path) == filepath.Dir(path) {
+			return file
+		}
+		if found == nil {
+			found = file
+		}
+	}
+	return found
+}
+
+// findAsset returns the absolute path of the file an ![[embed]] names from
+// the file at path, matched like findNote.
+func (set *sourceSet) findAsset(path, target string) (string, bool) {
+	target = filepath.ToSlash(target)
+	name := strings.ToLower(target[strings.LastIndex(target, "/")+1:])
+
+	found := ""
+	for _, asset := range set.assets[name] {
+		if !hasPathSuffix(asset, target) {
+			continue
+		}
+		if absDir, err := filepath.Abs(filepath.Dir(path)); err == nil && filepath.Dir(asset) == absDir {
+			return asset, true
+		}
+		if found == "" {
+			found = asset
+		}
+	}
+	return found, found != ""
+}
+
+// hasPathSuffix reports whether the slash-separated suffix matches the last
+// elements of path, ignoring case.
+func hasPathSuffix(path, suffix string) bool {
+	path = strings.ToLower(filepath.ToSlash(path))
+	suffix = strings.ToLower(suffix)
+	return path == suffix || strings.HasSuffix(path, "/"+suffix)
+}
+
+// headingAnchors returns the Confluence anchors of the headings of a Markdown
+// document, keyed by the fragments links may use for them.
+func headingAnchors(markdown string) map[string]string {
+	markdown = stripObsidianComments(markdown)
+	document := parser.NewMarkdownParser().Parse(markdown)
+	if document == nil {
+		return nil
+	}
+	return converter.HeadingAnchors(document, []byte(markdown))
+}
+
+// linkResolver rewrites links between the Markdown files of a directory to
+// the URLs of the pages they are published to.
+type linkResolver struct {
+	set        *sourceSet        // Files of the publish set
+	mapping    map[string]string // Target paths of the files, which their page titles derive from
+	baseURL    string            // Confluence URL that page paths are appended to
+	space      string            // Space key of the published pages
+	deployment Deployment        // Kind of site, which decides the form of page URLs
+	formulas   string            // Temporary directory of the rendered math formulas, once one is rendered
+}
+
+// renderFormula renders a LaTeX expression to an SVG file in the formula
+// directory of the conversion, which is uploaded like the other images.
+func (lr *linkResolver) renderFormula(latex string, display bool) (string, error) {
+	if lr.formulas == "" {
+		dir, err := os.MkdirTemp("", "mathtex")
+		if err != nil {
+			return "", err
+		}
+		lr.formulas = dir
+	}
+	return mathtex.RenderFormula(latex, display, lr.formulas)
+}
+
+// imageFile returns the absolute path of an image that the file at path
+// refers to: a local image, or a formula rendered for the file.
+func (lr *linkResolver) imageFile(path, src string) (string, bool) {
+	if lr.formulas != "" && filepath.Dir(src) == lr.formulas {
+		if info, err := os.Stat(src); err == nil && !info.IsDir() {
+			return src, true
+		}
+	}
+	return localImage(path, src)
+}
+
+// cleanup removes the formulas rendered during the conversion.
+func (lr *linkResolver) cleanup() {
+	if lr != nil && lr.formulas != "" {
+		os.RemoveAll(lr.formulas)
+		lr.formulas = ""
+	}
+}
+
+// resolve returns the href of a link from the file at path. pending is set
+// for links to files of the publish set that have no page yet, and outside
+// for links to Markdown files outside the set; both keep their destination.
+func (lr *linkResolver) resolve(path, destination string) (href string, pending, outside bool) {
+	target, fragment, ok := markdownLinkTarget(path, destination)
+	if !ok {
+		return destination, false, false
+	}
+
+	file, ok := lr.set.byPath[target]
+	if !ok {
+		return destination, false, true
+	}
+	if file.pageID == "" {
+		return destination, true, false
+	}
+
+	href = lr.pageURL(file.pageID)
+	if fragment != "" {
+		if anchor, ok := file.anchors[fragment]; ok {
+			fragment = anchor
+		}
+		href += "#" + fragment
+	}
+	return href, false, false
+}
+
+// resolvePage returns the title of the page a link from the file at path
+// points to, and the anchor of its fragment. ok is false for links to
+// anything but a file of the publish set.
+func (lr *linkResolver) resolvePage(path, destination string) (title, anchor string, ok bool) {
+	target, fragment, ok := markdownLinkTarget(path, destination)
+	if !ok {
+		return "", "", false
+	}
+	file, ok := lr.set.byPath[target]
+	if !ok {
+		return "", "", false
+	}
+
+	if fragment != "" {
+		anchor = fragment
+		if a, ok := file.anchors[fragment]; ok {
+			anchor = a
+		}
+	}
+	_, title = pageTitle(file, lr.mapping)
+	return title, anchor, true
+}
+
+// resolveWikiPage returns the title of the page a [[wikilink]] from the
+// file at path points to, and the anchor of its fragment. ok is false for
+// targets that are not notes of the publish set.
+func (lr *linkResolver) resolveWikiPage(path, target, fragment string) (title, anchor string, ok bool) {
+	if ext := filepath.Ext(target); ext != "" && !strings.EqualFold(ext, ".md") {
+		return "", "", false
+	}
+	file := lr.set.findNote(path, target)
+	if file == nil {
+		return "", "", false
+	}
+
+	if fragment != "" {
+		anchor = converter.FragmentAnchor(fragment, file.anchors)
+	}
+	_, title = pageTitle(file, lr.mapping)
+	return title, anchor, true
+}
+
+// resolveWikiLink returns the href of a [[wikilink]] from the file at path,
+// or, for targets with a file extension other than .md, the path of the
+// file relative to the linking file. ok is false when the target has no
+// page yet (pending) or is not part of the set (outside).
+func (lr *linkResolver) resolveWikiLink(path, target, fragment string) (href string, ok, pending, outside bool) {
+	if ext := filepath.Ext(target); ext != "" && !strings.EqualFold(ext, ".md") {
+		asset, ok := lr.set.findAsset(path, target)
+		if !ok {
+			return "", false, false, true
+		}
+		absDir, _ := filepath.Abs(filepath.Dir(path))
+		rel, err := filepath.Rel(absDir, asset)
+		if err != nil {
+			return "", false, false, true
+		}
+		return filepath.ToSlash(rel), true, false, false
+	}
+
+	file := lr.set.findNote(path, target)
+	if file == nil {
+		return "", false, false, true
+	}
+	if file.pageID == "" {
+		return "", false, true, false
+	}
+
+	href = lr.pageURL(file.pageID)
+	if fragment != "" {
+		href += "#" + converter.FragmentAnchor(fragment, file.anchors)
+	}
+	return href, true, false, false
+}
+
+// transclude returns the Markdown that an ![[embed]] of target from the file
+// at path is replaced with: the whole note, or the heading section or block
+// named by fragment. notePath is the path of the note, which the links and
+// images of the Markdown are relative to.
+func (lr *linkResolver) transclude(path, target, fragment string) (markdown, notePath string, ok bool) {
+	file := lr.set.findNote(path, target)
+	if file == nil {
+		return "", "", false
+	}
+	body := stripObsidianComments(file.body)
+	switch id, isBlock := strings.CutPrefix(fragment, "^"); {
+	case fragment == "":
+		markdown, ok = body, true
+	case isBlock:
+		markdown, ok = noteBlock(body, id)
+	default:
+		markdown, ok = noteSection(body, fragment)
+	}
+	return markdown, file.path, ok
+}
+
+var (
+	// atxHeading matches an ATX heading line, capturing its level and text.
+	atxHeading = regexp.MustCompile(`^ {0,3}(#{1,6})[ \t]+(.*?)(?:[ \t]+#+)?[ \t]*$`)
+	// headingAttribute matches the {#id} attribute that ends a heading.
+	headingAttribute = regexp.MustCompile(`[ \t]*\{#[^}]*\}$`)
+)
+
+// noteSection returns the section of a note that starts at the heading
+// named by heading and ends before the next heading of the same or a higher
+// level.
+func noteSection(markdown, heading string) (string, bool) {
+	lines := strings.Split(markdown, "\n")
+	slug := converter.HeadingSlug(heading)
+
+	start, level := -1, 0
+	fenced := false
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") || strings.HasPrefix(strings.TrimSpace(line), "~~~") {
+			fenced = !fenced
+			continue
+		}
+		m := atxHeading.FindStringSubmatch(line)
+		if fenced || m == nil {
+			continue
+		}
+		if start >= 0 && len(m[1]) <= level {
+			return strings.Join(lines[start:i], "\n"), true
+		}
+		text := headingAttribute.ReplaceAllString(m[2], "")
+		if start < 0 && converter.HeadingSlug(text) == slug {
+			start, level = i, len(m[1])
+		}
+	}
+	if start < 0 {
+		return "", false
+	}
+	return strings.Join(lines[start:], "\n"), true
+}
+
+// noteBlock returns the paragraph of a note that ends with the block marker
+// " ^id".
+func noteBlock(markdown, id string) (string, bool) {
+	marker := regexp.MustCompile(`[ \t]\^` + regexp.QuoteMeta(id) + `[ \t]*$`)
+	lines := strings.Split(markdown, "\n")
+	for i, line := range lines {
+		if !marker.MatchString(line) {
+			continue
+		}
+		start := i
+		for start > 0 && strings.TrimSpace(lines[start-1]) != "" {
+			start--
+		}
+		return strings.Join(lines[start:i+1], "\n"), true
+	}
+	return "", false
+}
+
+// markdownLinkTarget returns the absolute path and fragment of a relative
+// link to a Markdown file. ok is false for links to anything else.
+func markdownLinkTarget(path, destination string) (target, fragment string, ok bool) {
+	u, err := url.Parse(destination)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || strings.HasPrefix(u.Path, "/") {
+		return "", "", false
+	}
+	if !strings.EqualFold(filepath.Ext(u.Path), ".md") {
+		return "", "", false
+	}
+
+	target, err = filepath.Abs(filepath.Join(filepath.Dir(path), filepath.FromSlash(u.Path)))
+	if err != nil {
+		return "", "", false
+	}
+	return target, u.Fragment, true
+}
+
+// localImage returns the absolute path of an image that a file at path
+// refers to by a relative path. ok is false for URLs, absolute paths and
+// files that do not exist.
+func localImage(path, src string) (string, bool) {
+	u, err := url.Parse(src)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || strings.HasPrefix(u.Path, "/") {
+		return "", false
+	}
+
+	absPath, err := filepath.Abs(filepath.Join(filepath.Dir(path), filepath.FromSlash(u.Path)))
+	if err != nil {
+		return "", false
+	}
+	if info, err := os.Stat(absPath); err != nil || info.IsDir() {
+		return "", false
+	}
+	return absPath, true
+}
+
+// pageURL returns the URL of a published page. Confluence Server and Data
+// Center have no /spaces/<key>/pages/<id> URLs, so their pages are linked by
+// ID through viewpage.action.
+func (lr *linkResolver) pageURL(pageID string) string {
+	baseURL := strings.TrimSuffix(lr.baseURL, "/")
+	if lr.deployment == DataCenter {
+		return baseURL + "/pages/viewpage.action?pageId=" + url.QueryEscape(pageID)
+	}
+	return baseURL + "/spaces/" + url.PathEscape(lr.space) + "/pages/" + pageID
+}